@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/autostart"
+	"github.com/automazeio/vibeproxy/internal/config"
+	"github.com/automazeio/vibeproxy/internal/paths"
+)
+
+// newAutostart creates an autostart manager that launches VibeProxy with
+// the locations this instance uses
+func newAutostart(dirs *paths.Paths, settings *config.Settings) *autostart.Manager {
+	return autostart.NewManager(autostart.Config{
+		Env: dirs.Env(),
+		// Leave time to drain requests and stop the backend
+		StopTimeout: settings.Shutdown.DrainTimeout + 15*time.Second,
+	}, nil)
+}
+
+// runAutostart shows, enables or disables autostart, or prints the systemd
+// unit it would install
+func runAutostart(c *cli, args []string) error {
+	fs := c.flagSet("autostart")
+	mechanism := fs.String("mechanism", "", "desktop (XDG autostart entry) or systemd (user service); enable keeps the active one by default")
+	positional, err := parse(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	action := "status"
+	if len(positional) == 1 {
+		action = positional[0]
+	}
+
+	dirs, settings, err := c.resolve()
+	if err != nil {
+		return err
+	}
+	if err := dirs.ResolveBinary(c.paths.Binary, settings.Backend.Binary); err != nil {
+		// The unit still works when the binary is found at startup
+		dirs.Binary = paths.Location{}
+	}
+	manager := newAutostart(dirs, settings)
+
+	var status *autostart.Status
+	switch action {
+	case "status":
+		status = manager.Status()
+	case "enable":
+		if status, err = manager.Enable(*mechanism); err != nil {
+			return err
+		}
+	case "disable":
+		if err := manager.Disable(); err != nil {
+			return err
+		}
+		status = manager.Status()
+	case "unit":
+		unit, err := manager.Unit()
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.WriteString(unit)
+		return err
+	default:
+		return usageError("usage: vibeproxy autostart [status|enable|disable|unit] [--mechanism desktop|systemd]")
+	}
+
+	if c.json {
+		return printJSON(status)
+	}
+	if !status.Enabled {
+		fmt.Println("autostart  disabled")
+	} else {
+		fmt.Printf("autostart  %s\n", status.Mechanism)
+	}
+	if status.Unit != nil {
+		fmt.Printf("unit       %s (%s, %s/%s)\n", status.Unit.Path, orDash(status.Unit.UnitFileState), orDash(status.Unit.ActiveState), orDash(status.Unit.SubState))
+	}
+	fmt.Printf("available  %s\n", strings.Join(status.Available, ", "))
+	for _, hint := range status.Hints {
+		fmt.Println(hint)
+	}
+	return nil
+}
+
+// orDash returns s, or "-" when it is empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/automazeio/vibeproxy/internal/certs"
+	"github.com/automazeio/vibeproxy/internal/config"
+	"github.com/automazeio/vibeproxy/internal/paths"
+)
+
+// tlsFiles returns the certificate and key listeners with tls serve:
+// tls.cert and tls.key, or the ones `vibeproxy cert` generates
+func tlsFiles(dirs *paths.Paths, settings *config.Settings) (string, string) {
+	if settings.TLS.Cert != "" {
+		return settings.TLS.Cert, settings.TLS.Key
+	}
+	return filepath.Join(dirs.TLS(), certs.ServerFile), filepath.Join(dirs.TLS(), certs.ServerKeyFile)
+}
+
+// runCert creates the local CA and a server certificate, or a client
+// certificate for mTLS
+func runCert(c *cli, args []string) error {
+	fs := c.flagSet("cert")
+	hosts := fs.String("host", "", "comma-separated names and IP addresses the server certificate is valid for (default: localhost, this hostname and its addresses)")
+	force := fs.Bool("force", false, "replace an existing certificate")
+	positional, err := parse(fs, args, 0, 2)
+	if err != nil {
+		return err
+	}
+	client := ""
+	switch {
+	case len(positional) == 0:
+	case len(positional) == 2 && positional[0] == "client":
+		client = positional[1]
+	default:
+		return usageError("usage: vibeproxy cert [--host names] [--force] | vibeproxy cert client <name>")
+	}
+
+	dirs, err := paths.Resolve(c.paths)
+	if err != nil {
+		return err
+	}
+	ca, created, err := certs.LoadOrCreateCA(dirs.TLS())
+	if err != nil {
+		return fmt.Errorf("local CA: %w", err)
+	}
+
+	var certPath, keyPath string
+	var names []string
+	if client != "" {
+		if _, err := os.Stat(filepath.Join(dirs.TLS(), certs.ClientsDir, client+".crt")); err == nil && !*force {
+			return fmt.Errorf("client certificate %q exists, use --force to replace it", client)
+		}
+		certPath, keyPath, err = ca.IssueClient(client)
+	} else {
+		if _, err := os.Stat(filepath.Join(dirs.TLS(), certs.ServerFile)); err == nil && !*force {
+			return fmt.Errorf("%s exists, use --force to replace it", filepath.Join(dirs.TLS(), certs.ServerFile))
+		}
+		names = certs.DefaultHosts()
+		if *hosts != "" {
+			names = strings.Split(*hosts, ",")
+			for i := range names {
+				names[i] = strings.TrimSpace(names[i])
+			}
+		}
+		certPath, keyPath, err = ca.IssueServer(names)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		out := map[string]interface{}{"ca": ca.CertPath(), "caCreated": created, "cert": certPath, "key": keyPath}
+		if names != nil {
+			out["hosts"] = names
+		}
+		return printJSON(out)
+	}
+
+	if created {
+		fmt.Printf("Created local CA      %s\n", ca.CertPath())
+	}
+	fmt.Printf("Certificate           %s\n", certPath)
+	fmt.Printf("Key                   %s\n", keyPath)
+	if client != "" {
+		fmt.Printf("\nGive both files to the client, e.g.\n  curl --cacert %s --cert %s --key %s https://<host>:%d/v1/models\n",
+			ca.CertPath(), certPath, keyPath, thinkingProxyPort)
+		fmt.Printf("and require client certificates with tls.client-ca: %s in vibeproxy.yaml.\n", ca.CertPath())
+		return nil
+	}
+	fmt.Printf("Valid for             %s\n", strings.Join(names, ", "))
+	fmt.Printf("\nServe it with listen.proxy.tls: true (and listen.ui.tls: true) in vibeproxy.yaml;\n")
+	fmt.Printf("running instances pick up a replaced certificate without a restart.\n")
+	fmt.Printf("Clients trust %s, e.g.\n  curl --cacert %s https://<host>:%d/v1/models\n", ca.CertPath(), ca.CertPath(), thinkingProxyPort)
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/automazeio/vibeproxy/internal/auth"
+	"github.com/automazeio/vibeproxy/internal/certs"
+	"github.com/automazeio/vibeproxy/internal/client"
+	"github.com/automazeio/vibeproxy/internal/config"
+	"github.com/automazeio/vibeproxy/internal/logging"
+	"github.com/automazeio/vibeproxy/internal/paths"
+)
+
+// command is one `vibeproxy <name>` subcommand
+type command struct {
+	name    string
+	args    string // usage after the name
+	summary string
+	run     func(c *cli, args []string) error
+}
+
+// commands in the order `vibeproxy help` lists them
+var commands = []command{
+	{"serve", "", "Start the proxy, backend and web UI (the default)", runServe},
+	{"status", "", "Show whether VibeProxy is running and which providers are connected", runStatus},
+	{"login", "<provider> [--email]", "Log in to claude, codex, gemini or qwen", runLogin},
+	{"logout", "<provider> [--account]", "Remove a provider account", runLogout},
+	{"accounts", "", "List connected accounts", runAccounts},
+	{"logs", "[-f] [--level] [--component]", "Print recent log entries, -f to follow", runLogs},
+	{"config", "get [key] | set <key> <value>", "Read or change config.yaml (backend.*) and vibeproxy.yaml (settings.*)", runConfig},
+	{"autostart", "[status|enable|disable|unit] [--mechanism]", "Start VibeProxy at login via the desktop session or a systemd user service", runAutostart},
+	{"cert", "[client <name>] [--host] [--force]", "Create a local CA and a server certificate for TLS, or a client certificate for mTLS", runCert},
+	{"paths", "", "Print where config, data and cli-proxy-api were found", runPaths},
+	{"version", "", "Print the version", runVersion},
+}
+
+// envUISocket sets --ui-socket
+const envUISocket = "VIBEPROXY_UI_SOCKET"
+
+// cli holds the flags every command accepts
+type cli struct {
+	paths    paths.Options
+	uiSocket string
+	json     bool
+}
+
+// exitError ends the process with a specific status after printing message
+type exitError struct {
+	code    int
+	message string
+}
+
+func (e *exitError) Error() string {
+	return e.message
+}
+
+// usageError reports a command used wrongly; it exits with status 2
+func usageError(format string, args ...interface{}) error {
+	return &exitError{code: 2, message: fmt.Sprintf(format, args...)}
+}
+
+// run dispatches os.Args to a command and returns the exit status
+func run(args []string) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		printUsage(os.Stdout)
+		return 0
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "vibeproxy: unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	// Commands other than serve only log problems
+	if name != "serve" {
+		logging.Setup(logging.Options{Level: "warn"})
+	}
+
+	err := cmd.run(&cli{}, args)
+	var exitErr *exitError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, &exitErr):
+		if exitErr.message != "" {
+			fmt.Fprintln(os.Stderr, "vibeproxy:", exitErr.message)
+		}
+		return exitErr.code
+	default:
+		fmt.Fprintln(os.Stderr, "vibeproxy:", err)
+		return 1
+	}
+}
+
+// printUsage lists the commands
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: vibeproxy <command> [flags]\n\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun 'vibeproxy <command> -h' for the flags of a command.\n")
+}
+
+// flagSet returns the flags for a command, including the shared ones
+func (c *cli) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("vibeproxy "+name, flag.ContinueOnError)
+	fs.StringVar(&c.paths.ConfigDir, "config-dir", "", "directory with config.yaml and vibeproxy.yaml (or $"+paths.EnvConfigDir+")")
+	fs.StringVar(&c.paths.DataDir, "data-dir", "", "directory for recordings and other data (or $"+paths.EnvDataDir+")")
+	fs.StringVar(&c.paths.Binary, "cli-proxy-api", "", "path to the cli-proxy-api binary (or $"+paths.EnvBinary+")")
+	fs.StringVar(&c.uiSocket, "ui-socket", os.Getenv(envUISocket), "serve the web UI on this Unix socket instead of port 8319 (or $"+envUISocket+")")
+	if name != "serve" {
+		fs.BoolVar(&c.json, "json", false, "print JSON")
+	}
+	return fs
+}
+
+// parse parses flags given anywhere among args and returns the other
+// arguments, which must number between min and max
+func parse(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, &exitError{code: 2}
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if len(positional) < min || len(positional) > max {
+		return nil, usageError("wrong number of arguments, see '%s -h'", fs.Name())
+	}
+	return positional, nil
+}
+
+// client returns a client for the local UI server: --ui-socket, then the
+// socket in vibeproxy.yaml when the UI has no plain TCP port, then port 8319
+// over https when tls is on, trusting the configured certificate
+func (c *cli) client() *client.Client {
+	if c.uiSocket != "" {
+		return client.NewUnix(c.uiSocket)
+	}
+	dirs, settings, err := c.resolve()
+	if err != nil {
+		return client.New(fmt.Sprintf("http://127.0.0.1:%d", uiServerPort))
+	}
+	ui := settings.Listen.UI
+	if ui.Socket != "" && (!ui.TCP || ui.TLS) {
+		return client.NewUnix(ui.Socket)
+	}
+	address := net.JoinHostPort(uiHost(ui), strconv.Itoa(uiServerPort))
+	cl := client.New("http://" + address)
+	if ui.TLS {
+		certPath, _ := tlsFiles(dirs, settings)
+		if tlsConfig, err := certs.PinnedConfig(certPath); err == nil {
+			cl = client.NewTLS("https://"+address, tlsConfig)
+		}
+	}
+	// Needed when ui-auth.require-login is set
+	if token, err := uiToken(dirs, settings, false); err == nil {
+		cl.SetToken(token)
+	}
+	return cl
+}
+
+// resolve finds the files of the local installation for commands that work
+// without a running instance
+func (c *cli) resolve() (*paths.Paths, *config.Settings, error) {
+	dirs, err := paths.Resolve(c.paths)
+	if err != nil {
+		return nil, nil, err
+	}
+	settings, err := config.LoadSettings(dirs.Settings())
+	if err != nil {
+		return nil, nil, err
+	}
+	return dirs, settings, nil
+}
+
+// authManager returns an auth manager for the auth-dir of the local
+// config.yaml, or CLIProxyAPI's default when there is none
+func (c *cli) authManager() (*auth.Manager, error) {
+	dirs, err := paths.Resolve(c.paths)
+	if err != nil {
+		return nil, err
+	}
+	if !fileExists(dirs.Config()) {
+		return auth.NewManager(""), nil
+	}
+	backend, err := config.LoadCLIProxyAPIConfig(dirs.Config())
+	if err != nil {
+		return nil, err
+	}
+	dir, err := backend.AuthDirPath()
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewManager(dir), nil
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printJSONLine writes v to stdout as one line of JSON, for streams
+func printJSONLine(v interface{}) error {
+	return json.NewEncoder(os.Stdout).Encode(v)
+}
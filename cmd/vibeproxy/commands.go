@@ -0,0 +1,581 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/automazeio/vibeproxy/internal/auth"
+	"github.com/automazeio/vibeproxy/internal/client"
+	"github.com/automazeio/vibeproxy/internal/config"
+	"github.com/automazeio/vibeproxy/internal/logging"
+	"github.com/automazeio/vibeproxy/internal/paths"
+	"github.com/automazeio/vibeproxy/internal/process"
+	"github.com/automazeio/vibeproxy/internal/version"
+)
+
+// providers in display order
+var providers = []string{"claude", "codex", "gemini", "qwen"}
+
+// runServe runs VibeProxy in the foreground
+func runServe(c *cli, args []string) error {
+	fs := c.flagSet("serve")
+	var opts serveOptions
+	fs.BoolVar(&opts.headless, "headless", false, "do not open a browser, for servers and containers")
+	fs.BoolVar(&opts.noUI, "no-ui", false, "do not start the web UI")
+	fs.StringVar(&opts.pidFile, "pid-file", "", "write the process ID to this file while running")
+	fs.StringVar(&opts.logFile, "log-file", "", "also write logs to this file, overriding logging.file")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	opts.paths, opts.uiSocket = c.paths, c.uiSocket
+	if code := serve(opts); code != 0 {
+		return &exitError{code: code}
+	}
+	return nil
+}
+
+// runStatus prints the running instance's status, or the accounts on disk
+// and exit status 3 when VibeProxy is not running
+func runStatus(c *cli, args []string) error {
+	if _, err := parse(c.flagSet("status"), args, 0, 0); err != nil {
+		return err
+	}
+
+	status, err := c.client().Status()
+	running := err == nil
+	if err != nil {
+		if !errors.Is(err, client.ErrNotRunning) {
+			return err
+		}
+		authManager, err := c.authManager()
+		if err != nil {
+			return err
+		}
+		if err := authManager.CheckAuthStatus(); err != nil {
+			return err
+		}
+		status = &client.Status{Services: authManager.GetStatus(), Accounts: authManager.Accounts()}
+	}
+
+	if c.json {
+		err = printJSON(map[string]interface{}{
+			"running":  running,
+			"version":  status.Version,
+			"backend":  status.Server.Running,
+			"services": status.Services,
+			"accounts": status.Accounts,
+		})
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		if running {
+			fmt.Fprintf(w, "vibeproxy\trunning (%s)\n", status.Version)
+			fmt.Fprintf(w, "backend\t%s\n", runningText(status.Server.Running))
+		} else {
+			fmt.Fprintf(w, "vibeproxy\tnot running\n")
+		}
+		for _, provider := range providers {
+			service := status.Services[provider]
+			fmt.Fprintf(w, "%s\t%s\n", provider, service.StatusText())
+		}
+		err = w.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	if !running {
+		return &exitError{code: 3}
+	}
+	return nil
+}
+
+// runningText describes a running flag
+func runningText(running bool) string {
+	if running {
+		return "running"
+	}
+	return "stopped"
+}
+
+// runLogin runs a provider's browser login, through the running instance if
+// there is one
+func runLogin(c *cli, args []string) error {
+	fs := c.flagSet("login")
+	email := fs.String("email", "", "account email (required for qwen)")
+	positional, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	provider := strings.ToLower(positional[0])
+	command, err := process.ParseAuthCommand(provider)
+	if err != nil {
+		return usageError("%v", err)
+	}
+	if command == process.QwenLogin && *email == "" {
+		return usageError("qwen login requires --email")
+	}
+
+	if !c.json {
+		fmt.Printf("Logging in to %s, follow the steps in your browser...\n", provider)
+	}
+	result, err := c.client().Login(provider, *email)
+	if errors.Is(err, client.ErrNotRunning) {
+		result, err = c.loginOffline(command, *email)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		if err := printJSON(result); err != nil {
+			return err
+		}
+	} else if result.Message != "" {
+		fmt.Println(result.Message)
+	}
+	if !result.Success {
+		return &exitError{code: 1, message: result.Error}
+	}
+	return nil
+}
+
+// loginOffline runs cli-proxy-api's login flow directly
+func (c *cli) loginOffline(command process.AuthCommand, email string) (*client.LoginResult, error) {
+	dirs, settings, err := c.resolve()
+	if err != nil {
+		return nil, err
+	}
+	if err := dirs.Create(); err != nil {
+		return nil, err
+	}
+	if err := dirs.ResolveBinary(c.paths.Binary, settings.Backend.Binary); err != nil {
+		return nil, err
+	}
+	if err := process.EnsureConfig(dirs.Config(), paths.Template()); err != nil {
+		return nil, err
+	}
+
+	manager := process.NewManager(dirs.Binary.Path, dirs.Config())
+	success, message, err := manager.RunAuthCommand(command, email)
+	result := &client.LoginResult{Success: success, Message: message}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result, nil
+}
+
+// runLogout removes one account of a provider, through the running instance
+// if there is one
+func runLogout(c *cli, args []string) error {
+	fs := c.flagSet("logout")
+	account := fs.String("account", "", "account ID or email, needed when the provider has several")
+	positional, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	provider := strings.ToLower(positional[0])
+	if _, err := process.ParseAuthCommand(provider); err != nil {
+		return usageError("%v", err)
+	}
+
+	cl := c.client()
+	var authManager *auth.Manager
+	status, err := cl.Status()
+	running := err == nil
+	accounts := []auth.Account(nil)
+	switch {
+	case running:
+		accounts = status.Accounts
+	case errors.Is(err, client.ErrNotRunning):
+		if authManager, err = c.authManager(); err != nil {
+			return err
+		}
+		if err := authManager.CheckAuthStatus(); err != nil {
+			return err
+		}
+		accounts = authManager.Accounts()
+	default:
+		return err
+	}
+
+	var matches []string
+	for _, a := range accounts {
+		if a.Type == provider && (*account == "" || a.Matches(*account)) {
+			matches = append(matches, a.ID)
+		}
+	}
+	switch {
+	case len(matches) == 0 && *account != "":
+		return fmt.Errorf("no %s account %q", provider, *account)
+	case len(matches) == 0:
+		return fmt.Errorf("no %s account", provider)
+	case len(matches) > 1 && *account == "":
+		return usageError("%s has %d accounts, choose one with --account: %s", provider, len(matches), strings.Join(matches, ", "))
+	}
+
+	var removed auth.Account
+	if running {
+		removed, err = cl.Logout(provider, *account)
+	} else {
+		removed, err = authManager.RemoveAccount(provider, *account)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return printJSON(removed)
+	}
+	if removed.Email != "" {
+		fmt.Printf("Removed %s account %s (%s)\n", provider, removed.ID, removed.Email)
+	} else {
+		fmt.Printf("Removed %s account %s\n", provider, removed.ID)
+	}
+	return nil
+}
+
+// runAccounts lists the accounts in the auth directory
+func runAccounts(c *cli, args []string) error {
+	if _, err := parse(c.flagSet("accounts"), args, 0, 0); err != nil {
+		return err
+	}
+
+	var accounts []auth.Account
+	status, err := c.client().Status()
+	switch {
+	case err == nil:
+		accounts = status.Accounts
+	case errors.Is(err, client.ErrNotRunning):
+		authManager, err := c.authManager()
+		if err != nil {
+			return err
+		}
+		if err := authManager.CheckAuthStatus(); err != nil {
+			return err
+		}
+		accounts = authManager.Accounts()
+	default:
+		return err
+	}
+
+	if c.json {
+		if accounts == nil {
+			accounts = []auth.Account{}
+		}
+		return printJSON(accounts)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tPROVIDER\tEMAIL\tEXPIRES\n")
+	for _, a := range accounts {
+		email, expires := a.Email, "-"
+		if email == "" {
+			email = "-"
+		}
+		if !a.Expired.IsZero() {
+			expires = a.Expired.Local().Format("2006-01-02 15:04")
+			if a.Expired.Before(time.Now()) {
+				expires += " (expired)"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a.ID, a.Type, email, expires)
+	}
+	return w.Flush()
+}
+
+// runLogs prints the running instance's recent log entries and, with -f,
+// keeps polling for new ones until interrupted
+func runLogs(c *cli, args []string) error {
+	fs := c.flagSet("logs")
+	follow := fs.Bool("f", false, "keep printing new entries")
+	level := fs.String("level", "info", "minimum level: debug, info, warn or error")
+	component := fs.String("component", "", "only entries from this component, e.g. Proxy")
+	limit := fs.Int("limit", 100, "number of recent entries to print first")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if _, err := logging.ParseLevel(*level); err != nil {
+		return usageError("%v", err)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	cl := c.client()
+	var after uint64
+	n := *limit
+	for {
+		entries, err := cl.Logs(n, *level, *component, after)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if c.json {
+				err = printJSONLine(entry)
+			} else {
+				_, err = fmt.Println(formatEntry(entry))
+			}
+			if err != nil {
+				return err
+			}
+			after = entry.Seq
+		}
+		if !*follow {
+			return nil
+		}
+
+		// Later polls return everything since the last entry
+		n = 0
+		select {
+		case <-interrupt:
+			return nil
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// formatEntry renders a log entry like the text log format
+func formatEntry(entry logging.Entry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s", entry.Time.Local().Format("15:04:05.000"), entry.Level)
+	if entry.Component != "" {
+		fmt.Fprintf(&b, " [%s]", entry.Component)
+	}
+	b.WriteString(" " + entry.Message)
+
+	keys := make([]string, 0, len(entry.Attrs))
+	for key := range entry.Attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%v", key, entry.Attrs[key])
+	}
+	return b.String()
+}
+
+// runConfig reads (get) or changes (set) config.yaml and vibeproxy.yaml,
+// through the running instance if there is one
+func runConfig(c *cli, args []string) error {
+	fs := c.flagSet("config")
+	positional, err := parse(fs, args, 1, 3)
+	if err != nil {
+		return err
+	}
+	switch {
+	case positional[0] == "get" && len(positional) <= 2:
+		key := ""
+		if len(positional) == 2 {
+			key = positional[1]
+		}
+		return c.configGet(key)
+	case positional[0] == "set" && len(positional) == 3:
+		return c.configSet(positional[1], positional[2])
+	}
+	return usageError("usage: vibeproxy config get [key] | set <key> <value>")
+}
+
+// configGet prints one key, or both files without a key
+func (c *cli) configGet(key string) error {
+	values, err := c.configValues()
+	if err != nil {
+		return err
+	}
+
+	var value interface{} = values
+	if key != "" {
+		for _, part := range strings.Split(key, ".") {
+			section, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("unknown key %q", key)
+			}
+			if value, ok = section[part]; !ok {
+				return fmt.Errorf("unknown key %q", key)
+			}
+		}
+	}
+
+	if c.json {
+		return printJSON(value)
+	}
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		data, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	case nil:
+		fmt.Println()
+	default:
+		fmt.Println(value)
+	}
+	return nil
+}
+
+// configValues returns both files keyed backend and settings, with secrets
+// masked
+func (c *cli) configValues() (map[string]interface{}, error) {
+	response, err := c.client().Config()
+	if err == nil {
+		return map[string]interface{}{
+			"backend":  response["backend"],
+			"settings": response["settings"],
+		}, nil
+	}
+	if !errors.Is(err, client.ErrNotRunning) {
+		return nil, err
+	}
+
+	dirs, settings, err := c.resolve()
+	if err != nil {
+		return nil, err
+	}
+	// serve writes config.yaml on first start; until then show the defaults
+	backend := config.DefaultCLIProxyAPIConfig()
+	if fileExists(dirs.Config()) {
+		if backend, err = config.LoadCLIProxyAPIConfig(dirs.Config()); err != nil {
+			return nil, err
+		}
+	}
+	backendValues, err := backend.MaskedValues()
+	if err != nil {
+		return nil, err
+	}
+	settingsValues, err := settings.MaskedValues()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"backend":  backendValues,
+		"settings": settingsValues,
+	}, nil
+}
+
+// configSet changes one key. The value is parsed as YAML, so numbers,
+// booleans and lists keep their type.
+func (c *cli) configSet(key, raw string) error {
+	parts := strings.Split(key, ".")
+	if len(parts) < 2 || (parts[0] != "backend" && parts[0] != "settings") {
+		return usageError("keys start with backend. (config.yaml) or settings. (vibeproxy.yaml), got %q", key)
+	}
+	var value interface{}
+	if err := yaml.Unmarshal([]byte(raw), &value); err != nil {
+		value = raw
+	}
+
+	// Nest the value under its key path, e.g. {"settings": {"logging": {"level": "debug"}}}
+	changes := map[string]interface{}{parts[len(parts)-1]: value}
+	for i := len(parts) - 2; i >= 0; i-- {
+		changes = map[string]interface{}{parts[i]: changes}
+	}
+
+	restarted, err := c.client().UpdateConfig(changes)
+	if errors.Is(err, client.ErrNotRunning) {
+		err = c.configSetOffline(parts[0], changes[parts[0]].(map[string]interface{}))
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return printJSON(map[string]interface{}{"success": true, "restarted": restarted})
+	}
+	if restarted {
+		fmt.Printf("Set %s, backend restarted\n", key)
+	} else {
+		fmt.Printf("Set %s\n", key)
+	}
+	return nil
+}
+
+// configSetOffline writes a change straight to the file, keeping a backup
+// like the web UI does
+func (c *cli) configSetOffline(file string, values map[string]interface{}) error {
+	dirs, settings, err := c.resolve()
+	if err != nil {
+		return err
+	}
+
+	if file == "backend" {
+		if err := dirs.Create(); err != nil {
+			return err
+		}
+		if err := process.EnsureConfig(dirs.Config(), paths.Template()); err != nil {
+			return err
+		}
+		backend, err := config.LoadCLIProxyAPIConfig(dirs.Config())
+		if err != nil {
+			return err
+		}
+		if err := backend.Update(values); err != nil {
+			return fmt.Errorf("config.yaml: %w", err)
+		}
+		if !backend.Modified() {
+			return nil
+		}
+		if _, err := config.Backup(backend.Path()); err != nil {
+			return err
+		}
+		if err := config.RemoveBackup(settings.Path()); err != nil {
+			return err
+		}
+		return backend.Save(backend.Path())
+	}
+
+	if err := settings.Update(values); err != nil {
+		return fmt.Errorf("vibeproxy.yaml: %w", err)
+	}
+	if !settings.Modified() {
+		return nil
+	}
+	if err := dirs.Create(); err != nil {
+		return err
+	}
+	if _, err := config.Backup(settings.Path()); err != nil {
+		return err
+	}
+	if err := config.RemoveBackup(dirs.Config()); err != nil {
+		return err
+	}
+	return settings.Save(settings.Path())
+}
+
+// runVersion prints this binary's version and the running instance's
+func runVersion(c *cli, args []string) error {
+	if _, err := parse(c.flagSet("version"), args, 0, 0); err != nil {
+		return err
+	}
+
+	server := ""
+	if status, err := c.client().Status(); err == nil {
+		server = status.Version
+	}
+
+	if c.json {
+		out := map[string]string{"version": version.Version}
+		if server != "" {
+			out["server"] = server
+		}
+		return printJSON(out)
+	}
+	fmt.Printf("vibeproxy %s\n", version.Version)
+	if server != "" {
+		fmt.Printf("running instance %s\n", server)
+	}
+	return nil
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
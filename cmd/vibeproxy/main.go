@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
-	"log"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/automazeio/vibeproxy/internal/auth"
+	"github.com/automazeio/vibeproxy/internal/certs"
+	"github.com/automazeio/vibeproxy/internal/config"
+	"github.com/automazeio/vibeproxy/internal/logging"
+	"github.com/automazeio/vibeproxy/internal/paths"
 	"github.com/automazeio/vibeproxy/internal/process"
 	"github.com/automazeio/vibeproxy/internal/proxy"
 	"github.com/automazeio/vibeproxy/internal/server"
@@ -20,113 +28,306 @@ const (
 	uiServerPort      = 8319 // Web UI port
 )
 
+// Exit statuses of serve, from sysexits.h, so a supervisor can tell a bad
+// configuration from a failure worth retrying
+const (
+	exitUnavailable = 69 // cli-proxy-api is missing or did not start
+	exitOSError     = 71 // a port or socket could not be opened
+	exitCantCreate  = 73 // a directory, config.yaml, log or PID file could not be written
+	exitConfig      = 78 // vibeproxy.yaml or config.yaml is invalid
+)
+
+var logger = logging.Component("VibeProxy")
+
+// serveOptions are the flags of `vibeproxy serve`
+type serveOptions struct {
+	paths    paths.Options
+	headless bool   // do not open a browser
+	noUI     bool   // do not start the web UI
+	uiSocket string // serve the web UI on a Unix socket
+	pidFile  string
+	logFile  string // overrides logging.file
+}
+
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	log.Println("[VibeProxy] Starting...")
+	os.Exit(run(os.Args[1:]))
+}
 
-	// Get binary and config paths
-	binaryPath, err := process.GetBinaryPath()
+// serve runs the proxy, backend and web UI until interrupted and returns the
+// exit status
+func serve(opts serveOptions) int {
+	logger.Info("Starting")
+
+	dirs, err := paths.Resolve(opts.paths)
 	if err != nil {
-		log.Fatalf("[VibeProxy] Failed to find cli-proxy-api binary: %v", err)
+		return fail(exitConfig, "Failed to resolve paths", "error", err)
+	}
+	if err := dirs.Create(); err != nil {
+		return fail(exitCantCreate, "Failed to create directories", "error", err)
+	}
+
+	// Load VibeProxy settings
+	settingsPath := dirs.Settings()
+	settings, err := config.LoadSettings(settingsPath)
+	if err != nil {
+		return fail(exitConfig, "Invalid settings", "error", err)
+	}
+	if err := logging.Setup(loggingOptions(settings.Logging, opts.logFile)); err != nil {
+		return fail(exitCantCreate, "Failed to set up logging", "error", err)
 	}
-	log.Printf("[VibeProxy] Using binary: %s", binaryPath)
+	defer logging.Close()
 
-	configPath, err := process.GetConfigPath()
+	if opts.pidFile != "" {
+		if err := writePIDFile(opts.pidFile); err != nil {
+			return fail(exitCantCreate, "Failed to write PID file", "error", err)
+		}
+		defer removePIDFile(opts.pidFile)
+	}
+
+	// Get binary and config paths
+	if err := dirs.ResolveBinary(opts.paths.Binary, settings.Backend.Binary); err != nil {
+		return fail(exitUnavailable, "Failed to find cli-proxy-api binary", "error", err)
+	}
+	binaryPath := dirs.Binary.Path
+	logger.Info("Using binary", "path", binaryPath, "source", dirs.Binary.Source)
+
+	configPath := dirs.Config()
+	if err := process.EnsureConfig(configPath, paths.Template()); err != nil {
+		return fail(exitCantCreate, "Failed to create config.yaml", "error", err)
+	}
+	logger.Info("Using config", "path", configPath, "source", dirs.ConfigDir.Source)
+	backendConfig, err := config.LoadCLIProxyAPIConfig(configPath)
 	if err != nil {
-		log.Fatalf("[VibeProxy] Failed to find config.yaml: %v", err)
+		return fail(exitConfig, "Invalid config.yaml", "error", err)
 	}
-	log.Printf("[VibeProxy] Using config: %s", configPath)
+	authDir, err := backendConfig.AuthDirPath()
+	if err != nil {
+		return fail(exitConfig, "Invalid auth-dir in config.yaml", "error", err)
+	}
+	logger.Info("Using data directory", "path", dirs.DataDir.Path)
 
 	// Create auth manager
-	authManager := auth.NewManager()
+	authManager := auth.NewManager(authDir)
 	if err := authManager.CheckAuthStatus(); err != nil {
-		log.Printf("[VibeProxy] Warning: Failed to check auth status: %v", err)
+		logger.Warn("Failed to check auth status", "error", err)
 	}
 
 	// Create process manager for CLIProxyAPI
 	processManager := process.NewManager(binaryPath, configPath)
+	processManager.SetDataDir(dirs.DataDir.Path)
 
 	// Create thinking proxy (8317 → 8318)
 	thinkingProxy := proxy.NewThinkingProxy(thinkingProxyPort, cliProxyAPIPort)
+	processManager.SetTarget(thinkingProxy)
+	thinkingProxy.SetAPIKeysFile(configPath)
+
+	// Apply vibeproxy.yaml; the web UI re-applies it when settings are edited
+	svc := &services{proxy: thinkingProxy, process: processManager, recordings: dirs.Recordings(), logFile: opts.logFile, teamUsage: dirs.TeamUsage()}
+	if err := svc.apply(settings); err != nil {
+		return fail(exitConfig, "Invalid settings", "error", err)
+	}
+	for _, rule := range settings.AccountHints {
+		if _, ok := authManager.FindAccount(rule.Account); !ok {
+			logger.Warn("Account hint names unknown account", "rule", rule.Name, "account", rule.Account)
+		}
+	}
+
+	// Serve TLS with certificates reloaded when their files change
+	var tlsConfig *tls.Config
+	if settings.Listen.Proxy.TLS || settings.Listen.UI.TLS {
+		certPath, keyPath := tlsFiles(dirs, settings)
+		reloader, err := certs.NewReloader(certPath, keyPath, settings.TLS.ClientCA)
+		if err != nil {
+			return fail(exitConfig, "Failed to load TLS certificate, create one with 'vibeproxy cert'", "error", err)
+		}
+		defer reloader.Close()
+		tlsConfig = reloader.TLSConfig()
+		thinkingProxy.SetTLS(tlsConfig)
+		logger.Info("Using TLS certificate", "cert", certPath, "client_ca", settings.TLS.ClientCA)
+	}
 
 	// Create web UI server
-	uiServer := server.NewUIServer(uiServerPort, authManager, processManager)
+	var uiServer *server.UIServer
+	ui := "disabled"
+	if !opts.noUI {
+		uiServer = server.NewUIServer(uiServerPort, authManager, processManager, thinkingProxy)
+		uiServer.SetSettingsHandler(settingsPath, svc.apply)
+		uiServer.SetAutostart(newAutostart(dirs, settings))
+		uiServer.SetListen(uiListen(settings.Listen.UI, opts.uiSocket))
+		uiServer.SetTLS(tlsConfig)
+		token, err := uiToken(dirs, settings, true)
+		if err != nil {
+			return fail(exitCantCreate, "Failed to create web UI login token", "error", err)
+		}
+		uiServer.SetAuth(settings.UIAuth, token)
+		if settings.UIAuth.Token == "" {
+			logger.Info("Web UI login token for remote access", "file", dirs.UIToken())
+		}
+
+		listen := uiListen(settings.Listen.UI, opts.uiSocket)
+		ui = "unix:" + listen.Socket
+		if listen.TCP {
+			scheme := "http"
+			if listen.TLS {
+				scheme = "https"
+			}
+			ui = fmt.Sprintf("%s://%s/static/", scheme, net.JoinHostPort(uiHost(listen), strconv.Itoa(uiServerPort)))
+		}
+	}
+
+	// Reject requests for providers that are not logged in
+	loginHint := "run 'vibeproxy login <provider>'"
+	if strings.HasPrefix(ui, "http") {
+		loginHint = "open " + ui
+	}
+	thinkingProxy.SetAuth(authManager, loginHint)
 
 	// Create file watcher for auth directory
 	watcher, err := auth.NewWatcher(authManager, func() {
-		log.Println("[VibeProxy] Auth status changed")
+		logger.Info("Auth status changed")
 	})
 	if err != nil {
-		log.Printf("[VibeProxy] Warning: Failed to create file watcher: %v", err)
+		logger.Warn("Failed to create file watcher", "error", err)
 	} else {
 		defer watcher.Close()
 	}
 
 	// Start thinking proxy first
 	if err := thinkingProxy.Start(); err != nil {
-		log.Fatalf("[VibeProxy] Failed to start thinking proxy: %v", err)
+		return fail(exitOSError, "Failed to start thinking proxy", "error", err)
 	}
-	log.Printf("[VibeProxy] ThinkingProxy started on port %d", thinkingProxyPort)
+	logger.Info("ThinkingProxy started", "port", thinkingProxyPort)
 
 	// Wait for thinking proxy to be ready
 	time.Sleep(100 * time.Millisecond)
 
 	// Start CLIProxyAPI backend
 	if err := processManager.Start(); err != nil {
-		log.Fatalf("[VibeProxy] Failed to start CLIProxyAPI: %v", err)
+		return fail(exitUnavailable, "Failed to start CLIProxyAPI", "error", err)
 	}
-	log.Printf("[VibeProxy] CLIProxyAPI started on port %d", cliProxyAPIPort)
+	logger.Info("CLIProxyAPI started", "port", processManager.Port())
 
-	// Wait for CLIProxyAPI to be ready (actually listening on port 8318)
-	log.Println("[VibeProxy] Waiting for CLIProxyAPI to be ready...")
+	// Wait for CLIProxyAPI to be ready (actually listening on its port)
+	logger.Info("Waiting for CLIProxyAPI to be ready")
 	ready := false
 	for i := 0; i < 30; i++ {
 		if processManager.HealthCheck() {
 			ready = true
-			log.Println("[VibeProxy] CLIProxyAPI is ready and accepting connections")
+			logger.Info("CLIProxyAPI is ready and accepting connections")
 			break
 		}
 		time.Sleep(500 * time.Millisecond)
 	}
 
 	if !ready {
-		log.Fatalf("[VibeProxy] CLIProxyAPI failed to start (port 8318 not listening after 15 seconds)")
+		processManager.Stop()
+		return fail(exitUnavailable, "CLIProxyAPI failed to start (port not listening after 15 seconds)", "port", processManager.Port())
 	}
 
 	// Start web UI server
-	if err := uiServer.Start(); err != nil {
-		log.Fatalf("[VibeProxy] Failed to start UI server: %v", err)
+	if uiServer != nil {
+		if err := uiServer.Start(); err != nil {
+			processManager.Stop()
+			return fail(exitOSError, "Failed to start UI server", "error", err)
+		}
+		logger.Info("Web UI started", "address", ui)
 	}
-	log.Printf("[VibeProxy] Web UI started on port %d", uiServerPort)
 
 	// Open browser to UI
-	uiURL := fmt.Sprintf("http://localhost:%d/static/", uiServerPort)
-	log.Printf("[VibeProxy] Opening browser to %s", uiURL)
-	if err := server.OpenBrowser(uiURL); err != nil {
-		log.Printf("[VibeProxy] Failed to open browser: %v", err)
-		log.Printf("[VibeProxy] Please open manually: %s", uiURL)
+	if !opts.headless && strings.HasPrefix(ui, "http") {
+		logger.Info("Opening browser", "url", ui)
+		if err := server.OpenBrowser(ui); err != nil {
+			logger.Warn("Failed to open browser, please open the UI manually", "url", ui, "error", err)
+		}
 	}
 
-	log.Println("[VibeProxy] All services started successfully!")
-	log.Printf("[VibeProxy] Client port: %d (with thinking transformation)", thinkingProxyPort)
-	log.Printf("[VibeProxy] Backend port: %d (CLIProxyAPI)", cliProxyAPIPort)
-	log.Printf("[VibeProxy] Web UI: %s", uiURL)
+	logger.Info("All services started successfully",
+		"client_port", thinkingProxyPort,
+		"backend_port", processManager.Port(),
+		"ui", ui)
+
+	// Restart the backend without downtime when config.yaml changes or on SIGHUP
+	if settings.Backend.ReloadOnChange {
+		configWatcher, err := process.NewConfigWatcher(processManager)
+		if err != nil {
+			logger.Warn("Failed to watch config.yaml", "error", err)
+		} else {
+			defer configWatcher.Close()
+		}
+	}
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			logger.Info("Received SIGHUP, restarting CLIProxyAPI")
+			if err := processManager.Restart(); err != nil {
+				logger.Error("Failed to restart CLIProxyAPI", "error", err)
+			}
+		}
+	}()
 
-	// Wait for interrupt signal
-	sigChan := make(chan os.Signal, 1)
+	// Wait for interrupt signal; a second one forces exit while draining
+	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	<-sigChan
+	go func() {
+		<-sigChan
+		logger.Warn("Received second signal, exiting immediately")
+		if opts.pidFile != "" {
+			removePIDFile(opts.pidFile)
+		}
+		logging.Close()
+		os.Exit(1)
+	}()
 
-	log.Println("[VibeProxy] Shutting down...")
+	settings, tracer := svc.current()
+	logger.Info("Shutting down", "drain_timeout", settings.Shutdown.DrainTimeout.String())
 
-	// Stop all services
-	if err := thinkingProxy.Stop(); err != nil {
-		log.Printf("[VibeProxy] Error stopping thinking proxy: %v", err)
+	// Stop accepting requests and let in-flight ones, including streams, finish
+	drainCtx, cancel := context.WithTimeout(context.Background(), settings.Shutdown.DrainTimeout)
+	if err := thinkingProxy.Shutdown(drainCtx); err != nil {
+		logger.Warn("In-flight requests did not finish in time", "error", err)
 	}
+	cancel()
 
+	if uiServer != nil {
+		uiCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := uiServer.Shutdown(uiCtx); err != nil {
+			logger.Warn("Failed to stop UI server cleanly", "error", err)
+		}
+		cancel()
+	}
+
+	// The backend goes last so drained requests could still reach it
 	if err := processManager.Stop(); err != nil {
-		log.Printf("[VibeProxy] Error stopping process manager: %v", err)
+		logger.Error("Failed to stop process manager", "error", err)
+	}
+
+	tracer.Shutdown(5 * time.Second)
+
+	logger.Info("Shutdown complete")
+	return 0
+}
+
+// uiListen returns where the web UI listens; --ui-socket replaces the TCP
+// port with a socket
+func uiListen(cfg config.ListenerConfig, socket string) config.ListenerConfig {
+	if socket != "" {
+		cfg.TCP, cfg.Socket = false, socket
 	}
+	return cfg
+}
+
+// uiHost returns the address local clients reach the web UI's TCP port on
+func uiHost(cfg config.ListenerConfig) string {
+	if ip := net.ParseIP(cfg.Bind); cfg.Bind == "" || (ip != nil && ip.IsUnspecified()) {
+		return "localhost"
+	}
+	return cfg.Bind
+}
 
-	log.Println("[VibeProxy] Shutdown complete")
+// fail logs why serve cannot continue and returns code as its exit status
+func fail(code int, msg string, args ...interface{}) int {
+	logger.Error(msg, args...)
+	return code
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+	"github.com/automazeio/vibeproxy/internal/paths"
+)
+
+// runPaths shows where VibeProxy looks for its files
+func runPaths(c *cli, args []string) error {
+	if _, err := parse(c.flagSet("paths"), args, 0, 0); err != nil {
+		return err
+	}
+
+	dirs, err := paths.Resolve(c.paths)
+	if err != nil {
+		return err
+	}
+
+	recordings := dirs.Recordings()
+	configuredBinary := ""
+	if settings, err := config.LoadSettings(dirs.Settings()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	} else {
+		configuredBinary = settings.Backend.Binary
+		if settings.Recording.Dir != "" {
+			recordings = settings.Recording.Dir
+		}
+	}
+	binaryErr := dirs.ResolveBinary(c.paths.Binary, configuredBinary)
+	template := paths.Template()
+
+	if c.json {
+		out := map[string]interface{}{
+			"configDir":  dirs.ConfigDir,
+			"config":     dirs.Config(),
+			"settings":   dirs.Settings(),
+			"template":   template,
+			"dataDir":    dirs.DataDir,
+			"recordings": recordings,
+			"binary":     nil,
+		}
+		if binaryErr == nil {
+			out["binary"] = dirs.Binary
+		}
+		if err := printJSON(out); err != nil {
+			return err
+		}
+		return binaryErr
+	}
+
+	if template == "" {
+		template = "(none, a minimal config.yaml is written)"
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "config dir\t%s\t%s\n", dirs.ConfigDir.Path, dirs.ConfigDir.Source)
+	fmt.Fprintf(w, "config.yaml\t%s\t%s\n", dirs.Config(), describeFile(dirs.Config()))
+	fmt.Fprintf(w, "vibeproxy.yaml\t%s\t%s\n", dirs.Settings(), describeFile(dirs.Settings()))
+	fmt.Fprintf(w, "config template\t%s\t\n", template)
+	fmt.Fprintf(w, "data dir\t%s\t%s\n", dirs.DataDir.Path, dirs.DataDir.Source)
+	fmt.Fprintf(w, "recordings\t%s\t%s\n", recordings, describeFile(recordings))
+	if binaryErr != nil {
+		fmt.Fprintf(w, "cli-proxy-api\t(not found)\t\n")
+	} else {
+		fmt.Fprintf(w, "cli-proxy-api\t%s\t%s\n", dirs.Binary.Path, dirs.Binary.Source)
+	}
+	w.Flush()
+
+	return binaryErr
+}
+
+// describeFile says whether path exists yet
+func describeFile(path string) string {
+	if _, err := os.Stat(path); err != nil {
+		return "missing"
+	}
+	return "exists"
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+	"github.com/automazeio/vibeproxy/internal/logging"
+	"github.com/automazeio/vibeproxy/internal/process"
+	"github.com/automazeio/vibeproxy/internal/proxy"
+	"github.com/automazeio/vibeproxy/internal/tracing"
+)
+
+// services are the running parts of VibeProxy that vibeproxy.yaml configures
+type services struct {
+	mu         sync.Mutex
+	proxy      *proxy.ThinkingProxy
+	process    *process.Manager
+	recordings string // recording.dir when vibeproxy.yaml leaves it empty
+	logFile    string // --log-file, overrides logging.file
+	teamUsage  string // file for team members' daily usage
+	tracer     *tracing.Tracer
+	settings   *config.Settings // last applied
+}
+
+// apply configures the services for next. Sections that did not change
+// since the last call are left alone, so caches and history survive an
+// unrelated edit. On error nothing is changed.
+func (s *services) apply(next *config.Settings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.settings
+	changed := func(section func(*config.Settings) interface{}) bool {
+		return prev == nil || !reflect.DeepEqual(section(prev), section(next))
+	}
+
+	switch next.Backend.AlternatePort {
+	case thinkingProxyPort, cliProxyAPIPort, uiServerPort:
+		return fmt.Errorf("backend.alternate-port %d conflicts with a VibeProxy port", next.Backend.AlternatePort)
+	}
+
+	// Fallible sections first
+	teamChanged := changed(func(c *config.Settings) interface{} { return c.Team })
+	backendKey := next.Team.BackendKey
+	if teamChanged && next.Team.Enabled && backendKey == "" {
+		backend, err := config.LoadCLIProxyAPIConfig(s.process.ConfigPath())
+		if err != nil {
+			return fmt.Errorf("team mode needs team.backend-key or api-keys in config.yaml: %w", err)
+		}
+		if len(backend.APIKeys) == 0 {
+			return fmt.Errorf("team mode needs team.backend-key or api-keys in config.yaml")
+		}
+		backendKey = backend.APIKeys[0]
+	}
+	recordingChanged := changed(func(c *config.Settings) interface{} { return c.Recording })
+	if recordingChanged {
+		if err := s.proxy.SetRecording(s.recording(next)); err != nil {
+			return fmt.Errorf("failed to set up recording: %w", err)
+		}
+	}
+	// Logging is set up at startup before the services exist
+	if prev != nil && changed(func(c *config.Settings) interface{} { return c.Logging }) {
+		if err := logging.Setup(loggingOptions(next.Logging, s.logFile)); err != nil {
+			if recordingChanged {
+				s.proxy.SetRecording(s.recording(prev))
+			}
+			return fmt.Errorf("failed to set up logging: %w", err)
+		}
+	}
+
+	if teamChanged {
+		s.proxy.SetTeam(next.Team, backendKey, s.teamUsage)
+		if next.Team.Enabled {
+			logger.Info("Team mode enabled", "users", len(next.Team.Users))
+		}
+	}
+	if changed(func(c *config.Settings) interface{} { return c.Providers }) {
+		s.proxy.SetProviders(next.Providers)
+	}
+	if changed(func(c *config.Settings) interface{} { return c.AccountHints }) {
+		s.proxy.SetAccountHints(next.AccountHints)
+	}
+	if changed(func(c *config.Settings) interface{} { return c.RateLimits }) {
+		s.proxy.SetRateLimits(next.RateLimits)
+	}
+	if changed(func(c *config.Settings) interface{} { return c.UpstreamRetry }) {
+		s.proxy.SetUpstreamRetry(next.UpstreamRetry)
+	}
+	if changed(func(c *config.Settings) interface{} { return c.Limits }) {
+		s.proxy.SetLimits(next.Limits)
+	}
+	if changed(func(c *config.Settings) interface{} { return c.PromptCache }) {
+		s.proxy.SetPromptCacheInjection(next.PromptCache.Enabled)
+	}
+	if changed(func(c *config.Settings) interface{} { return c.ResponseCache }) {
+		s.proxy.SetResponseCache(next.ResponseCache)
+	}
+	if changed(func(c *config.Settings) interface{} { return c.Inspector }) {
+		s.proxy.SetInspector(next.Inspector)
+	}
+	if changed(func(c *config.Settings) interface{} { return c.Tracing }) {
+		old := s.tracer
+		s.tracer = tracing.NewTracer("", "", nil, 0)
+		if next.Tracing.Enabled {
+			s.tracer = tracing.NewTracer(next.Tracing.Endpoint, next.Tracing.ServiceName, next.Tracing.Headers, next.Tracing.SampleRatio)
+			logger.Info("Exporting traces", "endpoint", next.Tracing.Endpoint)
+		}
+		s.proxy.SetTracer(s.tracer)
+		if old != nil {
+			go old.Shutdown(5 * time.Second)
+		}
+	}
+	if changed(func(c *config.Settings) interface{} { return c.Backend }) {
+		s.process.SetBackendConfig(next.Backend)
+	}
+	if changed(func(c *config.Settings) interface{} { return c.Listen }) {
+		// The listeners are open; moving them would drop clients
+		if prev == nil {
+			s.proxy.SetListen(next.Listen.Proxy)
+		} else {
+			logger.Warn("Listen settings take effect after a restart")
+		}
+	}
+	if prev != nil && changed(func(c *config.Settings) interface{} { return c.TLS }) {
+		// Only the files' contents are reloaded while running
+		logger.Warn("TLS settings take effect after a restart")
+	}
+	if prev != nil && changed(func(c *config.Settings) interface{} { return c.UIAuth }) {
+		logger.Warn("Web UI access settings take effect after a restart")
+	}
+
+	if prev != nil {
+		logger.Info("Applied settings", "path", next.Path())
+	}
+	s.settings = next
+	return nil
+}
+
+// recording returns the recording section with the default directory filled in
+func (s *services) recording(settings *config.Settings) config.RecordingConfig {
+	cfg := settings.Recording
+	if cfg.Dir == "" {
+		cfg.Dir = s.recordings
+	}
+	return cfg
+}
+
+// current returns the applied settings and tracer
+func (s *services) current() (*config.Settings, *tracing.Tracer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.settings, s.tracer
+}
+
+// loggingOptions converts the logging section for the logging package; a
+// non-empty file replaces logging.file
+func loggingOptions(cfg config.LoggingConfig, file string) logging.Options {
+	if file == "" {
+		file = cfg.File
+	}
+	return logging.Options{
+		Level:      cfg.Level,
+		Format:     cfg.Format,
+		File:       file,
+		MaxSizeMB:  cfg.MaxSizeMB,
+		MaxBackups: cfg.MaxBackups,
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+	"github.com/automazeio/vibeproxy/internal/paths"
+)
+
+// uiToken returns the web UI login token: ui-auth.token, or the one in
+// <config-dir>/ui-token, which is generated when create is set
+func uiToken(dirs *paths.Paths, settings *config.Settings, create bool) (string, error) {
+	if settings.UIAuth.Token != "" {
+		return settings.UIAuth.Token, nil
+	}
+	data, err := os.ReadFile(dirs.UIToken())
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) || !create {
+		return "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	if err := os.WriteFile(dirs.UIToken(), []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
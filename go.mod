@@ -2,6 +2,9 @@ module github.com/automazeio/vibeproxy
 
 go 1.24.4
 
-require github.com/fsnotify/fsnotify v1.9.0
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require golang.org/x/sys v0.13.0 // indirect
@@ -2,10 +2,12 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -39,22 +41,58 @@ func (a *AuthStatus) StatusText() string {
 	return "Connected"
 }
 
+// Account represents a single credential file in the auth directory.
+// A provider may have several accounts (e.g. work and personal Claude).
+type Account struct {
+	ID      string    `json:"id"` // file name without the .json extension
+	Type    string    `json:"type"`
+	Email   string    `json:"email,omitempty"`
+	Expired time.Time `json:"expired,omitempty"`
+	// Refreshable is set when the file has a refresh token, so CLIProxyAPI
+	// can renew an expired token
+	Refreshable bool `json:"-"`
+}
+
+// Matches reports whether name refers to this account by ID or email
+func (a Account) Matches(name string) bool {
+	return strings.EqualFold(a.ID, name) || (a.Email != "" && strings.EqualFold(a.Email, name))
+}
+
 // Manager manages authentication status for all services
 type Manager struct {
 	Claude AuthStatus
 	Codex  AuthStatus
 	Gemini AuthStatus
 	Qwen   AuthStatus
+
+	dir      string // auth-dir from config.yaml; empty for the default
+	mu       sync.RWMutex
+	accounts []Account
 }
 
-// NewManager creates a new AuthManager
-func NewManager() *Manager {
+// NewManager creates a new AuthManager for the auth files in dir, the
+// auth-dir of config.yaml. An empty dir means CLIProxyAPI's default,
+// ~/.cli-proxy-api.
+func NewManager(dir string) *Manager {
 	return &Manager{
 		Claude: AuthStatus{Type: "claude"},
 		Codex:  AuthStatus{Type: "codex"},
 		Gemini: AuthStatus{Type: "gemini"},
 		Qwen:   AuthStatus{Type: "qwen"},
+		dir:    dir,
+	}
+}
+
+// Dir returns the directory holding the auth files
+func (m *Manager) Dir() (string, error) {
+	if m.dir != "" {
+		return m.dir, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
 	}
+	return filepath.Join(homeDir, ".cli-proxy-api"), nil
 }
 
 // authFileData represents the structure of auth JSON files
@@ -66,15 +104,13 @@ type authFileData struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
-// CheckAuthStatus scans the ~/.cli-proxy-api directory and updates auth status
+// CheckAuthStatus scans the auth directory and updates auth status
 func (m *Manager) CheckAuthStatus() error {
-	homeDir, err := os.UserHomeDir()
+	authDir, err := m.Dir()
 	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
+		return err
 	}
 
-	authDir := filepath.Join(homeDir, ".cli-proxy-api")
-
 	// Reset all statuses first
 	foundClaude := false
 	foundCodex := false
@@ -85,6 +121,7 @@ func (m *Manager) CheckAuthStatus() error {
 	if _, err := os.Stat(authDir); os.IsNotExist(err) {
 		// Directory doesn't exist yet - all services unauthenticated
 		m.resetAll()
+		m.setAccounts(nil)
 		return nil
 	}
 
@@ -92,9 +129,12 @@ func (m *Manager) CheckAuthStatus() error {
 	files, err := os.ReadDir(authDir)
 	if err != nil {
 		m.resetAll()
+		m.setAccounts(nil)
 		return fmt.Errorf("failed to read auth directory: %w", err)
 	}
 
+	var accounts []Account
+
 	for _, file := range files {
 		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
 			continue
@@ -125,6 +165,14 @@ func (m *Manager) CheckAuthStatus() error {
 			Expired:         expiredTime,
 		}
 
+		accounts = append(accounts, Account{
+			ID:          strings.TrimSuffix(file.Name(), ".json"),
+			Type:        strings.ToLower(authData.Type),
+			Email:       authData.Email,
+			Expired:     expiredTime,
+			Refreshable: authData.RefreshToken != "",
+		})
+
 		// Update the appropriate service status
 		switch strings.ToLower(authData.Type) {
 		case "claude":
@@ -156,9 +204,62 @@ func (m *Manager) CheckAuthStatus() error {
 		m.Qwen = AuthStatus{Type: "qwen"}
 	}
 
+	m.setAccounts(accounts)
 	return nil
 }
 
+// setAccounts replaces the list of known accounts
+func (m *Manager) setAccounts(accounts []Account) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.accounts = accounts
+}
+
+// Accounts returns every account found during the last scan
+func (m *Manager) Accounts() []Account {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Account(nil), m.accounts...)
+}
+
+// FindAccount returns the account matching name by ID or email
+func (m *Manager) FindAccount(name string) (Account, bool) {
+	for _, account := range m.Accounts() {
+		if account.Matches(name) {
+			return account, true
+		}
+	}
+	return Account{}, false
+}
+
+// ErrAccountNotFound is returned by RemoveAccount when nothing matches
+var ErrAccountNotFound = errors.New("account not found")
+
+// RemoveAccount deletes the credential file of a provider's account, matched
+// by ID or email, or the provider's first account when account is empty
+func (m *Manager) RemoveAccount(provider, account string) (Account, error) {
+	if err := m.CheckAuthStatus(); err != nil {
+		return Account{}, err
+	}
+
+	authDir, err := m.Dir()
+	if err != nil {
+		return Account{}, err
+	}
+
+	for _, candidate := range m.Accounts() {
+		if !strings.EqualFold(candidate.Type, provider) || (account != "" && !candidate.Matches(account)) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(authDir, candidate.ID+".json")); err != nil {
+			return Account{}, fmt.Errorf("failed to delete auth file: %w", err)
+		}
+		m.CheckAuthStatus()
+		return candidate, nil
+	}
+	return Account{}, ErrAccountNotFound
+}
+
 // resetAll resets all service statuses to unauthenticated
 func (m *Manager) resetAll() {
 	m.Claude = AuthStatus{Type: "claude"}
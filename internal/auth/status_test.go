@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveAccountUsesAuthDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"claude-work.json":     `{"type":"claude","email":"work@example.com"}`,
+		"claude-personal.json": `{"type":"claude","email":"me@example.com"}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("HOME", t.TempDir()) // nothing under the default directory
+
+	m := NewManager(dir)
+	removed, err := m.RemoveAccount("claude", "me@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed.ID != "claude-personal" {
+		t.Fatalf("removed %q, want claude-personal", removed.ID)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "claude-personal.json")); !os.IsNotExist(err) {
+		t.Fatalf("auth file still there: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "claude-work.json")); err != nil {
+		t.Fatalf("other account removed: %v", err)
+	}
+	if accounts := m.Accounts(); len(accounts) != 1 || accounts[0].ID != "claude-work" {
+		t.Fatalf("accounts = %+v, want only claude-work", accounts)
+	}
+
+	if _, err := m.RemoveAccount("codex", ""); !errors.Is(err, ErrAccountNotFound) {
+		t.Fatalf("removing a missing account: %v, want ErrAccountNotFound", err)
+	}
+}
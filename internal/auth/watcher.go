@@ -1,13 +1,14 @@
 package auth
 
 import (
-	"log"
 	"os"
-	"path/filepath"
 
+	"github.com/automazeio/vibeproxy/internal/logging"
 	"github.com/fsnotify/fsnotify"
 )
 
+var watcherLogger = logging.Component("FileWatcher")
+
 // Watcher monitors the auth directory for changes
 type Watcher struct {
 	watcher  *fsnotify.Watcher
@@ -23,14 +24,12 @@ func NewWatcher(manager *Manager, onChange func()) (*Watcher, error) {
 		return nil, err
 	}
 
-	homeDir, err := os.UserHomeDir()
+	authDir, err := manager.Dir()
 	if err != nil {
 		watcher.Close()
 		return nil, err
 	}
 
-	authDir := filepath.Join(homeDir, ".cli-proxy-api")
-
 	// Create directory if it doesn't exist
 	if err := os.MkdirAll(authDir, 0755); err != nil {
 		watcher.Close()
@@ -52,7 +51,7 @@ func NewWatcher(manager *Manager, onChange func()) (*Watcher, error) {
 
 	go w.watch()
 
-	log.Printf("[FileWatcher] Monitoring auth directory: %s", authDir)
+	watcherLogger.Info("Monitoring auth directory", "dir", authDir)
 
 	return w, nil
 }
@@ -68,11 +67,11 @@ func (w *Watcher) watch() {
 
 			// Trigger on any write, create, remove, or rename event
 			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
-				log.Printf("[FileWatcher] Auth directory changed: %s", event.Name)
+				watcherLogger.Debug("Auth directory changed", "file", event.Name, "op", event.Op.String())
 
 				// Refresh auth status
 				if err := w.manager.CheckAuthStatus(); err != nil {
-					log.Printf("[FileWatcher] Error checking auth status: %v", err)
+					watcherLogger.Error("Failed to check auth status", "error", err)
 				}
 
 				// Notify callback
@@ -85,7 +84,7 @@ func (w *Watcher) watch() {
 			if !ok {
 				return
 			}
-			log.Printf("[FileWatcher] Error: %v", err)
+			watcherLogger.Error("Watch error", "error", err)
 
 		case <-w.done:
 			return
@@ -0,0 +1,230 @@
+// Package autostart starts VibeProxy when the user logs in, through an XDG
+// autostart entry in a graphical session or a systemd user service
+package autostart
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/logging"
+)
+
+var logger = logging.Component("Autostart")
+
+// Mechanisms
+const (
+	Desktop = "desktop" // ~/.config/autostart/vibeproxy.desktop
+	Systemd = "systemd" // ~/.config/systemd/user/vibeproxy.service
+)
+
+// Runner runs an external command and returns its standard output. Tests
+// substitute a fake for systemctl and loginctl.
+type Runner interface {
+	Run(name string, args ...string) (string, error)
+}
+
+// RunnerFunc adapts a function to Runner
+type RunnerFunc func(name string, args ...string) (string, error)
+
+// Run calls f
+func (f RunnerFunc) Run(name string, args ...string) (string, error) {
+	return f(name, args...)
+}
+
+// execRunner runs commands with os/exec
+type execRunner struct{}
+
+func (execRunner) Run(name string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			return string(out), fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+		}
+		return string(out), fmt.Errorf("%s %s: %s", name, strings.Join(args, " "), message)
+	}
+	return string(out), nil
+}
+
+// Config describes how the autostarted VibeProxy is launched
+type Config struct {
+	Executable  string            // defaults to the running binary
+	Env         map[string]string // pinned environment, e.g. VIBEPROXY_CONFIG_DIR
+	ConfigHome  string            // defaults to $XDG_CONFIG_HOME or ~/.config
+	StopTimeout time.Duration     // how long systemd waits for a graceful stop
+}
+
+// Manager enables, disables and reports autostart
+type Manager struct {
+	cfg    Config
+	runner Runner
+}
+
+// Status is what /api/autostart reports
+type Status struct {
+	Enabled   bool       `json:"enabled"`
+	Mechanism string     `json:"mechanism,omitempty"` // the active one
+	Available []string   `json:"available"`           // mechanisms usable here
+	Unit      *UnitState `json:"unit,omitempty"`      // when the systemd unit exists
+	Hints     []string   `json:"hints,omitempty"`
+}
+
+// UnitState is the systemd unit's state from `systemctl --user show`
+type UnitState struct {
+	Path          string `json:"path"`
+	LoadState     string `json:"loadState"`
+	ActiveState   string `json:"activeState"`
+	SubState      string `json:"subState"`
+	UnitFileState string `json:"unitFileState"`
+	MainPID       int    `json:"mainPid"`
+	Result        string `json:"result"`
+	Linger        bool   `json:"linger"` // starts at boot without a login
+}
+
+// NewManager creates a manager; a nil runner runs real commands
+func NewManager(cfg Config, runner Runner) *Manager {
+	if runner == nil {
+		runner = execRunner{}
+	}
+	if cfg.StopTimeout <= 0 {
+		cfg.StopTimeout = 45 * time.Second
+	}
+	return &Manager{cfg: cfg, runner: runner}
+}
+
+// Enable installs the mechanism and removes the other one, so VibeProxy is
+// not started twice. An empty mechanism keeps the active one, or uses the
+// desktop entry.
+func (m *Manager) Enable(mechanism string) (*Status, error) {
+	if err := supported(); err != nil {
+		return nil, err
+	}
+	if mechanism == "" {
+		mechanism = Desktop
+		if m.systemdEnabled() {
+			mechanism = Systemd
+		}
+	}
+
+	var err error
+	switch mechanism {
+	case Desktop:
+		if err = m.disableSystemd(); err == nil {
+			err = m.enableDesktop()
+		}
+	case Systemd:
+		if err = m.enableSystemd(); err == nil {
+			err = m.disableDesktop()
+		}
+	default:
+		return nil, fmt.Errorf("unknown autostart mechanism %q (expected %s or %s)", mechanism, Desktop, Systemd)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Enabled autostart", "mechanism", mechanism)
+	return m.Status(), nil
+}
+
+// Disable removes both mechanisms
+func (m *Manager) Disable() error {
+	if err := supported(); err != nil {
+		return err
+	}
+	if err := m.disableSystemd(); err != nil {
+		return err
+	}
+	if err := m.disableDesktop(); err != nil {
+		return err
+	}
+	logger.Info("Disabled autostart")
+	return nil
+}
+
+// Status reports which mechanism is active and the systemd unit's state
+func (m *Manager) Status() *Status {
+	status := &Status{Available: []string{}}
+	if supported() != nil {
+		return status
+	}
+
+	status.Available = append(status.Available, Desktop)
+	if m.systemdAvailable() {
+		status.Available = append(status.Available, Systemd)
+	}
+
+	if fileExists(m.unitPath()) {
+		status.Unit = m.unitState()
+		if status.Unit.UnitFileState == "enabled" {
+			status.Enabled, status.Mechanism = true, Systemd
+			if status.Unit.ActiveState != "active" {
+				status.Hints = append(status.Hints, "The service starts at the next login. To switch now, stop this instance and run `systemctl --user start vibeproxy`")
+			}
+			if !status.Unit.Linger {
+				status.Hints = append(status.Hints, fmt.Sprintf("Run `loginctl enable-linger %s` to start VibeProxy at boot instead of at login", username()))
+			}
+		}
+	}
+	if !status.Enabled && fileExists(m.desktopPath()) {
+		status.Enabled, status.Mechanism = true, Desktop
+	}
+	return status
+}
+
+// configHome returns the XDG config directory
+func (m *Manager) configHome() string {
+	if m.cfg.ConfigHome != "" {
+		return m.cfg.ConfigHome
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config")
+}
+
+// executable returns the binary to launch
+func (m *Manager) executable() (string, error) {
+	if m.cfg.Executable != "" {
+		return m.cfg.Executable, nil
+	}
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return path, nil
+}
+
+// supported reports whether autostart works on this platform
+func supported() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("autostart only supported on Linux")
+	}
+	return nil
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// username returns the login name for hints
+func username() string {
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "$USER"
+}
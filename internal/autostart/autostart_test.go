@@ -0,0 +1,185 @@
+package autostart
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeSystem answers systemctl and loginctl like a user session would
+type fakeSystem struct {
+	unit    string // output of systemctl --user show
+	linger  string // output of loginctl show-user
+	noUser  bool   // no systemd user manager
+	command []string
+}
+
+func (f *fakeSystem) runner() Runner {
+	return RunnerFunc(func(name string, args ...string) (string, error) {
+		f.command = append(f.command, strings.Join(append([]string{name}, args...), " "))
+		switch {
+		case name == "systemctl" && f.noUser:
+			return "", errors.New("Failed to connect to bus")
+		case name == "systemctl" && len(args) > 1 && args[1] == "show":
+			return f.unit, nil
+		case name == "loginctl":
+			return f.linger, nil
+		}
+		return "", nil
+	})
+}
+
+func newTestManager(t *testing.T, sys *fakeSystem) *Manager {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("autostart is Linux only")
+	}
+	return NewManager(Config{
+		Executable: "/opt/vibe proxy/vibeproxy",
+		Env:        map[string]string{"VIBEPROXY_CONFIG_DIR": "/home/me/my config", "A_FIRST": "100%"},
+		ConfigHome: t.TempDir(),
+	}, sys.runner())
+}
+
+func TestUnitExecStartAndEnvironment(t *testing.T) {
+	m := NewManager(Config{
+		Executable: `/opt/vibe "proxy"/$HOME/vibeproxy`,
+		Env:        map[string]string{"VIBEPROXY_CONFIG_DIR": "/home/me/my config", "A_FIRST": "100%"},
+	}, (&fakeSystem{}).runner())
+
+	unit, err := m.Unit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`ExecStart="/opt/vibe \"proxy\"/$$HOME/vibeproxy" serve --headless`,
+		`Environment="A_FIRST=100%%"`,
+		`Environment="VIBEPROXY_CONFIG_DIR=/home/me/my config"`,
+		`RestartPreventExitStatus=73 78`,
+		`TimeoutStopSec=45`,
+	}
+	lines := strings.Split(unit, "\n")
+	next := 0
+	for _, line := range lines {
+		if next < len(want) && line == want[next] {
+			next++
+		}
+	}
+	if next < len(want) {
+		t.Fatalf("unit is missing %q (or lines are out of order):\n%s", want[next], unit)
+	}
+}
+
+func TestEnableSystemdWritesUnit(t *testing.T) {
+	sys := &fakeSystem{unit: "UnitFileState=enabled\n", linger: "Linger=yes\n"}
+	m := newTestManager(t, sys)
+
+	status, err := m.Enable(Systemd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || status.Mechanism != Systemd {
+		t.Fatalf("status = %+v, want systemd enabled", status)
+	}
+
+	data, err := os.ReadFile(filepath.Join(m.cfg.ConfigHome, "systemd", "user", unitName))
+	if err != nil {
+		t.Fatalf("unit not written: %v", err)
+	}
+	if !strings.Contains(string(data), `ExecStart="/opt/vibe proxy/vibeproxy" serve --headless`) {
+		t.Fatalf("unexpected unit:\n%s", data)
+	}
+
+	ran := strings.Join(sys.command, "\n")
+	for _, want := range []string{"systemctl --user daemon-reload", "systemctl --user enable vibeproxy.service"} {
+		if !strings.Contains(ran, want) {
+			t.Fatalf("%q not run; ran:\n%s", want, ran)
+		}
+	}
+}
+
+func TestStatusReadsUnitState(t *testing.T) {
+	sys := &fakeSystem{
+		unit: "LoadState=loaded\nActiveState=active\nSubState=running\n" +
+			"UnitFileState=enabled\nMainPID=4242\nResult=success\n",
+		linger: "Linger=yes\n",
+	}
+	m := newTestManager(t, sys)
+	if err := os.MkdirAll(filepath.Dir(m.unitPath()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(m.unitPath(), []byte("[Unit]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	status := m.Status()
+	want := UnitState{
+		Path:          m.unitPath(),
+		LoadState:     "loaded",
+		ActiveState:   "active",
+		SubState:      "running",
+		UnitFileState: "enabled",
+		MainPID:       4242,
+		Result:        "success",
+		Linger:        true,
+	}
+	if status.Unit == nil || *status.Unit != want {
+		t.Fatalf("unit = %+v, want %+v", status.Unit, want)
+	}
+	if !status.Enabled || status.Mechanism != Systemd {
+		t.Fatalf("status = %+v, want systemd enabled", status)
+	}
+	if len(status.Hints) != 0 {
+		t.Fatalf("unexpected hints: %q", status.Hints)
+	}
+}
+
+func TestStatusLingerHint(t *testing.T) {
+	for _, tt := range []struct {
+		linger string
+		hint   bool
+	}{
+		{"Linger=no\n", true},
+		{"Linger=yes\n", false},
+		{"", true}, // loginctl printed nothing
+	} {
+		sys := &fakeSystem{unit: "ActiveState=active\nUnitFileState=enabled\n", linger: tt.linger}
+		m := newTestManager(t, sys)
+		if err := os.MkdirAll(filepath.Dir(m.unitPath()), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(m.unitPath(), []byte("[Unit]\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		status := m.Status()
+		hint := false
+		for _, h := range status.Hints {
+			if strings.Contains(h, "loginctl enable-linger") {
+				hint = true
+			}
+		}
+		if hint != tt.hint {
+			t.Errorf("loginctl output %q: linger hint = %v, want %v (hints %q)", tt.linger, hint, tt.hint, status.Hints)
+		}
+		if !strings.Contains(strings.Join(sys.command, "\n"), "loginctl show-user") {
+			t.Errorf("loginctl not asked; ran %q", sys.command)
+		}
+	}
+}
+
+func TestStatusWithoutSystemd(t *testing.T) {
+	m := newTestManager(t, &fakeSystem{noUser: true})
+
+	status := m.Status()
+	if len(status.Available) != 1 || status.Available[0] != Desktop {
+		t.Fatalf("available = %q, want only %s", status.Available, Desktop)
+	}
+	if _, err := m.Enable(Systemd); err == nil {
+		t.Fatal("enabling systemd without a user manager succeeded")
+	}
+}
@@ -0,0 +1,69 @@
+package autostart
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// desktopPath returns the XDG autostart entry
+func (m *Manager) desktopPath() string {
+	return filepath.Join(m.configHome(), "autostart", "vibeproxy.desktop")
+}
+
+// enableDesktop writes the XDG autostart entry, which the desktop session
+// runs at login
+func (m *Manager) enableDesktop() error {
+	execPath, err := m.executable()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.desktopPath()), 0755); err != nil {
+		return err
+	}
+
+	exec := desktopQuote(execPath)
+	if len(m.cfg.Env) > 0 {
+		keys := make([]string, 0, len(m.cfg.Env))
+		for key := range m.cfg.Env {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		args := []string{"env"}
+		for _, key := range keys {
+			args = append(args, desktopQuote(key+"="+m.cfg.Env[key]))
+		}
+		exec = strings.Join(append(args, exec), " ")
+	}
+
+	desktopEntry := fmt.Sprintf(`[Desktop Entry]
+Type=Application
+Name=VibeProxy
+Exec=%s
+Hidden=false
+NoDisplay=false
+X-GNOME-Autostart-enabled=true
+`, exec)
+
+	return os.WriteFile(m.desktopPath(), []byte(desktopEntry), 0644)
+}
+
+// disableDesktop removes the XDG autostart entry
+func (m *Manager) disableDesktop() error {
+	if err := os.Remove(m.desktopPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// desktopQuote quotes an Exec argument per the desktop entry spec when it
+// contains reserved characters
+func desktopQuote(arg string) string {
+	if !strings.ContainsAny(arg, " \t\n\"'\\><~|&;$*?#()`%") {
+		return arg
+	}
+	r := strings.NewReplacer(`\`, `\\\\`, `"`, `\\"`, "`", "\\\\`", `$`, `\\$`, `%`, `%%`)
+	return `"` + r.Replace(arg) + `"`
+}
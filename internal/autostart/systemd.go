@@ -0,0 +1,160 @@
+package autostart
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// unitName is the systemd user service
+const unitName = "vibeproxy.service"
+
+// unitPath returns the user unit file
+func (m *Manager) unitPath() string {
+	return filepath.Join(m.configHome(), "systemd", "user", unitName)
+}
+
+// Unit returns the systemd unit file contents. The service runs headless
+// and is restarted after crashes and transient failures, but not after the
+// configuration errors serve reports with exit statuses 73 and 78.
+func (m *Manager) Unit() (string, error) {
+	execPath, err := m.executable()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=VibeProxy OAuth proxy for AI coding tools\n")
+	b.WriteString("Wants=network-online.target\n")
+	b.WriteString("After=network-online.target\n")
+	b.WriteString("\n[Service]\n")
+	b.WriteString("Type=simple\n")
+	// $ expands variables in command lines only
+	fmt.Fprintf(&b, "ExecStart=%s serve --headless\n", strings.ReplaceAll(systemdQuote(execPath), "$", "$$"))
+	fmt.Fprintf(&b, "ExecReload=/bin/kill -HUP $MAINPID\n")
+
+	keys := make([]string, 0, len(m.cfg.Env))
+	for key := range m.cfg.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(key+"="+m.cfg.Env[key]))
+	}
+
+	b.WriteString("Restart=on-failure\n")
+	b.WriteString("RestartSec=5\n")
+	b.WriteString("RestartPreventExitStatus=73 78\n")
+	fmt.Fprintf(&b, "TimeoutStopSec=%d\n", int(m.cfg.StopTimeout.Seconds()))
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=default.target\n")
+	return b.String(), nil
+}
+
+// enableSystemd writes the unit and enables it. It is not started now since
+// this instance holds the ports; it starts at the next login, or at boot
+// with lingering enabled.
+func (m *Manager) enableSystemd() error {
+	if !m.systemdAvailable() {
+		return fmt.Errorf("systemd user instance is not available")
+	}
+	unit, err := m.Unit()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.unitPath()), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.unitPath(), []byte(unit), 0644); err != nil {
+		return err
+	}
+	if _, err := m.systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	_, err = m.systemctl("enable", unitName)
+	return err
+}
+
+// disableSystemd disables and removes the unit if it exists
+func (m *Manager) disableSystemd() error {
+	if !fileExists(m.unitPath()) {
+		return nil
+	}
+	if m.systemdAvailable() {
+		if _, err := m.systemctl("disable", unitName); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(m.unitPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if m.systemdAvailable() {
+		if _, err := m.systemctl("daemon-reload"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// systemdEnabled reports whether the unit is installed and enabled
+func (m *Manager) systemdEnabled() bool {
+	return fileExists(m.unitPath()) && m.unitState().UnitFileState == "enabled"
+}
+
+// systemdAvailable reports whether a systemd user manager is reachable
+func (m *Manager) systemdAvailable() bool {
+	_, err := m.systemctl("show-environment")
+	return err == nil
+}
+
+// unitState reads the unit's properties; fields stay empty when systemd
+// cannot be asked
+func (m *Manager) unitState() *UnitState {
+	state := &UnitState{Path: m.unitPath()}
+	out, err := m.systemctl("show", unitName,
+		"--property=LoadState,ActiveState,SubState,UnitFileState,MainPID,Result")
+	if err != nil {
+		logger.Debug("Failed to read unit state", "error", err)
+		return state
+	}
+
+	props := parseProperties(out)
+	state.LoadState = props["LoadState"]
+	state.ActiveState = props["ActiveState"]
+	state.SubState = props["SubState"]
+	state.UnitFileState = props["UnitFileState"]
+	state.MainPID, _ = strconv.Atoi(props["MainPID"])
+	state.Result = props["Result"]
+
+	if out, err := m.runner.Run("loginctl", "show-user", strconv.Itoa(os.Getuid()), "--property=Linger"); err == nil {
+		state.Linger = parseProperties(out)["Linger"] == "yes"
+	}
+	return state
+}
+
+// systemctl runs systemctl against the user manager
+func (m *Manager) systemctl(args ...string) (string, error) {
+	return m.runner.Run("systemctl", append([]string{"--user"}, args...)...)
+}
+
+// parseProperties parses the key=value lines systemctl and loginctl print
+func parseProperties(out string) map[string]string {
+	props := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			props[key] = value
+		}
+	}
+	return props
+}
+
+// systemdQuote quotes a unit file value so spaces, quotes and specifiers
+// survive
+func systemdQuote(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `%`, `%%`)
+	return `"` + r.Replace(value) + `"`
+}
@@ -0,0 +1,225 @@
+// Package certs generates a local CA with server and client certificates
+// for sharing VibeProxy over the LAN, and serves TLS certificates that are
+// reloaded when their files change
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/logging"
+)
+
+var logger = logging.Component("TLS")
+
+// Validity periods; 825 days is the longest leaf lifetime Apple platforms accept
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 825 * 24 * time.Hour
+)
+
+// File names in the certificate directory
+const (
+	CAFile        = "ca.crt"
+	caKeyFile     = "ca.key"
+	ServerFile    = "server.crt"
+	ServerKeyFile = "server.key"
+	ClientsDir    = "clients"
+)
+
+// CA is a local certificate authority kept in a directory
+type CA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	dir  string
+}
+
+// LoadOrCreateCA loads the CA in dir, creating one on first use, and
+// reports whether it was created
+func LoadOrCreateCA(dir string) (*CA, bool, error) {
+	certPath, keyPath := filepath.Join(dir, CAFile), filepath.Join(dir, caKeyFile)
+	if _, err := os.Stat(certPath); err == nil {
+		ca, err := loadCA(dir, certPath, keyPath)
+		return ca, false, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, false, err
+	}
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: "VibeProxy local CA " + hostname, Organization: []string{"VibeProxy"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := writePair(certPath, keyPath, der, key); err != nil {
+		return nil, false, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, false, err
+	}
+	return &CA{cert: cert, key: key, dir: dir}, true, nil
+}
+
+// loadCA reads an existing CA
+func loadCA(dir, certPath, keyPath string) (*CA, error) {
+	pair, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pair)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s is not a PEM certificate", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("CA key: %w", err)
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM key", keyPath)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("CA key cannot sign")
+	}
+	return &CA{cert: cert, key: signer, dir: dir}, nil
+}
+
+// CertPath returns the CA certificate clients should trust
+func (ca *CA) CertPath() string {
+	return filepath.Join(ca.dir, CAFile)
+}
+
+// IssueServer writes server.crt and server.key for hosts, which may be DNS
+// names or IP addresses, and returns their paths
+func (ca *CA) IssueServer(hosts []string) (string, string, error) {
+	if len(hosts) == 0 {
+		return "", "", errors.New("at least one host name or IP address is needed")
+	}
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: hosts[0], Organization: []string{"VibeProxy"}},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	certPath, keyPath := filepath.Join(ca.dir, ServerFile), filepath.Join(ca.dir, ServerKeyFile)
+	return certPath, keyPath, ca.issue(template, certPath, keyPath)
+}
+
+// IssueClient writes clients/<name>.crt and .key for mTLS and returns their
+// paths
+func (ca *CA) IssueClient(name string) (string, string, error) {
+	if name == "" || filepath.Base(name) != name {
+		return "", "", fmt.Errorf("invalid client name %q", name)
+	}
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name, Organization: []string{"VibeProxy"}},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	dir := filepath.Join(ca.dir, ClientsDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	certPath, keyPath := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	return certPath, keyPath, ca.issue(template, certPath, keyPath)
+}
+
+// issue signs a leaf certificate with a new key
+func (ca *CA) issue(template *x509.Certificate, certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template.SerialNumber = serialNumber()
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(leafValidity)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return err
+	}
+	return writePair(certPath, keyPath, der, key)
+}
+
+// DefaultHosts returns the names and addresses teammates may use to reach
+// this machine: localhost, the hostname and every interface address
+func DefaultHosts() []string {
+	hosts := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		hosts = append(hosts, hostname)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return append(hosts, "127.0.0.1", "::1")
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			hosts = append(hosts, ipNet.IP.String())
+		}
+	}
+	return hosts
+}
+
+// writePair writes a PEM certificate (0644) and PKCS #8 key (0600)
+func writePair(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(certPath), 0700); err != nil {
+		return err
+	}
+	// A reloader sees both writes within one debounce period
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// serialNumber returns a random 128-bit serial
+func serialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(err)
+	}
+	return serial
+}
@@ -0,0 +1,204 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce lets tools finish writing a certificate and key pair
+const reloadDebounce = 500 * time.Millisecond
+
+// Reloader serves a certificate and, for mTLS, a client CA pool that are
+// reread when their files change. A failed reload keeps the previous ones.
+type Reloader struct {
+	certPath     string
+	keyPath      string
+	clientCAPath string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+// NewReloader loads the certificate, key and optional client CA and watches
+// their directories for changes
+func NewReloader(certPath, keyPath, clientCAPath string) (*Reloader, error) {
+	r := &Reloader{
+		certPath:     filepath.Clean(certPath),
+		keyPath:      filepath.Clean(keyPath),
+		clientCAPath: clientCAPath,
+		done:         make(chan struct{}),
+	}
+	if clientCAPath != "" {
+		r.clientCAPath = filepath.Clean(clientCAPath)
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// Directories are watched so that files replaced by renames are noticed
+	var dirs []string
+	for _, path := range r.files() {
+		if dir := filepath.Dir(path); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+	r.watcher = watcher
+
+	go r.watch()
+
+	logger.Info("Watching certificates for changes", "cert", r.certPath, "client_ca", r.clientCAPath)
+
+	return r, nil
+}
+
+// files returns the paths being served
+func (r *Reloader) files() []string {
+	files := []string{r.certPath, r.keyPath}
+	if r.clientCAPath != "" {
+		files = append(files, r.clientCAPath)
+	}
+	return files
+}
+
+// load reads the files and swaps them in
+func (r *Reloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.clientCAPath != "" {
+		data, err := os.ReadFile(r.clientCAPath)
+		if err != nil {
+			return fmt.Errorf("load client CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return errors.New("load client CA: no PEM certificates in " + r.clientCAPath)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCA = pool
+	r.mu.Unlock()
+	return nil
+}
+
+// watch reloads once writes to any of the files settle
+func (r *Reloader) watch() {
+	var timer *time.Timer
+	for {
+		select {
+		case event, ok := <-r.watcher.Events:
+			if !ok {
+				return
+			}
+			if !slices.Contains(r.files(), filepath.Clean(event.Name)) ||
+				event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(reloadDebounce, func() {
+				if err := r.load(); err != nil {
+					logger.Error("Failed to reload certificates, keeping the previous ones", "error", err)
+					return
+				}
+				logger.Info("Certificates reloaded", "cert", r.certPath)
+			})
+
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return
+			}
+			logger.Error("Watch error", "error", err)
+
+		case <-r.done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+	}
+}
+
+// TLSConfig returns a server config that always uses the latest files.
+// Client certificates are required when a client CA is set.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+			if r.clientCA != nil {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				config.ClientCAs = r.clientCA
+			}
+			return config, nil
+		},
+	}
+}
+
+// Close stops watching
+func (r *Reloader) Close() error {
+	close(r.done)
+	return r.watcher.Close()
+}
+
+// PinnedConfig returns a client config that trusts only the certificate in
+// certPath, for local tools that share the server's files and may reach it
+// under a name the certificate does not list
+func PinnedConfig(certPath string) (*tls.Config, error) {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s is not a PEM certificate", certPath)
+	}
+	pinned := block.Bytes
+	return &tls.Config{
+		// Verification is replaced by comparing with the pinned certificate
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], pinned) {
+				return fmt.Errorf("server certificate does not match %s", certPath)
+			}
+			return nil
+		},
+	}, nil
+}
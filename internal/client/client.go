@@ -0,0 +1,202 @@
+// Package client talks to a running VibeProxy through its web UI API, for
+// the command-line interface
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/auth"
+	"github.com/automazeio/vibeproxy/internal/logging"
+)
+
+// ErrNotRunning is returned when nothing answers on the UI port
+var ErrNotRunning = errors.New("VibeProxy is not running")
+
+// Client calls the UI server's API
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// Status is the subset of /api/status the CLI shows
+type Status struct {
+	Version  string                     `json:"version"`
+	Services map[string]auth.AuthStatus `json:"services"`
+	Accounts []auth.Account             `json:"accounts"`
+	Server   struct {
+		Running bool `json:"running"`
+	} `json:"server"`
+}
+
+// LoginResult is the outcome of a login started through the API
+type LoginResult struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Error   string `json:"error,omitempty"`
+}
+
+// New creates a client for the UI server at baseURL, e.g.
+// http://127.0.0.1:8319
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		// Logins wait for the browser flow to finish
+		http: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// NewUnix creates a client for a UI server listening on the Unix socket at
+// path
+func NewUnix(path string) *Client {
+	c := New("http://vibeproxy")
+	c.http.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return c
+}
+
+// NewTLS creates a client for a UI server at an https baseURL
+func NewTLS(baseURL string, tlsConfig *tls.Config) *Client {
+	c := New(baseURL)
+	c.http.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	return c
+}
+
+// SetToken sends the web UI login token with every call
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// Status returns the running instance's status
+func (c *Client) Status() (*Status, error) {
+	var status Status
+	if err := c.do(http.MethodGet, "/api/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Login runs the provider's login flow in the running instance
+func (c *Client) Login(provider, email string) (*LoginResult, error) {
+	var result LoginResult
+	body := map[string]string{"service": provider, "email": email}
+	if err := c.do(http.MethodPost, "/api/auth/connect", body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Logout removes a provider's account; account may be empty when the
+// provider has only one
+func (c *Client) Logout(provider, account string) (auth.Account, error) {
+	var result struct {
+		Account auth.Account `json:"account"`
+	}
+	body := map[string]string{"service": provider, "account": account}
+	if err := c.do(http.MethodPost, "/api/auth/disconnect", body, &result); err != nil {
+		return auth.Account{}, err
+	}
+	return result.Account, nil
+}
+
+// Logs returns buffered log entries with a sequence number above after
+func (c *Client) Logs(limit int, level, component string, after uint64) ([]logging.Entry, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("after", strconv.FormatUint(after, 10))
+	if level != "" {
+		query.Set("level", level)
+	}
+	if component != "" {
+		query.Set("component", component)
+	}
+
+	var entries []logging.Entry
+	if err := c.do(http.MethodGet, "/api/logs?"+query.Encode(), nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Config returns config.yaml and vibeproxy.yaml as served by /api/config
+func (c *Client) Config() (map[string]interface{}, error) {
+	var cfg map[string]interface{}
+	if err := c.do(http.MethodGet, "/api/config", nil, &cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// UpdateConfig applies changes keyed like /api/config and reports whether
+// the backend was restarted
+func (c *Client) UpdateConfig(changes map[string]interface{}) (bool, error) {
+	var result struct {
+		Restarted bool `json:"restarted"`
+	}
+	if err := c.do(http.MethodPut, "/api/config", changes, &result); err != nil {
+		return false, err
+	}
+	return result.Restarted, nil
+}
+
+// do sends a JSON request and decodes the JSON response into out; error
+// responses are returned as their message
+func (c *Client) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return ErrNotRunning
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if text := strings.TrimSpace(string(message)); text != "" {
+			return errors.New(text)
+		}
+		return fmt.Errorf("request failed: %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
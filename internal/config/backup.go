@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// BackupPath returns where Backup keeps the previous version of path
+func BackupPath(path string) string {
+	return path + ".bak"
+}
+
+// Backup copies path to its backup file before it is changed. The returned
+// undo puts path back as it was and reinstates the backup it replaced, for
+// changes that turn out not to work.
+func Backup(path string) (undo func() error, err error) {
+	original, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	hadFile := err == nil
+	previous, err := os.ReadFile(BackupPath(path))
+	hadBackup := err == nil
+
+	if hadFile {
+		if err := writeFile(BackupPath(path), original, 0600); err != nil {
+			return nil, fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+	}
+
+	undo = func() error {
+		if !hadFile {
+			os.Remove(path)
+		} else if err := writeFile(path, original, 0644); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+		if hadBackup {
+			return writeFile(BackupPath(path), previous, 0600)
+		}
+		if hadFile {
+			os.Remove(BackupPath(path))
+		}
+		return nil
+	}
+	return undo, nil
+}
+
+// Restore puts the backup of path back in place and removes it, so a
+// second restore cannot undo the first
+func Restore(path string) error {
+	data, err := os.ReadFile(BackupPath(path))
+	if err != nil {
+		return fmt.Errorf("no backup of %s: %w", path, err)
+	}
+	if err := writeFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	return RemoveBackup(path)
+}
+
+// RemoveBackup deletes the backup of path, if any
+func RemoveBackup(path string) error {
+	if err := os.Remove(BackupPath(path)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove backup of %s: %w", path, err)
+	}
+	return nil
+}
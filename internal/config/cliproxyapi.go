@@ -0,0 +1,181 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CLIProxyAPIConfig is the part of CLIProxyAPI's config.yaml VibeProxy
+// understands. Other keys and comments are kept when it is saved.
+type CLIProxyAPIConfig struct {
+	Port                   int                    `yaml:"port"`
+	AuthDir                string                 `yaml:"auth-dir"`
+	RemoteManagement       RemoteManagementConfig `yaml:"remote-management"`
+	APIKeys                []string               `yaml:"api-keys"`
+	Debug                  bool                   `yaml:"debug"`
+	LoggingToFile          bool                   `yaml:"logging-to-file"`
+	UsageStatisticsEnabled bool                   `yaml:"usage-statistics-enabled"`
+	ProxyURL               string                 `yaml:"proxy-url"`
+	RequestRetry           int                    `yaml:"request-retry"`
+	QuotaExceeded          QuotaExceededConfig    `yaml:"quota-exceeded"`
+	WSAuth                 bool                   `yaml:"ws-auth"`
+
+	path string // file the config was loaded from
+	data []byte // its contents, for comments and unknown keys
+}
+
+// RemoteManagementConfig controls CLIProxyAPI's management API
+type RemoteManagementConfig struct {
+	AllowRemote         bool   `yaml:"allow-remote"`
+	SecretKey           string `yaml:"secret-key"`
+	DisableControlPanel bool   `yaml:"disable-control-panel"`
+}
+
+// QuotaExceededConfig controls what CLIProxyAPI does when a quota runs out
+type QuotaExceededConfig struct {
+	SwitchProject      bool `yaml:"switch-project"`
+	SwitchPreviewModel bool `yaml:"switch-preview-model"`
+}
+
+// DefaultCLIProxyAPIConfig returns the config VibeProxy creates when there is
+// no template
+func DefaultCLIProxyAPIConfig() *CLIProxyAPIConfig {
+	return &CLIProxyAPIConfig{
+		Port:         8318,
+		AuthDir:      "~/.cli-proxy-api",
+		APIKeys:      []string{"dummy-not-used"},
+		RequestRetry: 3,
+		QuotaExceeded: QuotaExceededConfig{
+			SwitchProject:      true,
+			SwitchPreviewModel: true,
+		},
+	}
+}
+
+// AuthDirPath returns auth-dir with a leading ~ expanded to the home directory
+func (c *CLIProxyAPIConfig) AuthDirPath() (string, error) {
+	dir := strings.TrimSpace(c.AuthDir)
+	if dir != "~" && !strings.HasPrefix(dir, "~/") {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(home, strings.TrimPrefix(dir, "~")), nil
+}
+
+// LoadCLIProxyAPIConfig reads and validates config.yaml
+func LoadCLIProxyAPIConfig(path string) (*CLIProxyAPIConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	return ParseCLIProxyAPIConfig(data, path)
+}
+
+// ParseCLIProxyAPIConfig decodes and validates config.yaml contents; path is
+// used in errors and as the default save location
+func ParseCLIProxyAPIConfig(data []byte, path string) (*CLIProxyAPIConfig, error) {
+	cfg := &CLIProxyAPIConfig{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	cfg.path, cfg.data = path, data
+	return cfg, nil
+}
+
+// Validate checks the values VibeProxy depends on
+func (c *CLIProxyAPIConfig) Validate() error {
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535, got %d", c.Port)
+	}
+	if strings.TrimSpace(c.AuthDir) == "" {
+		return fmt.Errorf("auth-dir must be set")
+	}
+	if c.ProxyURL != "" {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("proxy-url is not a valid URL: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("proxy-url must use http, https or socks5, got %q", c.ProxyURL)
+		}
+		if u.Host == "" {
+			return fmt.Errorf("proxy-url has no host: %q", c.ProxyURL)
+		}
+	}
+	if c.RequestRetry < 0 {
+		return fmt.Errorf("request-retry must not be negative, got %d", c.RequestRetry)
+	}
+	return nil
+}
+
+// Values returns the config keyed like config.yaml
+func (c *CLIProxyAPIConfig) Values() (map[string]interface{}, error) {
+	return yamlValues(c)
+}
+
+// Update sets the keys present in values, keyed like config.yaml, and
+// validates the result
+func (c *CLIProxyAPIConfig) Update(values map[string]interface{}) error {
+	if err := updateValues(c, values); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Modified reports whether the config differs from the file it was loaded from
+func (c *CLIProxyAPIConfig) Modified() bool {
+	before, err := c.loaded()
+	return err != nil || !sameValues(before, c)
+}
+
+// Marshal returns the loaded file with changed values written back
+func (c *CLIProxyAPIConfig) Marshal() ([]byte, error) {
+	before, err := c.loaded()
+	if err != nil {
+		return nil, err
+	}
+	return updateYAML(c.data, before, c)
+}
+
+// loaded decodes the file the config was loaded from
+func (c *CLIProxyAPIConfig) loaded() (*CLIProxyAPIConfig, error) {
+	before := &CLIProxyAPIConfig{}
+	if err := yaml.Unmarshal(c.data, before); err != nil {
+		return nil, err
+	}
+	return before, nil
+}
+
+// Save validates the config and writes it to path
+func (c *CLIProxyAPIConfig) Save(path string) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	data, err := c.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := writeFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	c.path, c.data = path, data
+	return nil
+}
+
+// Path returns the file the config was loaded from or last saved to
+func (c *CLIProxyAPIConfig) Path() string {
+	return c.path
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// SecretMask stands in for secrets in MaskedValues, followed by a short
+// fingerprint of the secret. Sent back to Update through Unmask, it keeps the
+// stored secret with that fingerprint wherever the entry moved to.
+const SecretMask = "********"
+
+// Paths of secrets, keyed like the YAML; "*" matches every list item. All
+// strings under a path are secret.
+var (
+	cliProxyAPISecrets = []string{"api-keys", "remote-management.secret-key"}
+	settingsSecrets    = []string{
+		"team.backend-key", "team.users.*.keys", "ui-auth.token", "tracing.headers",
+		"account-hints.*.client-key", "rate-limits.rules.*.client-key",
+	}
+)
+
+// MaskedValues returns Values with secrets replaced by SecretMask
+func (c *CLIProxyAPIConfig) MaskedValues() (map[string]interface{}, error) {
+	values, err := c.Values()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range cliProxyAPISecrets {
+		maskPath(values, strings.Split(path, "."))
+	}
+	return values, nil
+}
+
+// Unmask replaces masks in values, keyed like config.yaml, with the stored
+// secrets they stand for
+func (c *CLIProxyAPIConfig) Unmask(values map[string]interface{}) error {
+	stored, err := c.Values()
+	if err != nil {
+		return err
+	}
+	return unmaskPaths(values, stored, cliProxyAPISecrets)
+}
+
+// MaskedValues returns Values with secrets replaced by SecretMask
+func (s *Settings) MaskedValues() (map[string]interface{}, error) {
+	values, err := s.Values()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range settingsSecrets {
+		maskPath(values, strings.Split(path, "."))
+	}
+	return values, nil
+}
+
+// Unmask replaces masks in values, keyed like vibeproxy.yaml, with the stored
+// secrets they stand for
+func (s *Settings) Unmask(values map[string]interface{}) error {
+	stored, err := s.Values()
+	if err != nil {
+		return err
+	}
+	return unmaskPaths(values, stored, settingsSecrets)
+}
+
+// maskPath masks the strings under path in value
+func maskPath(value interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return mask(value)
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if item, ok := v[path[0]]; ok {
+			v[path[0]] = maskPath(item, path[1:])
+		}
+	case []interface{}:
+		if path[0] == "*" {
+			for i, item := range v {
+				v[i] = maskPath(item, path[1:])
+			}
+		}
+	}
+	return value
+}
+
+// mask replaces every non-empty string in value with its mask
+func mask(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			return maskOf(v)
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = mask(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = mask(item)
+		}
+	}
+	return value
+}
+
+// maskOf returns SecretMask followed by the first 8 hex digits of the
+// secret's SHA-256, which tells masked entries apart without revealing them
+func maskOf(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return SecretMask + hex.EncodeToString(sum[:4])
+}
+
+// unmaskPaths restores the masked strings under each of paths in sent from
+// stored
+func unmaskPaths(sent, stored map[string]interface{}, paths []string) error {
+	for _, path := range paths {
+		keys := strings.Split(path, ".")
+		secrets := map[string]string{}
+		collect(stored, keys, secrets)
+		if _, err := unmaskPath(sent, keys, secrets); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// collect adds the strings under path in value to secrets, keyed by mask
+func collect(value interface{}, path []string, secrets map[string]string) {
+	if len(path) == 0 {
+		switch v := value.(type) {
+		case string:
+			if v != "" {
+				secrets[maskOf(v)] = v
+			}
+		case map[string]interface{}:
+			for _, item := range v {
+				collect(item, nil, secrets)
+			}
+		case []interface{}:
+			for _, item := range v {
+				collect(item, nil, secrets)
+			}
+		}
+		return
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		collect(v[path[0]], path[1:], secrets)
+	case []interface{}:
+		if path[0] == "*" {
+			for _, item := range v {
+				collect(item, path[1:], secrets)
+			}
+		}
+	}
+}
+
+// unmaskPath replaces the masks under path in sent with the secrets they
+// stand for. Entries are matched by mask, not position, so removing or
+// reordering list entries keeps every secret with its own entry.
+func unmaskPath(sent interface{}, path []string, secrets map[string]string) (interface{}, error) {
+	if len(path) == 0 {
+		return unmask(sent, secrets)
+	}
+	switch v := sent.(type) {
+	case map[string]interface{}:
+		if item, ok := v[path[0]]; ok {
+			restored, err := unmaskPath(item, path[1:], secrets)
+			if err != nil {
+				return nil, err
+			}
+			v[path[0]] = restored
+		}
+	case []interface{}:
+		if path[0] == "*" {
+			for i, item := range v {
+				restored, err := unmaskPath(item, path[1:], secrets)
+				if err != nil {
+					return nil, err
+				}
+				v[i] = restored
+			}
+		}
+	}
+	return sent, nil
+}
+
+// unmask replaces every mask in sent with the stored secret it stands for.
+// A bare SecretMask is accepted where only one secret is stored; any other
+// mask that matches no stored secret is an error.
+func unmask(sent interface{}, secrets map[string]string) (interface{}, error) {
+	switch v := sent.(type) {
+	case string:
+		if !strings.HasPrefix(v, SecretMask) {
+			return v, nil
+		}
+		if secret, ok := secrets[v]; ok {
+			return secret, nil
+		}
+		if v == SecretMask && len(secrets) == 1 {
+			for _, secret := range secrets {
+				return secret, nil
+			}
+		}
+		return nil, fmt.Errorf("%q matches no stored secret; send masks as returned or a new value", v)
+	case map[string]interface{}:
+		for key, item := range v {
+			restored, err := unmask(item, secrets)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = restored
+		}
+	case []interface{}:
+		for i, item := range v {
+			restored, err := unmask(item, secrets)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = restored
+		}
+	}
+	return sent, nil
+}
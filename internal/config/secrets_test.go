@@ -0,0 +1,119 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnmaskMatchesMovedEntries(t *testing.T) {
+	cfg, err := ParseCLIProxyAPIConfig([]byte("port: 8318\nauth-dir: ~/.cli-proxy-api\napi-keys:\n  - key-a\n  - key-b\n  - key-c\n"), "config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	masked, err := cfg.MaskedValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := masked["api-keys"].([]interface{})
+	for _, key := range keys {
+		if !strings.HasPrefix(key.(string), SecretMask) || strings.Contains(key.(string), "key-") {
+			t.Fatalf("api-keys not masked: %v", keys)
+		}
+	}
+
+	// key-a removed, key-c moved to the front, a new key added
+	sent := map[string]interface{}{"api-keys": []interface{}{keys[2], "key-d", keys[1]}}
+	if err := cfg.Unmask(sent); err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"key-c", "key-d", "key-b"}; !reflect.DeepEqual(sent["api-keys"], want) {
+		t.Fatalf("api-keys = %v, want %v", sent["api-keys"], want)
+	}
+
+	stale := map[string]interface{}{"api-keys": []interface{}{maskOf("key-z")}}
+	if err := cfg.Unmask(stale); err == nil {
+		t.Fatal("mask of a secret that is not stored accepted")
+	}
+	bare := map[string]interface{}{"api-keys": []interface{}{SecretMask}}
+	if err := cfg.Unmask(bare); err == nil {
+		t.Fatal("bare mask accepted with several stored keys")
+	}
+}
+
+func TestSettingsSecretsMasked(t *testing.T) {
+	settings, err := ParseSettings([]byte(`team:
+  backend-key: backend-secret
+  users:
+    - name: alice
+      keys: [alice-key]
+    - name: bob
+      keys: [bob-key-1, bob-key-2]
+account-hints:
+  - name: work
+    client-key: hint-key
+    account: work@example.com
+rate-limits:
+  rules:
+    - name: ci
+      client-key: ci-key
+      requests-per-minute: 10
+`), "vibeproxy.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	masked, err := settings.MaskedValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"backend-secret", "alice-key", "bob-key", "hint-key", "ci-key"} {
+		if strings.Contains(valuesString(masked), secret) {
+			t.Errorf("%s returned unmasked", secret)
+		}
+	}
+
+	// bob renamed and moved first, his second key dropped
+	team := masked["team"].(map[string]interface{})
+	users := team["users"].([]interface{})
+	alice, bob := users[0].(map[string]interface{}), users[1].(map[string]interface{})
+	bob["name"] = "robert"
+	bob["keys"] = bob["keys"].([]interface{})[:1]
+	team["users"] = []interface{}{bob, alice}
+	if err := settings.Unmask(masked); err != nil {
+		t.Fatal(err)
+	}
+	if err := settings.Update(masked); err != nil {
+		t.Fatal(err)
+	}
+
+	got := settings.Team
+	if got.BackendKey != "backend-secret" || got.Users[0].Name != "robert" ||
+		!reflect.DeepEqual(got.Users[0].Keys, []string{"bob-key-1"}) ||
+		!reflect.DeepEqual(got.Users[1].Keys, []string{"alice-key"}) {
+		t.Fatalf("team = %+v", got)
+	}
+	if settings.AccountHints[0].ClientKey != "hint-key" || settings.RateLimits.Rules[0].ClientKey != "ci-key" {
+		t.Fatalf("client keys = %q, %q", settings.AccountHints[0].ClientKey, settings.RateLimits.Rules[0].ClientKey)
+	}
+}
+
+// valuesString flattens values for searching
+func valuesString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		var b strings.Builder
+		for _, item := range v {
+			b.WriteString(valuesString(item) + "\n")
+		}
+		return b.String()
+	case []interface{}:
+		var b strings.Builder
+		for _, item := range v {
+			b.WriteString(valuesString(item) + "\n")
+		}
+		return b.String()
+	}
+	return ""
+}
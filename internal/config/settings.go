@@ -0,0 +1,641 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/logging"
+	"gopkg.in/yaml.v3"
+)
+
+var logger = logging.Component("Config")
+
+// Settings holds VibeProxy's own settings, loaded from vibeproxy.yaml.
+// The CLIProxyAPI backend keeps its own config.yaml.
+type Settings struct {
+	AccountHints  []AccountHint       `yaml:"account-hints"`
+	Providers     ProvidersConfig     `yaml:"providers"`
+	RateLimits    RateLimitConfig     `yaml:"rate-limits"`
+	UpstreamRetry UpstreamRetryConfig `yaml:"upstream-retry"`
+	Limits        LimitsConfig        `yaml:"limits"`
+	PromptCache   PromptCacheConfig   `yaml:"prompt-cache"`
+	ResponseCache ResponseCacheConfig `yaml:"response-cache"`
+	Recording     RecordingConfig     `yaml:"recording"`
+	Inspector     InspectorConfig     `yaml:"inspector"`
+	Tracing       TracingConfig       `yaml:"tracing"`
+	Logging       LoggingConfig       `yaml:"logging"`
+	Shutdown      ShutdownConfig      `yaml:"shutdown"`
+	Backend       BackendConfig       `yaml:"backend"`
+	Listen        ListenConfig        `yaml:"listen"`
+	TLS           TLSConfig           `yaml:"tls"`
+	UIAuth        UIAuthConfig        `yaml:"ui-auth"`
+	Team          TeamConfig          `yaml:"team"`
+
+	path string // file the settings were loaded from
+	data []byte // its contents, for comments and unknown keys
+}
+
+// AccountHint names the account CLIProxyAPI should use for matching
+// requests. The hint is advisory: CLIProxyAPI picks accounts itself unless
+// it reads the hint. Every non-empty matcher must match; the first matching
+// hint wins.
+type AccountHint struct {
+	Name      string            `yaml:"name"`
+	ClientKey string            `yaml:"client-key"`
+	Headers   map[string]string `yaml:"headers"`
+	Model     string            `yaml:"model"` // glob, e.g. claude-*
+	Path      string            `yaml:"path"`  // glob, e.g. /v1/messages*
+	Account   string            `yaml:"account"`
+}
+
+// ProvidersConfig maps models to the providers serving them and controls
+// the check that a provider is connected before a request is forwarded
+type ProvidersConfig struct {
+	CheckAuth bool `yaml:"check-auth"`
+	// Expired is "forward" to let CLIProxyAPI try refreshing expired
+	// tokens, or "fail" to reject requests when every account that could
+	// serve them has an expired token and no refresh token
+	Expired string          `yaml:"expired"`
+	Models  []ProviderModel `yaml:"models"` // checked before the built-in prefixes
+}
+
+// ProviderModel assigns models to a provider
+type ProviderModel struct {
+	Model    string `yaml:"model"`    // glob
+	Provider string `yaml:"provider"` // claude, codex, gemini, qwen or none
+}
+
+// RateLimitConfig throttles requests before they reach CLIProxyAPI
+type RateLimitConfig struct {
+	// MaxWait is how long a request may queue for capacity; zero rejects
+	// immediately with 429 and Retry-After
+	MaxWait time.Duration   `yaml:"max-wait"`
+	Rules   []RateLimitRule `yaml:"rules"`
+}
+
+// RateLimitRule limits the requests it matches. Every non-empty matcher must
+// match, and all matching requests share the rule's budget unless
+// PerClientKey gives each client key its own.
+type RateLimitRule struct {
+	Name         string `yaml:"name"`
+	Provider     string `yaml:"provider"`
+	Model        string `yaml:"model"` // glob
+	ClientKey    string `yaml:"client-key"`
+	PerClientKey bool   `yaml:"per-client-key"`
+
+	RequestsPerMinute int `yaml:"requests-per-minute"`
+	TokensPerMinute   int `yaml:"tokens-per-minute"` // estimated from request size
+	MaxConcurrent     int `yaml:"max-concurrent"`
+}
+
+// UpstreamRetryConfig controls how rate-limited (429/overloaded) responses
+// from CLIProxyAPI are retried and how long the model cools down afterwards
+type UpstreamRetryConfig struct {
+	MaxRetries int           `yaml:"max-retries"`
+	MaxDelay   time.Duration `yaml:"max-delay"` // longest Retry-After waited inline
+	// DefaultCooldown applies when the response carries no reset hint
+	DefaultCooldown time.Duration `yaml:"default-cooldown"`
+	// CooldownMode is "fail" (immediate 429) or "wait" (sleep up to MaxDelay)
+	CooldownMode string `yaml:"cooldown-mode"`
+}
+
+// LimitsConfig bounds request bodies and how long clients and CLIProxyAPI
+// may take, so a stalled peer cannot hold memory or connections forever
+type LimitsConfig struct {
+	MaxBodyMB int            `yaml:"max-body-mb"` // larger request bodies get 413
+	Client    Timeouts       `yaml:"client"`
+	Upstream  Timeouts       `yaml:"upstream"`
+	Routes    []TimeoutRoute `yaml:"routes"`
+}
+
+// MaxBodyBytes returns the request body size limit in bytes
+func (c LimitsConfig) MaxBodyBytes() int64 {
+	return int64(c.MaxBodyMB) << 20
+}
+
+// Timeouts for one side of a request; zero means no limit
+type Timeouts struct {
+	Dial   time.Duration `yaml:"dial"`   // connecting to CLIProxyAPI; upstream only
+	Header time.Duration `yaml:"header"` // until the request or response head is read
+	Idle   time.Duration `yaml:"idle"`   // longest pause while reading or writing
+	Total  time.Duration `yaml:"total"`  // whole request
+}
+
+// override returns t with the non-zero timeouts of o
+func (t Timeouts) override(o Timeouts) Timeouts {
+	if o.Dial > 0 {
+		t.Dial = o.Dial
+	}
+	if o.Header > 0 {
+		t.Header = o.Header
+	}
+	if o.Idle > 0 {
+		t.Idle = o.Idle
+	}
+	if o.Total > 0 {
+		t.Total = o.Total
+	}
+	return t
+}
+
+func (t Timeouts) negative() bool {
+	return t.Dial < 0 || t.Header < 0 || t.Idle < 0 || t.Total < 0
+}
+
+// TimeoutRoute replaces timeouts for matching requests, e.g. long streaming
+// calls. Every non-empty matcher must match; the first matching route wins.
+// Client header timeouts apply before the route is known and cannot be
+// overridden.
+type TimeoutRoute struct {
+	Name     string   `yaml:"name"`
+	Path     string   `yaml:"path"`   // glob
+	Model    string   `yaml:"model"`  // glob
+	Stream   bool     `yaml:"stream"` // only requests with "stream": true
+	Client   Timeouts `yaml:"client"`
+	Upstream Timeouts `yaml:"upstream"`
+}
+
+// Timeouts returns the client and upstream timeouts for a request, applying
+// the first route accepted by match
+func (c LimitsConfig) Timeouts(match func(TimeoutRoute) bool) (client, upstream Timeouts) {
+	for _, route := range c.Routes {
+		if match(route) {
+			return c.Client.override(route.Client), c.Upstream.override(route.Upstream)
+		}
+	}
+	return c.Client, c.Upstream
+}
+
+// PromptCacheConfig controls automatic Anthropic prompt caching
+type PromptCacheConfig struct {
+	// Enabled adds cache_control breakpoints to the system prompt, tools and
+	// latest message of /v1/messages requests
+	Enabled bool `yaml:"enabled"`
+}
+
+// ResponseCacheConfig controls the local cache of responses to
+// deterministic (temperature 0) requests
+type ResponseCacheConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	TTL            time.Duration `yaml:"ttl"`
+	MaxEntries     int           `yaml:"max-entries"`
+	MaxSizeMB      int           `yaml:"max-size-mb"`
+	MaxEntrySizeMB int           `yaml:"max-entry-size-mb"`
+	// AnyTemperature also caches requests without temperature 0
+	AnyTemperature bool `yaml:"any-temperature"`
+}
+
+// MaxBytes returns the total cache size limit in bytes
+func (c ResponseCacheConfig) MaxBytes() int64 {
+	return int64(c.MaxSizeMB) << 20
+}
+
+// MaxEntryBytes returns the size limit of a single cached response in bytes
+func (c ResponseCacheConfig) MaxEntryBytes() int64 {
+	return int64(c.MaxEntrySizeMB) << 20
+}
+
+// RecordingConfig controls capturing exchanges to disk and replaying them
+// instead of calling the backend
+type RecordingConfig struct {
+	Mode      string `yaml:"mode"` // "", "capture" or "replay"
+	Dir       string `yaml:"dir"`  // defaults to "recordings" in the data directory
+	MaxFiles  int    `yaml:"max-files"`
+	MaxSizeMB int    `yaml:"max-size-mb"`
+	// ReplayTiming re-creates the recorded delays between SSE events
+	ReplayTiming bool `yaml:"replay-timing"`
+	// ReplayFallthrough forwards requests without a recording to the backend
+	ReplayFallthrough bool `yaml:"replay-fallthrough"`
+}
+
+// MaxBytes returns the recording directory size limit in bytes
+func (c RecordingConfig) MaxBytes() int64 {
+	return int64(c.MaxSizeMB) << 20
+}
+
+// InspectorConfig controls the recent-request history shown in the web UI
+type InspectorConfig struct {
+	MaxEntries int  `yaml:"max-entries"` // 0 disables the inspector
+	Bodies     bool `yaml:"bodies"`      // keep request bodies for the diff view; off by default as they hold prompts
+	MaxBodyKB  int  `yaml:"max-body-kb"`
+	// RedactFields are JSON keys whose values are blanked at any depth,
+	// e.g. "content" or "system"
+	RedactFields []string `yaml:"redact-fields"`
+}
+
+// LoggingConfig controls log level, format and the optional log file
+type LoggingConfig struct {
+	Level      string `yaml:"level"`  // debug, info, warn or error
+	Format     string `yaml:"format"` // text or json
+	File       string `yaml:"file"`   // also write logs here; empty logs to stderr only
+	MaxSizeMB  int    `yaml:"max-size-mb"`
+	MaxBackups int    `yaml:"max-backups"`
+}
+
+// ShutdownConfig controls how long VibeProxy waits for in-flight requests
+// when it is asked to stop
+type ShutdownConfig struct {
+	DrainTimeout time.Duration `yaml:"drain-timeout"`
+}
+
+// BackendConfig controls zero-downtime CLIProxyAPI restarts
+type BackendConfig struct {
+	Binary         string        `yaml:"binary"`         // cli-proxy-api to run; found next to vibeproxy or in $PATH when empty
+	AlternatePort  int           `yaml:"alternate-port"` // restarts alternate between 8318 and this; 0 restarts in place
+	ReadyTimeout   time.Duration `yaml:"ready-timeout"`  // wait for a new process to listen
+	DrainTimeout   time.Duration `yaml:"drain-timeout"`  // wait for requests on the old process
+	ReloadOnChange bool          `yaml:"reload-on-change"`
+}
+
+// TracingConfig controls OpenTelemetry trace export over OTLP/HTTP (JSON)
+type TracingConfig struct {
+	Enabled     bool              `yaml:"enabled"`
+	Endpoint    string            `yaml:"endpoint"` // collector traces URL
+	ServiceName string            `yaml:"service-name"`
+	SampleRatio float64           `yaml:"sample-ratio"` // for requests without a sampled parent
+	Headers     map[string]string `yaml:"headers"`      // e.g. collector API keys
+}
+
+// ListenConfig controls where the proxy and the web UI accept connections.
+// Changes take effect after a restart.
+type ListenConfig struct {
+	Proxy ListenerConfig `yaml:"proxy"` // port 8317
+	UI    ListenerConfig `yaml:"ui"`    // port 8319
+}
+
+// ListenerConfig is a TCP port and an optional Unix socket. Only processes
+// of this user, root and allow-uids may connect to the socket where the
+// platform reports peer credentials (Linux).
+type ListenerConfig struct {
+	TCP        bool   `yaml:"tcp"`         // listen on the TCP port
+	Bind       string `yaml:"bind"`        // address for the TCP port; empty means all interfaces
+	TLS        bool   `yaml:"tls"`         // serve HTTPS on the TCP port
+	Socket     string `yaml:"socket"`      // also listen on this Unix socket
+	SocketMode string `yaml:"socket-mode"` // octal file mode, e.g. "0600"
+	AllowUIDs  []int  `yaml:"allow-uids"`
+}
+
+// Mode returns the socket's file mode
+func (c ListenerConfig) Mode() os.FileMode {
+	mode, _ := strconv.ParseUint(c.SocketMode, 8, 32)
+	return os.FileMode(mode)
+}
+
+// validate checks the listener named name
+func (c ListenerConfig) validate(name string) error {
+	if !c.TCP && c.Socket == "" {
+		return fmt.Errorf("listen.%s needs tcp or a socket", name)
+	}
+	if mode, err := strconv.ParseUint(c.SocketMode, 8, 32); err != nil || mode > 0777 {
+		return fmt.Errorf("listen.%s.socket-mode must be an octal file mode like \"0600\", got %q", name, c.SocketMode)
+	}
+	return nil
+}
+
+// TLSConfig locates the certificate listeners with tls enabled serve. The
+// files are reloaded when they change.
+type TLSConfig struct {
+	Cert     string `yaml:"cert"`      // default <config-dir>/tls/server.crt
+	Key      string `yaml:"key"`       // default <config-dir>/tls/server.key
+	ClientCA string `yaml:"client-ca"` // require client certificates signed by this CA (mTLS)
+}
+
+// UIAuthConfig protects the web UI API. Clients other than this machine
+// must log in with the token; browsers must also pass Origin, Host and CSRF
+// checks before changing anything.
+type UIAuthConfig struct {
+	Token          string        `yaml:"token"`           // default is generated into <config-dir>/ui-token
+	RequireLogin   bool          `yaml:"require-login"`   // also for loopback clients, e.g. behind a reverse proxy
+	SessionTTL     time.Duration `yaml:"session-ttl"`     // how long a login lasts
+	AllowedHosts   []string      `yaml:"allowed-hosts"`   // names the UI is reached by besides localhost, this hostname and IPs
+	TrustedOrigins []string      `yaml:"trusted-origins"` // other origins allowed to call the API, e.g. https://vibe.example.com
+}
+
+// TeamConfig shares one instance among named users. When enabled, every
+// proxy request must carry one of a user's keys, which is replaced by
+// BackendKey before the request reaches CLIProxyAPI.
+type TeamConfig struct {
+	Enabled    bool       `yaml:"enabled"`
+	BackendKey string     `yaml:"backend-key"` // default: the first api-keys entry of config.yaml
+	Users      []TeamUser `yaml:"users"`
+}
+
+// TeamUser is one member of the team. Zero quotas are unlimited and an
+// empty model list allows every model.
+type TeamUser struct {
+	Name          string   `yaml:"name"`
+	Role          string   `yaml:"role"` // admin or member (default)
+	Keys          []string `yaml:"keys"`
+	DailyRequests int      `yaml:"daily-requests"`
+	DailyTokens   int      `yaml:"daily-tokens"` // input plus output tokens
+	Models        []string `yaml:"models"`       // globs, e.g. claude-*
+}
+
+// IsAdmin reports whether the user may manage accounts and settings
+func (u TeamUser) IsAdmin() bool {
+	return u.Role == "admin"
+}
+
+// validate checks that users have unique names and keys
+func (c TeamConfig) validate() error {
+	names := make(map[string]bool)
+	keys := make(map[string]string)
+	for i, user := range c.Users {
+		if user.Name == "" {
+			return fmt.Errorf("team user %d has no name", i+1)
+		}
+		if names[user.Name] {
+			return fmt.Errorf("team user %q is listed twice", user.Name)
+		}
+		names[user.Name] = true
+		switch user.Role {
+		case "", "member", "admin":
+		default:
+			return fmt.Errorf("team user %q: role must be \"admin\" or \"member\", got %q", user.Name, user.Role)
+		}
+		if user.DailyRequests < 0 || user.DailyTokens < 0 {
+			return fmt.Errorf("team user %q has a negative quota", user.Name)
+		}
+		for _, key := range user.Keys {
+			if len(key) < 8 {
+				return fmt.Errorf("team user %q has a key shorter than 8 characters", user.Name)
+			}
+			if other, ok := keys[key]; ok {
+				return fmt.Errorf("team users %q and %q share a key", other, user.Name)
+			}
+			keys[key] = user.Name
+		}
+	}
+	if c.Enabled && len(c.Users) == 0 {
+		return fmt.Errorf("team is enabled but has no users")
+	}
+	return nil
+}
+
+// DefaultSettings returns the settings used when no vibeproxy.yaml exists
+func DefaultSettings() *Settings {
+	return &Settings{
+		Providers: ProvidersConfig{
+			CheckAuth: true,
+			Expired:   "forward",
+		},
+		UpstreamRetry: UpstreamRetryConfig{
+			MaxRetries:      2,
+			MaxDelay:        30 * time.Second,
+			DefaultCooldown: 10 * time.Second,
+			CooldownMode:    "fail",
+		},
+		Limits: LimitsConfig{
+			MaxBodyMB: 32,
+			Client: Timeouts{
+				Header: 30 * time.Second,
+				Idle:   2 * time.Minute,
+			},
+			Upstream: Timeouts{
+				Dial:   10 * time.Second,
+				Header: 10 * time.Minute,
+				Idle:   5 * time.Minute,
+			},
+		},
+		ResponseCache: ResponseCacheConfig{
+			TTL:            24 * time.Hour,
+			MaxEntries:     1000,
+			MaxSizeMB:      256,
+			MaxEntrySizeMB: 8,
+		},
+		Recording: RecordingConfig{
+			MaxFiles:  1000,
+			MaxSizeMB: 512,
+		},
+		Inspector: InspectorConfig{
+			MaxEntries: 200,
+			MaxBodyKB:  256,
+		},
+		Tracing: TracingConfig{
+			Endpoint:    "http://localhost:4318/v1/traces",
+			ServiceName: "vibeproxy",
+			SampleRatio: 1,
+		},
+		Logging: LoggingConfig{
+			Level:      "info",
+			Format:     "text",
+			MaxSizeMB:  10,
+			MaxBackups: 3,
+		},
+		Shutdown: ShutdownConfig{
+			DrainTimeout: 30 * time.Second,
+		},
+		Listen: ListenConfig{
+			Proxy: ListenerConfig{TCP: true, SocketMode: "0600"},
+			UI:    ListenerConfig{TCP: true, Bind: "127.0.0.1", SocketMode: "0600"},
+		},
+		UIAuth: UIAuthConfig{
+			SessionTTL: 24 * time.Hour,
+		},
+		Backend: BackendConfig{
+			AlternatePort:  8320,
+			ReadyTimeout:   15 * time.Second,
+			DrainTimeout:   30 * time.Second,
+			ReloadOnChange: true,
+		},
+	}
+}
+
+// LoadSettings reads settings from path, falling back to defaults if the file
+// does not exist
+func LoadSettings(path string) (*Settings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read settings: %w", err)
+	}
+
+	settings, err := ParseSettings(data, path)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		logger.Info("Loaded settings", "path", path)
+	}
+	return settings, nil
+}
+
+// ParseSettings decodes vibeproxy.yaml contents over the defaults and
+// validates them; path is used in errors and as the default save location
+func ParseSettings(data []byte, path string) (*Settings, error) {
+	settings := DefaultSettings()
+	if err := yaml.Unmarshal(data, settings); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	settings.path, settings.data = path, data
+	return settings, nil
+}
+
+// Validate checks that the settings are usable
+func (s *Settings) Validate() error {
+	for i, rule := range s.AccountHints {
+		if rule.Account == "" {
+			return fmt.Errorf("account hint %d (%s) has no account", i+1, rule.Name)
+		}
+	}
+
+	switch s.Providers.Expired {
+	case "fail", "forward":
+	default:
+		return fmt.Errorf("providers.expired must be \"fail\" or \"forward\", got %q", s.Providers.Expired)
+	}
+	for i, mapping := range s.Providers.Models {
+		switch mapping.Provider {
+		case "claude", "codex", "gemini", "qwen", "none":
+		default:
+			return fmt.Errorf("providers model %d (%s) has provider %q, want claude, codex, gemini, qwen or none", i+1, mapping.Model, mapping.Provider)
+		}
+		if mapping.Model == "" {
+			return fmt.Errorf("providers model %d has no model", i+1)
+		}
+	}
+
+	for i, rule := range s.RateLimits.Rules {
+		if rule.RequestsPerMinute < 0 || rule.TokensPerMinute < 0 || rule.MaxConcurrent < 0 {
+			return fmt.Errorf("rate limit rule %d (%s) has a negative limit", i+1, rule.Name)
+		}
+	}
+
+	if s.Limits.MaxBodyMB <= 0 {
+		return fmt.Errorf("limits.max-body-mb must be positive")
+	}
+	if s.Limits.Client.negative() || s.Limits.Upstream.negative() {
+		return fmt.Errorf("limits timeouts must not be negative")
+	}
+	for i, route := range s.Limits.Routes {
+		if route.Client.negative() || route.Upstream.negative() {
+			return fmt.Errorf("limits route %d (%s) has a negative timeout", i+1, route.Name)
+		}
+	}
+
+	if cache := s.ResponseCache; cache.Enabled && (cache.TTL <= 0 || cache.MaxEntries <= 0 || cache.MaxSizeMB <= 0 || cache.MaxEntrySizeMB <= 0) {
+		return fmt.Errorf("response-cache ttl, max-entries, max-size-mb and max-entry-size-mb must be positive")
+	}
+
+	if s.Inspector.MaxEntries < 0 || s.Inspector.MaxBodyKB < 0 {
+		return fmt.Errorf("inspector max-entries and max-body-kb must not be negative")
+	}
+
+	if tracing := s.Tracing; tracing.Enabled && (tracing.Endpoint == "" || tracing.SampleRatio < 0 || tracing.SampleRatio > 1) {
+		return fmt.Errorf("tracing needs an endpoint and a sample-ratio between 0 and 1")
+	}
+
+	switch strings.ToLower(s.Logging.Level) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		return fmt.Errorf("logging.level must be debug, info, warn or error, got %q", s.Logging.Level)
+	}
+	switch strings.ToLower(s.Logging.Format) {
+	case "text", "json":
+	default:
+		return fmt.Errorf("logging.format must be \"text\" or \"json\", got %q", s.Logging.Format)
+	}
+	if logs := s.Logging; logs.File != "" && (logs.MaxSizeMB <= 0 || logs.MaxBackups < 0) {
+		return fmt.Errorf("logging max-size-mb must be positive and max-backups must not be negative")
+	}
+
+	if s.Shutdown.DrainTimeout < 0 {
+		return fmt.Errorf("shutdown.drain-timeout must not be negative")
+	}
+
+	if backend := s.Backend; backend.AlternatePort < 0 || backend.AlternatePort > 65535 || backend.ReadyTimeout <= 0 || backend.DrainTimeout < 0 {
+		return fmt.Errorf("backend alternate-port must be a port number, ready-timeout positive and drain-timeout not negative")
+	}
+
+	if err := s.Listen.Proxy.validate("proxy"); err != nil {
+		return err
+	}
+	if err := s.Listen.UI.validate("ui"); err != nil {
+		return err
+	}
+	if (s.TLS.Cert == "") != (s.TLS.Key == "") {
+		return fmt.Errorf("tls.cert and tls.key must be set together")
+	}
+	if err := s.Team.validate(); err != nil {
+		return err
+	}
+	if s.UIAuth.SessionTTL <= 0 {
+		return fmt.Errorf("ui-auth.session-ttl must be positive")
+	}
+	if token := s.UIAuth.Token; token != "" && len(token) < 16 {
+		return fmt.Errorf("ui-auth.token must be at least 16 characters")
+	}
+
+	switch s.Recording.Mode {
+	case "", "capture", "replay":
+	default:
+		return fmt.Errorf("recording.mode must be \"capture\" or \"replay\", got %q", s.Recording.Mode)
+	}
+	if rec := s.Recording; rec.Mode != "" && (rec.MaxFiles <= 0 || rec.MaxSizeMB <= 0) {
+		return fmt.Errorf("recording max-files and max-size-mb must be positive")
+	}
+
+	switch s.UpstreamRetry.CooldownMode {
+	case "fail", "wait":
+	default:
+		return fmt.Errorf("upstream-retry.cooldown-mode must be \"fail\" or \"wait\", got %q", s.UpstreamRetry.CooldownMode)
+	}
+
+	return nil
+}
+
+// Values returns the settings keyed like vibeproxy.yaml
+func (s *Settings) Values() (map[string]interface{}, error) {
+	return yamlValues(s)
+}
+
+// Update sets the keys present in values, keyed like vibeproxy.yaml, and
+// validates the result
+func (s *Settings) Update(values map[string]interface{}) error {
+	if err := updateValues(s, values); err != nil {
+		return err
+	}
+	return s.Validate()
+}
+
+// Modified reports whether the settings differ from the file they were
+// loaded from
+func (s *Settings) Modified() bool {
+	before, err := ParseSettings(s.data, s.path)
+	return err != nil || !sameValues(before, s)
+}
+
+// Marshal returns the loaded file with changed settings written back
+func (s *Settings) Marshal() ([]byte, error) {
+	before, err := ParseSettings(s.data, s.path)
+	if err != nil {
+		return nil, err
+	}
+	return updateYAML(s.data, before, s)
+}
+
+// Save validates the settings and writes them to path
+func (s *Settings) Save(path string) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	data, err := s.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode settings: %w", err)
+	}
+	if err := writeFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	s.path, s.data = path, data
+	return nil
+}
+
+// Path returns the file the settings were loaded from or last saved to
+func (s *Settings) Path() string {
+	return s.path
+}
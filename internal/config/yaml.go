@@ -0,0 +1,268 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// updateYAML rewrites the keys of data whose values differ between before
+// (data as decoded) and after, keeping comments, key order and keys the Go
+// types do not know about
+func updateYAML(data []byte, before, after interface{}) ([]byte, error) {
+	if len(bytes.TrimSpace(data)) > 0 && sameValues(before, after) {
+		return data, nil
+	}
+
+	var doc yaml.Node
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("top level is not a mapping")
+	}
+
+	var oldNode, newNode yaml.Node
+	if err := oldNode.Encode(before); err != nil {
+		return nil, err
+	}
+	if err := newNode.Encode(after); err != nil {
+		return nil, err
+	}
+	mergeMapping(root, &oldNode, &newNode)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return restoreBlankLines(data, buf.Bytes()), nil
+}
+
+// restoreBlankLines puts the blank lines of original, which the encoder
+// drops, back in front of the same lines of updated. Lines are matched in
+// order by their text up to the value, so changed values keep their spacing.
+func restoreBlankLines(original, updated []byte) []byte {
+	type gap struct {
+		before string // lineKey of the line after the blank lines
+		blanks int
+	}
+	var gaps []gap
+	blanks := 0
+	for _, line := range strings.Split(string(original), "\n") {
+		if strings.TrimSpace(line) == "" {
+			blanks++
+			continue
+		}
+		if blanks > 0 {
+			gaps = append(gaps, gap{lineKey(line), blanks})
+		}
+		blanks = 0
+	}
+
+	var out []string
+	next := 0
+	for _, line := range strings.Split(string(updated), "\n") {
+		key := lineKey(line)
+		for i := next; key != "" && i < len(gaps); i++ {
+			if gaps[i].before != key {
+				continue
+			}
+			if len(out) > 0 && strings.TrimSpace(out[len(out)-1]) != "" {
+				for n := 0; n < gaps[i].blanks; n++ {
+					out = append(out, "")
+				}
+			}
+			next = i + 1
+			break
+		}
+		out = append(out, line)
+	}
+	return []byte(strings.Join(out, "\n"))
+}
+
+// lineKey identifies a YAML line: comments by their text, other lines by
+// what comes before the first colon
+func lineKey(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return trimmed
+	}
+	if i := strings.Index(line, ":"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// mergeMapping applies the keys that changed from before to after onto dst
+func mergeMapping(dst, before, after *yaml.Node) {
+	for i := 0; i+1 < len(after.Content); i += 2 {
+		key, value := after.Content[i].Value, after.Content[i+1]
+		old := mappingValue(before, key)
+		if old != nil && sameNode(old, value) {
+			continue
+		}
+
+		current := mappingValue(dst, key)
+		if current == nil && value.Kind == yaml.MappingNode && old != nil && old.Kind == yaml.MappingNode {
+			// Add only the changed keys of a section the file leaves out
+			current = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			dst.Content = append(dst.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, current)
+		}
+		switch {
+		case current == nil:
+			dst.Content = append(dst.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+		case current.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode && old != nil && old.Kind == yaml.MappingNode:
+			mergeMapping(current, old, value)
+		default:
+			value.HeadComment, value.LineComment, value.FootComment = current.HeadComment, current.LineComment, current.FootComment
+			*current = *value
+		}
+	}
+
+	// Keys the new value no longer has, e.g. emptied omitempty fields
+	for i := 0; i+1 < len(before.Content); i += 2 {
+		key := before.Content[i].Value
+		if mappingValue(after, key) != nil {
+			continue
+		}
+		for j := 0; j+1 < len(dst.Content); j += 2 {
+			if dst.Content[j].Value == key {
+				dst.Content = append(dst.Content[:j], dst.Content[j+2:]...)
+				break
+			}
+		}
+	}
+}
+
+// mappingValue returns the value node for key, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// sameNode compares two encoded values
+func sameNode(a, b *yaml.Node) bool {
+	ab, errA := yaml.Marshal(a)
+	bb, errB := yaml.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ab, bb)
+}
+
+// writeFile replaces path with data atomically, keeping its permissions
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// yamlValues returns v as generic values keyed like its YAML file, for JSON
+// APIs
+func yamlValues(v interface{}) (map[string]interface{}, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// sameValues reports whether a and b encode to the same YAML values
+func sameValues(a, b interface{}) bool {
+	av, errA := yamlValues(a)
+	bv, errB := yamlValues(b)
+	return errA == nil && errB == nil && reflect.DeepEqual(av, bv)
+}
+
+// updateValues decodes values, keyed like the YAML file, over v. Keys that
+// are left out keep their value; unknown keys are an error. Maps that are
+// given replace the stored ones, so their entries can be removed.
+func updateValues(v interface{}, values map[string]interface{}) error {
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	clearMaps(reflect.ValueOf(v), values)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// clearMaps empties the map fields of the struct v points to that values
+// sets, so decoding values over v starts them afresh instead of adding to
+// them. Nested sections are cleared likewise.
+func clearMaps(v reflect.Value, values map[string]interface{}) {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		value, ok := values[name]
+		if name == "" || name == "-" || !ok {
+			continue
+		}
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Map:
+			field.Set(reflect.Zero(field.Type()))
+		case reflect.Struct, reflect.Pointer:
+			if section, ok := value.(map[string]interface{}); ok {
+				clearMaps(field, section)
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const commentedConfig = `# =========================
+# CLIProxyAPI Configuration
+# =========================
+
+# Backend port for CLIProxyAPI (DO NOT CHANGE)
+port: 8318
+
+# Directory where authentication tokens are stored
+auth-dir: ~/.cli-proxy-api
+
+# Remote management configuration
+remote-management:
+  allow-remote: false # local only
+  secret-key: ""
+  disable-control-panel: false
+
+# Client API keys (local access only)
+api-keys:
+  - dummy-not-used
+
+# Keys VibeProxy does not know about
+claude-api-key:
+  - api-key: sk-test
+    base-url: https://example.com
+
+# Debug mode
+debug: false
+
+request-retry: 3
+`
+
+const commentedSettings = `# VibeProxy settings
+
+# Proxy logging
+logging:
+  level: info # or debug
+  format: text
+
+# Not a VibeProxy setting
+x-notes:
+  owner: me
+
+response-cache:
+  enabled: false
+  ttl: 24h
+`
+
+// saveConfig loads data as config.yaml, lets change modify it and returns
+// what Save writes
+func saveConfig(t *testing.T, data string, change func(*CLIProxyAPIConfig)) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadCLIProxyAPIConfig(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	change(cfg)
+	if err := cfg.Save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// assertOrder fails unless every string appears in out, in the given order
+func assertOrder(t *testing.T, out string, want ...string) {
+	t.Helper()
+	pos := 0
+	for _, s := range want {
+		i := strings.Index(out[pos:], s)
+		if i < 0 {
+			t.Fatalf("%q missing or out of order in:\n%s", s, out)
+		}
+		pos += i + len(s)
+	}
+}
+
+func TestCLIProxyAPIConfigUnchangedSaveIsIdentical(t *testing.T) {
+	out := saveConfig(t, commentedConfig, func(*CLIProxyAPIConfig) {})
+	if out != commentedConfig {
+		t.Fatalf("unchanged save rewrote the file:\n%s", out)
+	}
+}
+
+func TestCLIProxyAPIConfigSaveKeepsCommentsOrderAndUnknownKeys(t *testing.T) {
+	out := saveConfig(t, commentedConfig, func(cfg *CLIProxyAPIConfig) {
+		err := cfg.Update(map[string]interface{}{
+			"debug":             true,
+			"remote-management": map[string]interface{}{"allow-remote": true},
+		})
+		if err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	})
+
+	assertOrder(t, out,
+		"# CLIProxyAPI Configuration",
+		"# Backend port for CLIProxyAPI (DO NOT CHANGE)",
+		"port: 8318",
+		"# Directory where authentication tokens are stored",
+		"auth-dir: ~/.cli-proxy-api",
+		"# Remote management configuration",
+		"remote-management:",
+		"allow-remote: true # local only",
+		"secret-key: \"\"",
+		"# Client API keys (local access only)",
+		"- dummy-not-used",
+		"# Keys VibeProxy does not know about",
+		"claude-api-key:",
+		"api-key: sk-test",
+		"base-url: https://example.com",
+		"# Debug mode",
+		"debug: true",
+		"request-retry: 3",
+	)
+
+	for _, section := range []string{"\n\n# Remote management configuration\n", "\n\n# Debug mode\ndebug: true\n\nrequest-retry: 3\n"} {
+		if !strings.Contains(out, section) {
+			t.Fatalf("blank lines around %q lost in:\n%s", strings.TrimSpace(section), out)
+		}
+	}
+
+	cfg, err := ParseCLIProxyAPIConfig([]byte(out), "config.yaml")
+	if err != nil {
+		t.Fatalf("saved file does not parse: %v", err)
+	}
+	if !cfg.Debug || !cfg.RemoteManagement.AllowRemote {
+		t.Fatalf("changes not saved: debug=%v allow-remote=%v", cfg.Debug, cfg.RemoteManagement.AllowRemote)
+	}
+}
+
+func TestSettingsSaveKeepsCommentsAndUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vibeproxy.yaml")
+	if err := os.WriteFile(path, []byte(commentedSettings), 0644); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := LoadSettings(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if err := settings.Save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, []byte(commentedSettings)) {
+		t.Fatalf("unchanged save rewrote the file:\n%s", out)
+	}
+
+	err = settings.Update(map[string]interface{}{
+		"logging": map[string]interface{}{"level": "debug"},
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if err := settings.Save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	out, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOrder(t, string(out),
+		"# VibeProxy settings",
+		"# Proxy logging",
+		"logging:",
+		"level: debug # or debug",
+		"format: text",
+		"# Not a VibeProxy setting",
+		"x-notes:",
+		"owner: me",
+		"response-cache:",
+		"ttl: 24h",
+	)
+}
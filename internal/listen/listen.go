@@ -0,0 +1,134 @@
+// Package listen opens the TCP ports and Unix sockets the proxy and web UI
+// accept connections on, and restricts sockets to trusted local users
+package listen
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+	"github.com/automazeio/vibeproxy/internal/logging"
+)
+
+var logger = logging.Component("Listen")
+
+// errUnsupported is returned by peerUID where the platform cannot report
+// the peer of a Unix socket
+var errUnsupported = errors.New("peer credentials are not supported on this platform")
+
+// Open listens on the TCP port and the Unix socket cfg enables. The TCP
+// port serves TLS with tlsConfig when cfg.TLS is set; the socket never does.
+func Open(cfg config.ListenerConfig, port int, tlsConfig *tls.Config) ([]net.Listener, error) {
+	if cfg.TCP && cfg.TLS && tlsConfig == nil {
+		return nil, errors.New("tls is enabled but no certificate is loaded")
+	}
+	var listeners []net.Listener
+	if cfg.TCP {
+		l, err := net.Listen("tcp", net.JoinHostPort(cfg.Bind, strconv.Itoa(port)))
+		if err != nil {
+			return nil, err
+		}
+		if cfg.TLS {
+			l = tls.NewListener(l, tlsConfig)
+		}
+		listeners = append(listeners, l)
+	}
+	if cfg.Socket != "" {
+		l, err := Unix(cfg.Socket, cfg.Mode())
+		if err != nil {
+			Close(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, PeerFilter(l, cfg.AllowUIDs))
+	}
+	return listeners, nil
+}
+
+// Close closes every listener
+func Close(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
+
+// Addrs describes the listeners for logs, e.g. "[::]:8317 /run/vibeproxy.sock"
+func Addrs(listeners []net.Listener) []string {
+	addrs := make([]string, len(listeners))
+	for i, l := range listeners {
+		addrs[i] = l.Addr().String()
+	}
+	return addrs
+}
+
+// Unix listens on a Unix socket at path with the given file mode. A socket
+// file left by an unclean exit is replaced; one a live process still
+// listens on is an error.
+func Unix(path string, mode os.FileMode) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s is in use by another process", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// PeerFilter wraps a Unix socket listener so connections from processes of
+// other users are closed on accept. This user, root and allowUIDs are let
+// through. Where the platform cannot tell, the socket's file mode is the
+// only protection and l is returned unchanged.
+func PeerFilter(l net.Listener, allowUIDs []int) net.Listener {
+	if _, err := peerUID(nil); errors.Is(err, errUnsupported) {
+		logger.Warn("Cannot check peer credentials, relying on socket permissions", "socket", l.Addr().String())
+		return l
+	}
+	allowed := append([]int{os.Getuid(), 0}, allowUIDs...)
+	return &peerListener{Listener: l, allowed: allowed}
+}
+
+// peerListener accepts only connections from allowed users
+type peerListener struct {
+	net.Listener
+	allowed []int
+}
+
+// Accept returns the next connection from an allowed user
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		uid, err := peerUID(conn)
+		if err != nil {
+			logger.Warn("Rejected connection without peer credentials", "socket", l.Addr().String(), "error", err)
+			conn.Close()
+			continue
+		}
+		if !slices.Contains(l.allowed, uid) {
+			logger.Warn("Rejected connection from another user", "socket", l.Addr().String(), "uid", uid)
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package listen
+
+import "net"
+
+// peerUID cannot tell the peer of a Unix socket on this platform
+func peerUID(conn net.Conn) (int, error) {
+	return 0, errUnsupported
+}
@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Entry is one buffered log record as served to the web UI
+type Entry struct {
+	Seq       uint64                 `json:"seq"` // increases by one per record
+	Time      time.Time              `json:"time"`
+	Level     string                 `json:"level"`
+	Component string                 `json:"component,omitempty"`
+	Message   string                 `json:"message"`
+	Attrs     map[string]interface{} `json:"attrs,omitempty"`
+
+	level slog.Level
+}
+
+// buffer keeps the most recent entries in a ring
+type buffer struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+	seq     uint64
+}
+
+// newBuffer creates a ring holding size entries
+func newBuffer(size int) *buffer {
+	return &buffer{entries: make([]Entry, size)}
+}
+
+// add stores a record with the attributes attached to its logger
+func (b *buffer) add(component string, attrs []slog.Attr, r slog.Record) {
+	entry := Entry{
+		Time:      r.Time,
+		Level:     r.Level.String(),
+		Component: component,
+		Message:   r.Message,
+		level:     r.Level,
+	}
+	if len(attrs) > 0 || r.NumAttrs() > 0 {
+		entry.Attrs = make(map[string]interface{}, len(attrs)+r.NumAttrs())
+		for _, a := range attrs {
+			entry.Attrs[a.Key] = a.Value.Resolve().Any()
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			value := a.Value.Resolve().Any()
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+			entry.Attrs[a.Key] = value
+			return true
+		})
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	entry.Seq = b.seq
+	b.entries[b.next] = entry
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// recent returns matching entries after sequence number after, oldest
+// first, keeping the newest limit
+func (b *buffer) recent(limit int, minLevel slog.Level, component string, after uint64) []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ordered := b.entries[:b.next]
+	if b.full {
+		ordered = append(append([]Entry(nil), b.entries[b.next:]...), b.entries[:b.next]...)
+	}
+
+	result := []Entry{}
+	for _, entry := range ordered {
+		if entry.Seq <= after || entry.level < minLevel || (component != "" && entry.Component != component) {
+			continue
+		}
+		result = append(result, entry)
+	}
+	if limit > 0 && len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result
+}
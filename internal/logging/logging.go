@@ -0,0 +1,170 @@
+// Package logging provides VibeProxy's log/slog setup: a process-wide level,
+// text or JSON output to stderr and an optional rotating file, per-component
+// loggers and an in-memory buffer of recent entries for the web UI.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Options configures the log output
+type Options struct {
+	Level      string // debug, info, warn or error
+	Format     string // text or json
+	File       string // also write to this file when set
+	MaxSizeMB  int    // rotate the file when it grows past this size
+	MaxBackups int    // rotated files to keep
+}
+
+var (
+	level = new(slog.LevelVar)
+
+	mu      sync.RWMutex
+	output  slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	file    io.Closer
+	entries = newBuffer(2000)
+)
+
+// Setup replaces the log output and level. Component loggers created before
+// Setup follow the new configuration.
+func Setup(opts Options) error {
+	lvl, err := ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stderr
+	var rotating *rotatingFile
+	if opts.File != "" {
+		rotating, err = openRotatingFile(opts.File, int64(opts.MaxSizeMB)*1024*1024, opts.MaxBackups)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		w = io.MultiWriter(os.Stderr, rotating)
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		h = slog.NewTextHandler(w, handlerOpts)
+	case "json":
+		h = slog.NewJSONHandler(w, handlerOpts)
+	default:
+		if rotating != nil {
+			rotating.Close()
+		}
+		return fmt.Errorf("unknown log format '%s' (expected text or json)", opts.Format)
+	}
+
+	mu.Lock()
+	previous := file
+	output = h
+	file = nil
+	if rotating != nil {
+		file = rotating
+	}
+	mu.Unlock()
+	level.Set(lvl)
+
+	if previous != nil {
+		previous.Close()
+	}
+
+	slog.SetDefault(slog.New(&componentHandler{}))
+	return nil
+}
+
+// Close flushes and closes the log file, if any
+func Close() error {
+	mu.Lock()
+	f := file
+	file = nil
+	mu.Unlock()
+	if f != nil {
+		return f.Close()
+	}
+	return nil
+}
+
+// ParseLevel converts a configured level name; an empty name means info
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level '%s' (expected debug, info, warn or error)", name)
+}
+
+// Component returns a logger whose records carry component=name
+func Component(name string) *slog.Logger {
+	return slog.New(&componentHandler{component: name})
+}
+
+// Recent returns up to limit buffered entries at or above minLevel with a
+// sequence number above after, newest last; an empty component matches all
+// components
+func Recent(limit int, minLevel slog.Level, component string, after uint64) []Entry {
+	return entries.recent(limit, minLevel, component, after)
+}
+
+// componentHandler resolves the current output on every record so loggers
+// can be created at package init, before Setup runs
+type componentHandler struct {
+	component string
+	ops       []func(slog.Handler) slog.Handler
+	attrs     []slog.Attr // record-level attributes for the buffer, ignoring groups
+}
+
+func (h *componentHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= level.Level()
+}
+
+func (h *componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	entries.add(h.component, h.attrs, r)
+
+	mu.RLock()
+	out := output
+	mu.RUnlock()
+
+	if h.component != "" {
+		out = out.WithAttrs([]slog.Attr{slog.String("component", h.component)})
+	}
+	for _, op := range h.ops {
+		out = op(out)
+	}
+	return out.Handle(ctx, r)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := h.clone()
+	clone.ops = append(clone.ops, func(out slog.Handler) slog.Handler { return out.WithAttrs(attrs) })
+	clone.attrs = append(clone.attrs, attrs...)
+	return clone
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	clone := h.clone()
+	clone.ops = append(clone.ops, func(out slog.Handler) slog.Handler { return out.WithGroup(name) })
+	return clone
+}
+
+func (h *componentHandler) clone() *componentHandler {
+	return &componentHandler{
+		component: h.component,
+		ops:       append([]func(slog.Handler) slog.Handler(nil), h.ops...),
+		attrs:     append([]slog.Attr(nil), h.attrs...),
+	}
+}
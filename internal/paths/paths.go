@@ -0,0 +1,272 @@
+// Package paths resolves where VibeProxy keeps its config files, its data
+// and the cli-proxy-api binary
+package paths
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// Environment variables that override the lookup
+const (
+	EnvConfigDir = "VIBEPROXY_CONFIG_DIR"
+	EnvDataDir   = "VIBEPROXY_DATA_DIR"
+	EnvBinary    = "VIBEPROXY_CLI_PROXY_API"
+)
+
+// Where a location came from
+const (
+	SourceFlag       = "flag"
+	SourceEnv        = "env"
+	SourceSettings   = "vibeproxy.yaml"
+	SourceUser       = "user"
+	SourceSystem     = "system"
+	SourceExecutable = "executable"
+	SourcePath       = "PATH"
+	SourceDefault    = "default"
+)
+
+// systemConfigDir is checked after the user's config directory
+const systemConfigDir = "/etc/vibeproxy"
+
+// Options are locations given on the command line; empty ones are looked up
+type Options struct {
+	ConfigDir string
+	DataDir   string
+	Binary    string
+}
+
+// Location is a resolved path and where it came from
+type Location struct {
+	Path   string `json:"path"`
+	Source string `json:"source"`
+}
+
+// Paths are the directories and binary VibeProxy uses
+type Paths struct {
+	ConfigDir Location `json:"configDir"`
+	DataDir   Location `json:"dataDir"`
+	Binary    Location `json:"binary"` // empty until ResolveBinary succeeds
+}
+
+// Config returns the path of CLIProxyAPI's config.yaml
+func (p *Paths) Config() string {
+	return filepath.Join(p.ConfigDir.Path, "config.yaml")
+}
+
+// Settings returns the path of vibeproxy.yaml
+func (p *Paths) Settings() string {
+	return filepath.Join(p.ConfigDir.Path, "vibeproxy.yaml")
+}
+
+// Recordings returns the default directory for captured exchanges
+func (p *Paths) Recordings() string {
+	return filepath.Join(p.DataDir.Path, "recordings")
+}
+
+// TeamUsage returns the file keeping team members' daily usage
+func (p *Paths) TeamUsage() string {
+	return filepath.Join(p.DataDir.Path, "team-usage.json")
+}
+
+// TLS returns the directory of the local CA and generated certificates
+func (p *Paths) TLS() string {
+	return filepath.Join(p.ConfigDir.Path, "tls")
+}
+
+// UIToken returns the file holding the generated web UI login token
+func (p *Paths) UIToken() string {
+	return filepath.Join(p.ConfigDir.Path, "ui-token")
+}
+
+// Resolve finds the config and data directories.
+//
+// The config directory is the --config-dir flag, then $VIBEPROXY_CONFIG_DIR,
+// then the first of $XDG_CONFIG_HOME/vibeproxy, /etc/vibeproxy and the
+// executable's directory that holds config.yaml or vibeproxy.yaml. Without
+// any, the user directory is created. The data directory is the --data-dir
+// flag, then $VIBEPROXY_DATA_DIR, then $XDG_DATA_HOME/vibeproxy.
+func Resolve(opts Options) (*Paths, error) {
+	p := &Paths{}
+
+	userConfig, err := userConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case opts.ConfigDir != "":
+		p.ConfigDir = Location{opts.ConfigDir, SourceFlag}
+	case os.Getenv(EnvConfigDir) != "":
+		p.ConfigDir = Location{os.Getenv(EnvConfigDir), SourceEnv}
+	default:
+		p.ConfigDir = Location{userConfig, SourceDefault}
+		candidates := []Location{{userConfig, SourceUser}}
+		if runtime.GOOS != "windows" {
+			candidates = append(candidates, Location{systemConfigDir, SourceSystem})
+		}
+		if dir, err := executableDir(); err == nil {
+			candidates = append(candidates, Location{dir, SourceExecutable})
+		}
+		for _, candidate := range candidates {
+			if hasConfig(candidate.Path) {
+				p.ConfigDir = candidate
+				break
+			}
+		}
+	}
+
+	switch {
+	case opts.DataDir != "":
+		p.DataDir = Location{opts.DataDir, SourceFlag}
+	case os.Getenv(EnvDataDir) != "":
+		p.DataDir = Location{os.Getenv(EnvDataDir), SourceEnv}
+	default:
+		dir, err := userDataDir()
+		if err != nil {
+			return nil, err
+		}
+		p.DataDir = Location{dir, SourceUser}
+	}
+
+	for _, loc := range []*Location{&p.ConfigDir, &p.DataDir} {
+		if abs, err := filepath.Abs(loc.Path); err == nil {
+			loc.Path = abs
+		}
+	}
+	return p, nil
+}
+
+// Env returns the environment variables that make another vibeproxy, e.g.
+// one started by systemd, use the locations given here by flag or
+// environment
+func (p *Paths) Env() map[string]string {
+	env := make(map[string]string)
+	for name, loc := range map[string]Location{
+		EnvConfigDir: p.ConfigDir,
+		EnvDataDir:   p.DataDir,
+		EnvBinary:    p.Binary,
+	} {
+		if loc.Source == SourceFlag || loc.Source == SourceEnv {
+			env[name] = loc.Path
+		}
+	}
+	return env
+}
+
+// Create makes the config and data directories if they do not exist
+func (p *Paths) Create() error {
+	for _, dir := range []string{p.ConfigDir.Path, p.DataDir.Path} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// ResolveBinary finds cli-proxy-api: the --cli-proxy-api flag, then
+// $VIBEPROXY_CLI_PROXY_API, then configured (backend.binary in
+// vibeproxy.yaml), then the executable's directory, then $PATH
+func (p *Paths) ResolveBinary(flagValue, configured string) error {
+	candidates := []Location{
+		{flagValue, SourceFlag},
+		{os.Getenv(EnvBinary), SourceEnv},
+		{configured, SourceSettings},
+	}
+	for _, candidate := range candidates {
+		if candidate.Path == "" {
+			continue
+		}
+		if _, err := os.Stat(candidate.Path); err != nil {
+			return fmt.Errorf("cli-proxy-api from %s: %w", candidate.Source, err)
+		}
+		p.Binary = candidate
+		return nil
+	}
+
+	if dir, err := executableDir(); err == nil {
+		path := filepath.Join(dir, binaryName())
+		if _, err := os.Stat(path); err == nil {
+			p.Binary = Location{path, SourceExecutable}
+			return nil
+		}
+	}
+	if path, err := exec.LookPath(binaryName()); err == nil {
+		p.Binary = Location{path, SourcePath}
+		return nil
+	}
+	return fmt.Errorf("cli-proxy-api binary not found next to vibeproxy or in $PATH")
+}
+
+// Template returns a config.default.yaml to create config.yaml from, or ""
+func Template() string {
+	var dirs []string
+	if dir, err := executableDir(); err == nil {
+		dirs = append(dirs, dir, filepath.Join(dir, "..", "share", "vibeproxy"))
+	}
+	dirs = append(dirs, "/usr/local/share/vibeproxy", "/usr/share/vibeproxy")
+	for _, dir := range dirs {
+		path := filepath.Join(dir, "config.default.yaml")
+		if _, err := os.Stat(path); err == nil {
+			return filepath.Clean(path)
+		}
+	}
+	return ""
+}
+
+// hasConfig reports whether dir holds either config file
+func hasConfig(dir string) bool {
+	for _, name := range []string{"config.yaml", "vibeproxy.yaml"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// executableDir returns the directory of the running binary
+func executableDir() (string, error) {
+	execPath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(execPath); err == nil {
+		execPath = resolved
+	}
+	return filepath.Dir(execPath), nil
+}
+
+// userConfigDir returns $XDG_CONFIG_HOME/vibeproxy or the platform equivalent
+func userConfigDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the config directory: %w", err)
+	}
+	return filepath.Join(dir, "vibeproxy"), nil
+}
+
+// userDataDir returns $XDG_DATA_HOME/vibeproxy, ~/.local/share/vibeproxy,
+// or the platform equivalent on macOS and Windows
+func userDataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "vibeproxy"), nil
+	}
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		return userConfigDir()
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the data directory: %w", err)
+	}
+	return filepath.Join(home, ".local", "share", "vibeproxy"), nil
+}
+
+// binaryName returns the file name of cli-proxy-api on this platform
+func binaryName() string {
+	if runtime.GOOS == "windows" {
+		return "cli-proxy-api.exe"
+	}
+	return "cli-proxy-api"
+}
@@ -0,0 +1,164 @@
+package process
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+	"github.com/fsnotify/fsnotify"
+)
+
+// configSync keeps the per-port copy of config.yaml a backend runs from in
+// step with config.yaml. Edits to config.yaml are copied over, with the port
+// replaced, for CLIProxyAPI to hot-reload; changes CLIProxyAPI writes to the
+// copy, such as through its management API, are written back.
+type configSync struct {
+	manager *Manager
+	path    string // the copy
+	port    int
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+
+	mu     sync.Mutex
+	source []byte // config.yaml the copy matches
+	copied []byte // the copy as last written or read
+}
+
+// syncConfig starts keeping the copy at path, made from source for port, in
+// step with config.yaml
+func (m *Manager) syncConfig(path string, port int, source []byte) (*configSync, error) {
+	copied, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{filepath.Dir(m.configPath), filepath.Dir(path)} {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	s := &configSync{
+		manager: m,
+		path:    path,
+		port:    port,
+		watcher: watcher,
+		done:    make(chan struct{}),
+		source:  source,
+		copied:  copied,
+	}
+	go s.watch()
+	return s, nil
+}
+
+// watch syncs once writes to either file settle
+func (s *configSync) watch() {
+	var timer *time.Timer
+	for {
+		select {
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			name := filepath.Clean(event.Name)
+			if name != filepath.Clean(s.path) && name != filepath.Clean(s.manager.configPath) ||
+				event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(configDebounce, s.sync)
+
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			configLogger.Error("Watch error", "error", err)
+
+		case <-s.done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+	}
+}
+
+// sync writes changes to the copy back to config.yaml, or else copies
+// changes to config.yaml over
+func (s *configSync) sync() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	if copied, err := os.ReadFile(s.path); err == nil && !bytes.Equal(copied, s.copied) {
+		if err := s.writeBack(copied); err != nil {
+			configLogger.Error("Failed to write backend config changes to config.yaml", "error", err)
+		}
+		return
+	}
+
+	data, err := os.ReadFile(s.manager.configPath)
+	if err != nil || bytes.Equal(data, s.source) {
+		return
+	}
+	out, err := withPort(data, s.manager.configPath, s.port)
+	if err != nil {
+		configLogger.Warn("Not copying invalid config.yaml to the backend", "error", err)
+		return
+	}
+	if err := os.WriteFile(s.path, out, 0600); err != nil {
+		configLogger.Error("Failed to update backend config", "path", s.path, "error", err)
+		return
+	}
+	s.source, s.copied = data, out
+	configLogger.Info("Copied config.yaml changes to the backend", "port", s.port)
+}
+
+// writeBack stores the copy's contents in config.yaml with config.yaml's port
+func (s *configSync) writeBack(copied []byte) error {
+	data, err := os.ReadFile(s.manager.configPath)
+	if err != nil {
+		return err
+	}
+	current, err := config.ParseCLIProxyAPIConfig(data, s.manager.configPath)
+	if err != nil {
+		return err
+	}
+	out, err := withPort(copied, s.path, current.Port)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.manager.configPath, out, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", s.manager.configPath, err)
+	}
+	s.source, s.copied = out, copied
+
+	// The backend already runs with these changes
+	s.manager.mu.Lock()
+	s.manager.config = out
+	s.manager.mu.Unlock()
+	configLogger.Info("Wrote backend config changes to config.yaml", "port", s.port)
+	return nil
+}
+
+// close stops syncing
+func (s *configSync) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.done)
+	s.watcher.Close()
+}
@@ -4,15 +4,25 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+	"github.com/automazeio/vibeproxy/internal/logging"
+)
+
+var (
+	logger       = logging.Component("Process")
+	authLogger   = logging.Component("Auth")
+	configLogger = logging.Component("Config")
 )
 
 // AuthCommand represents an authentication command type
@@ -25,92 +35,125 @@ const (
 	QwenLogin
 )
 
+// ParseAuthCommand returns the login command for a provider name
+func ParseAuthCommand(provider string) (AuthCommand, error) {
+	switch strings.ToLower(provider) {
+	case "claude":
+		return ClaudeLogin, nil
+	case "codex":
+		return CodexLogin, nil
+	case "gemini":
+		return GeminiLogin, nil
+	case "qwen":
+		return QwenLogin, nil
+	}
+	return 0, fmt.Errorf("unknown provider %q (expected claude, codex, gemini or qwen)", provider)
+}
+
 // Manager manages the CLIProxyAPI backend process
 type Manager struct {
-	mu           sync.RWMutex
+	mu         sync.RWMutex
+	current    *backend // serving process, nil when stopped
+	previous   *backend // process being drained after a restart
+	binaryPath string
+	configPath string
+	dataDir    string // where per-port copies of config.yaml go
+	port       int    // port of the current backend, or the next one to start
+
+	primaryPort   int
+	alternatePort int // restarts alternate between primaryPort and this; 0 restarts in place
+	readyTimeout  time.Duration
+	drainTimeout  time.Duration
+	target        Target
+
+	restartMu sync.Mutex // held until a restart has stopped the old process
+	config    []byte     // config.yaml as last read by spawn
+
+	starts  int
+	crashes int
+}
+
+// backend is one CLIProxyAPI process
+type backend struct {
 	cmd          *exec.Cmd
-	isRunning    bool
-	logBuffer    *RingBuffer
-	binaryPath   string
+	port         int
 	configPath   string
-	onLogUpdate  func([]string)
+	config       []byte // config.yaml it was started from
+	startedAt    time.Time
 	cancelOutput chan struct{}
+	exited       chan struct{} // closed once the process has exited
+	sync         *configSync   // nil when running from config.yaml itself
+	stopping     bool          // Stop was called; guarded by Manager.mu
 }
 
-// RingBuffer implements a fixed-size circular buffer for log lines
-type RingBuffer struct {
-	mu      sync.Mutex
-	storage []string
-	head    int
-	tail    int
-	count   int
-	cap     int
+// Stats summarizes the backend process lifecycle since VibeProxy started
+type Stats struct {
+	Running   bool      `json:"running"`
+	PID       int       `json:"pid,omitempty"`
+	Port      int       `json:"port"`
+	StartedAt time.Time `json:"startedAt,omitempty"`
+	Starts    int       `json:"starts"`
+	Restarts  int       `json:"restarts"` // starts after the first
+	Crashes   int       `json:"crashes"`  // exits not requested by Stop
 }
 
-// NewRingBuffer creates a new ring buffer with the specified capacity
-func NewRingBuffer(capacity int) *RingBuffer {
-	if capacity < 1 {
-		capacity = 1
-	}
-	return &RingBuffer{
-		storage: make([]string, capacity),
-		cap:     capacity,
+// NewManager creates a new process manager
+func NewManager(binaryPath, configPath string) *Manager {
+	return &Manager{
+		binaryPath:   binaryPath,
+		configPath:   configPath,
+		dataDir:      filepath.Dir(configPath),
+		port:         defaultPort,
+		primaryPort:  defaultPort,
+		readyTimeout: 15 * time.Second,
+		drainTimeout: 30 * time.Second,
 	}
 }
 
-// Append adds an element to the ring buffer
-func (rb *RingBuffer) Append(line string) {
-	rb.mu.Lock()
-	defer rb.mu.Unlock()
-
-	rb.storage[rb.tail] = line
-
-	if rb.count == rb.cap {
-		rb.head = (rb.head + 1) % rb.cap
-	} else {
-		rb.count++
-	}
+// defaultPort is the backend port written to config.yaml
+const defaultPort = 8318
 
-	rb.tail = (rb.tail + 1) % rb.cap
+// IsRunning returns true if the server is running
+func (m *Manager) IsRunning() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.current != nil
 }
 
-// Elements returns all elements in the ring buffer in order
-func (rb *RingBuffer) Elements() []string {
-	rb.mu.Lock()
-	defer rb.mu.Unlock()
+// Port returns the port of the current backend
+func (m *Manager) Port() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.port
+}
 
-	if rb.count == 0 {
-		return []string{}
-	}
+// Stats returns the backend process lifecycle counters
+func (m *Manager) Stats() Stats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-	result := make([]string, 0, rb.count)
-	for i := 0; i < rb.count; i++ {
-		idx := (rb.head + i) % rb.cap
-		result = append(result, rb.storage[idx])
+	stats := Stats{
+		Running:  m.current != nil,
+		Port:     m.port,
+		Starts:   m.starts,
+		Restarts: max(m.starts-1, 0),
+		Crashes:  m.crashes,
 	}
-
-	return result
-}
-
-// NewManager creates a new process manager
-func NewManager(binaryPath, configPath string) *Manager {
-	return &Manager{
-		logBuffer:  NewRingBuffer(1000),
-		binaryPath: binaryPath,
-		configPath: configPath,
+	if m.current != nil {
+		stats.PID = m.current.cmd.Process.Pid
+		stats.StartedAt = m.current.startedAt
 	}
+	return stats
 }
 
-// IsRunning returns true if the server is running
-func (m *Manager) IsRunning() bool {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.isRunning
+// HealthCheck checks if CLIProxyAPI is actually listening on its port
+func (m *Manager) HealthCheck() bool {
+	return portOpen(m.Port())
 }
 
-// HealthCheck checks if CLIProxyAPI is actually listening on port 8318
-func (m *Manager) HealthCheck() bool {
-	conn, err := net.DialTimeout("tcp", "127.0.0.1:8318", 500*time.Millisecond)
+// portOpen reports whether something accepts connections on the local port
+func portOpen(port int) bool {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), 500*time.Millisecond)
 	if err != nil {
 		return false
 	}
@@ -121,135 +164,197 @@ func (m *Manager) HealthCheck() bool {
 // Start starts the CLIProxyAPI server
 func (m *Manager) Start() error {
 	m.mu.Lock()
-	if m.isRunning {
+	if m.current != nil {
 		m.mu.Unlock()
 		return nil
 	}
+	port := m.port
 	m.mu.Unlock()
 
 	// Kill any orphaned processes
 	m.killOrphanedProcesses()
 
-	// Verify binary exists
+	if err := m.checkFiles(); err != nil {
+		return err
+	}
+
+	b, err := m.spawn(port)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	select {
+	case <-b.exited:
+		m.mu.Unlock()
+		return fmt.Errorf("server exited immediately")
+	default:
+		m.current = b
+	}
+	m.mu.Unlock()
+
+	// Wait a bit to ensure it started successfully
+	time.Sleep(1 * time.Second)
+
+	return nil
+}
+
+// checkFiles verifies the binary and config exist
+func (m *Manager) checkFiles() error {
 	if _, err := os.Stat(m.binaryPath); err != nil {
 		return fmt.Errorf("binary not found at %s: %w", m.binaryPath, err)
 	}
-
-	// Verify config exists
 	if _, err := os.Stat(m.configPath); err != nil {
 		return fmt.Errorf("config not found at %s: %w", m.configPath, err)
 	}
+	return nil
+}
+
+// spawn starts a backend process listening on port
+func (m *Manager) spawn(port int) (*backend, error) {
+	configPath, data, err := m.configForPort(port)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create command
-	cmd := exec.Command(m.binaryPath, "--config", m.configPath)
+	cmd := exec.Command(m.binaryPath, "--config", configPath)
 
 	// Create pipes for stdout and stderr
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %w", err)
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %w", err)
+		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
 	}
 
 	// Start the process
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start server: %w", err)
+		return nil, fmt.Errorf("failed to start server: %w", err)
+	}
+
+	var cfgSync *configSync
+	if configPath != m.configPath {
+		if cfgSync, err = m.syncConfig(configPath, port, data); err != nil {
+			configLogger.Warn("Changes to config.yaml will not reach the backend until it restarts", "port", port, "error", err)
+		}
+	}
+
+	b := &backend{
+		cmd:          cmd,
+		port:         port,
+		configPath:   configPath,
+		config:       data,
+		sync:         cfgSync,
+		startedAt:    time.Now(),
+		cancelOutput: make(chan struct{}),
+		exited:       make(chan struct{}),
 	}
 
 	m.mu.Lock()
-	m.cmd = cmd
-	m.isRunning = true
-	m.cancelOutput = make(chan struct{})
+	m.starts++
+	m.config = data
 	m.mu.Unlock()
 
-	m.addLog("✓ Server started on port 8318")
+	logger.Info("Server started", "pid", cmd.Process.Pid, "port", port)
 
 	// Start output readers
-	go m.readOutput(stdout, "")
-	go m.readOutput(stderr, "⚠️ ")
+	go m.readOutput(stdout, "stdout", b.cancelOutput)
+	go m.readOutput(stderr, "stderr", b.cancelOutput)
 
 	// Wait for process in background
-	go func() {
-		err := cmd.Wait()
-		exitCode := 0
-		if err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				exitCode = exitErr.ExitCode()
-			}
-		}
+	go m.wait(b)
+
+	return b, nil
+}
 
-		m.mu.Lock()
-		m.isRunning = false
-		m.cmd = nil
-		if m.cancelOutput != nil {
-			close(m.cancelOutput)
-			m.cancelOutput = nil
+// wait reaps a backend process and records whether it crashed
+func (m *Manager) wait(b *backend) {
+	err := b.cmd.Wait()
+	exitCode := 0
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
 		}
-		m.mu.Unlock()
+	}
 
-		m.addLog(fmt.Sprintf("Server stopped with code: %d", exitCode))
-	}()
+	m.mu.Lock()
+	crashed := !b.stopping
+	if crashed {
+		m.crashes++
+	}
+	if m.current == b {
+		m.current = nil
+	}
+	m.mu.Unlock()
 
-	// Wait a bit to ensure it started successfully
-	time.Sleep(1 * time.Second)
+	close(b.cancelOutput)
+	close(b.exited)
+	if b.sync != nil {
+		b.sync.close()
+	}
+	if b.configPath != m.configPath {
+		os.Remove(b.configPath)
+	}
 
-	return nil
+	if crashed {
+		logger.Error("Server exited unexpectedly", "port", b.port, "exit_code", exitCode)
+	} else {
+		logger.Info("Server stopped", "port", b.port, "exit_code", exitCode)
+	}
 }
 
-// Stop stops the CLIProxyAPI server
+// Stop stops the CLIProxyAPI server, including one still draining after a
+// restart
 func (m *Manager) Stop() error {
 	m.mu.Lock()
-	cmd := m.cmd
-	isRunning := m.isRunning
+	current, previous := m.current, m.previous
 	m.mu.Unlock()
 
-	if !isRunning || cmd == nil {
-		m.mu.Lock()
-		m.isRunning = false
-		m.mu.Unlock()
-		return nil
+	for _, b := range []*backend{previous, current} {
+		if b != nil {
+			m.stopBackend(b)
+		}
+	}
+	return nil
+}
+
+// stopBackend terminates a backend process, killing it if it does not exit
+// within 2 seconds
+func (m *Manager) stopBackend(b *backend) {
+	select {
+	case <-b.exited:
+		return
+	default:
 	}
 
-	pid := cmd.Process.Pid
-	m.addLog(fmt.Sprintf("Stopping server (PID: %d)...", pid))
+	m.mu.Lock()
+	b.stopping = true
+	m.mu.Unlock()
+
+	logger.Info("Stopping server", "pid", b.cmd.Process.Pid, "port", b.port)
 
 	// Try graceful termination (SIGTERM)
-	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
-		log.Printf("[Process] Failed to send SIGTERM: %v", err)
+	if err := b.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		logger.Warn("Failed to send SIGTERM", "error", err)
 	}
 
 	// Wait up to 2 seconds for graceful termination
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Wait()
-	}()
-
 	select {
-	case <-done:
+	case <-b.exited:
 		// Graceful shutdown succeeded
-		m.addLog("✓ Server stopped gracefully")
+		logger.Info("Server stopped gracefully")
 	case <-time.After(2 * time.Second):
 		// Force kill
-		m.addLog("⚠️ Server didn't stop gracefully, force killing...")
-		if err := cmd.Process.Kill(); err != nil {
-			log.Printf("[Process] Failed to kill process: %v", err)
+		logger.Warn("Server didn't stop gracefully, force killing")
+		if err := b.cmd.Process.Kill(); err != nil {
+			logger.Error("Failed to kill process", "error", err)
 		}
-		<-done // Wait for process to actually exit
+		<-b.exited // Wait for process to actually exit
 	}
-
-	m.mu.Lock()
-	m.cmd = nil
-	m.isRunning = false
-	if m.cancelOutput != nil {
-		close(m.cancelOutput)
-		m.cancelOutput = nil
-	}
-	m.mu.Unlock()
-
-	return nil
 }
 
 // RunAuthCommand executes an authentication command
@@ -302,15 +407,14 @@ func (m *Manager) RunAuthCommand(command AuthCommand, email string) (bool, strin
 		return false, "", fmt.Errorf("failed to start auth process: %w", err)
 	}
 
-	log.Printf("[Auth] Starting %s authentication process (PID: %d)", cmdType, cmd.Process.Pid)
-	m.addLog(fmt.Sprintf("✓ Authentication process started (PID: %d) - browser should open shortly", cmd.Process.Pid))
+	authLogger.Info("Started authentication process, browser should open shortly", "provider", cmdType, "pid", cmd.Process.Pid)
 
 	// Handle Gemini auto-newline
 	if command == GeminiLogin {
 		go func() {
 			time.Sleep(3 * time.Second)
 			stdin.Write([]byte("\n"))
-			log.Printf("[Auth] Sent newline to accept default project")
+			authLogger.Info("Sent newline to accept default project")
 		}()
 	}
 
@@ -319,7 +423,7 @@ func (m *Manager) RunAuthCommand(command AuthCommand, email string) (bool, strin
 		go func() {
 			time.Sleep(10 * time.Second)
 			stdin.Write([]byte(email + "\n"))
-			log.Printf("[Auth] Sent Qwen email: %s", email)
+			authLogger.Info("Sent Qwen email", "email", email)
 		}()
 	}
 
@@ -378,117 +482,129 @@ func (m *Manager) RunAuthCommand(command AuthCommand, email string) (bool, strin
 	}
 }
 
-// GetLogs returns all log lines
-func (m *Manager) GetLogs() []string {
-	return m.logBuffer.Elements()
-}
-
-// addLog adds a log line to the buffer
-func (m *Manager) addLog(message string) {
-	timestamp := time.Now().Format("15:04:05")
-	logLine := fmt.Sprintf("[%s] %s", timestamp, message)
-	m.logBuffer.Append(logLine)
-
-	if m.onLogUpdate != nil {
-		m.onLogUpdate(m.logBuffer.Elements())
-	}
-}
-
-// readOutput reads from an output pipe and adds to logs
-func (m *Manager) readOutput(reader io.Reader, prefix string) {
+// readOutput logs each line of a backend output stream as a structured record
+func (m *Manager) readOutput(reader io.Reader, stream string, cancel <-chan struct{}) {
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
 		select {
-		case <-m.cancelOutput:
+		case <-cancel:
 			return
 		default:
-			line := scanner.Text()
-			if line != "" {
-				m.addLog(prefix + line)
+			if line := scanner.Text(); line != "" {
+				logBackendLine(line, stream)
 			}
 		}
 	}
 }
 
-// killOrphanedProcesses kills any orphaned cli-proxy-api processes
+// killOrphanedProcesses kills leftover processes running the backend binary.
+// Processes are matched by executable rather than command line, which would
+// also match vibeproxy itself when the binary's path is among its arguments.
 func (m *Manager) killOrphanedProcesses() {
-	// Use pgrep to find processes
-	cmd := exec.Command("pgrep", "-f", "cli-proxy-api")
-	output, err := cmd.Output()
-	if err != nil {
-		// Exit code 1 means no processes found - this is fine
+	pids := m.backendProcesses()
+	if len(pids) == 0 {
 		return
 	}
 
-	pids := strings.TrimSpace(string(output))
-	if pids == "" {
-		return
-	}
-
-	m.addLog(fmt.Sprintf("⚠️ Found orphaned server process(es): %s", pids))
-
-	// Kill them
-	killCmd := exec.Command("pkill", "-9", "-f", "cli-proxy-api")
-	if err := killCmd.Run(); err != nil {
-		log.Printf("[Process] Failed to kill orphaned processes: %v", err)
+	logger.Warn("Found orphaned server processes", "pids", pids)
+	for _, pid := range pids {
+		process, err := os.FindProcess(pid)
+		if err == nil {
+			err = process.Kill()
+		}
+		if err != nil {
+			logger.Error("Failed to kill orphaned process", "pid", pid, "error", err)
+		}
 	}
 
 	time.Sleep(500 * time.Millisecond)
-	m.addLog("✓ Cleaned up orphaned processes")
+	logger.Info("Cleaned up orphaned processes")
 }
 
-// GetBinaryPath returns the path to the bundled CLI proxy binary
-func GetBinaryPath() (string, error) {
-	execPath, err := os.Executable()
-	if err != nil {
-		return "", err
-	}
+// backendProcesses returns the IDs of processes running the backend binary,
+// other than this one
+func (m *Manager) backendProcesses() []int {
+	binary := resolvedPath(m.binaryPath)
+	self := os.Getpid()
+	var pids []int
 
-	execDir := filepath.Dir(execPath)
-
-	// Check if cli-proxy-api is in the same directory as the executable
-	binaryPath := filepath.Join(execDir, "cli-proxy-api")
-	if _, err := os.Stat(binaryPath); err == nil {
-		return binaryPath, nil
+	if runtime.GOOS == "linux" {
+		entries, err := os.ReadDir("/proc")
+		if err != nil {
+			return nil
+		}
+		for _, entry := range entries {
+			pid, err := strconv.Atoi(entry.Name())
+			if err != nil || pid == self {
+				continue
+			}
+			exe, err := os.Readlink(filepath.Join("/proc", entry.Name(), "exe"))
+			if err != nil {
+				continue
+			}
+			if strings.TrimSuffix(exe, " (deleted)") == binary {
+				pids = append(pids, pid)
+			}
+		}
+		return pids
 	}
 
-	return "", fmt.Errorf("cli-proxy-api binary not found")
-}
-
-// GetConfigPath returns the path to the config file, auto-creating it if needed
-func GetConfigPath() (string, error) {
-	execPath, err := os.Executable()
+	// Elsewhere ps reports the executable's full path as the command name
+	output, err := exec.Command("ps", "-axo", "pid=,comm=").Output()
 	if err != nil {
-		return "", err
+		return nil
 	}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil || pid == self {
+			continue
+		}
+		if resolvedPath(strings.Join(fields[1:], " ")) == binary {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
 
-	execDir := filepath.Dir(execPath)
+// resolvedPath returns path made absolute with symlinks resolved, or path
+// itself if that fails
+func resolvedPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return path
+}
 
-	// Check if config.yaml is in the same directory as the executable
-	configPath := filepath.Join(execDir, "config.yaml")
-	if _, err := os.Stat(configPath); err == nil {
-		return configPath, nil
+// EnsureConfig creates config.yaml at path if it does not exist, copying
+// template when one is given
+func EnsureConfig(path, template string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
 	}
 
-	// Check for config.default.yaml template
-	defaultConfigPath := filepath.Join(execDir, "config.default.yaml")
-	if _, err := os.Stat(defaultConfigPath); err == nil {
-		// Copy default config to config.yaml
-		log.Printf("[Config] Creating config.yaml from default template")
-		if err := copyFile(defaultConfigPath, configPath); err != nil {
-			return "", fmt.Errorf("failed to create config from template: %w", err)
+	if template != "" {
+		configLogger.Info("Creating config.yaml from default template", "template", template)
+		if err := copyFile(template, path); err != nil {
+			return fmt.Errorf("failed to create config from template: %w", err)
 		}
-		log.Printf("[Config] Created config.yaml at %s", configPath)
-		return configPath, nil
+		configLogger.Info("Created config.yaml", "path", path)
+		return nil
 	}
 
 	// Last resort: create minimal config
-	log.Printf("[Config] No template found, creating minimal config.yaml")
-	if err := createMinimalConfig(configPath); err != nil {
-		return "", fmt.Errorf("failed to create minimal config: %w", err)
+	configLogger.Info("No template found, creating minimal config.yaml")
+	if err := createMinimalConfig(path); err != nil {
+		return fmt.Errorf("failed to create minimal config: %w", err)
 	}
-	log.Printf("[Config] Created minimal config.yaml at %s", configPath)
-	return configPath, nil
+	configLogger.Info("Created minimal config.yaml", "path", path)
+	return nil
 }
 
 // copyFile copies a file from src to dst
@@ -500,37 +616,7 @@ func copyFile(src, dst string) error {
 	return os.WriteFile(dst, data, 0644)
 }
 
-// createMinimalConfig creates a minimal config.yaml
+// createMinimalConfig creates a config.yaml with the default settings
 func createMinimalConfig(path string) error {
-	content := `# CLIProxyAPI Configuration (auto-generated)
-# Backend port for CLIProxyAPI (DO NOT CHANGE)
-port: 8318
-
-# Directory where authentication tokens are stored
-auth-dir: ~/.cli-proxy-api
-
-# Remote management
-remote-management:
-  allow-remote: false
-  secret-key: ""
-  disable-control-panel: false
-
-# Client API keys
-api-keys:
-  - dummy-not-used
-
-# Settings
-debug: false
-logging-to-file: false
-usage-statistics-enabled: false
-proxy-url: ''
-request-retry: 3
-
-quota-exceeded:
-  switch-project: true
-  switch-preview-model: true
-
-ws-auth: false
-`
-	return os.WriteFile(path, []byte(content), 0644)
+	return config.DefaultCLIProxyAPIConfig().Save(path)
 }
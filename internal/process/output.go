@@ -0,0 +1,184 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/automazeio/vibeproxy/internal/logging"
+)
+
+// backendLogger receives CLIProxyAPI's stdout and stderr
+var backendLogger = logging.Component("CLIProxyAPI")
+
+// backendLine is one backend output line split into structured parts
+type backendLine struct {
+	level   slog.Level
+	message string
+	attrs   []slog.Attr
+}
+
+// logBackendLine parses a backend output line and logs it; lines without a
+// recognizable level are info on stdout and warnings on stderr
+func logBackendLine(line, stream string) {
+	parsed := parseBackendLine(line)
+	if parsed.level == levelUnknown {
+		parsed.level = slog.LevelInfo
+		if stream == "stderr" {
+			parsed.level = slog.LevelWarn
+		}
+	}
+	attrs := append([]slog.Attr{slog.String("stream", stream)}, parsed.attrs...)
+	backendLogger.LogAttrs(context.Background(), parsed.level, parsed.message, attrs...)
+}
+
+// levelUnknown marks lines whose level could not be determined
+const levelUnknown = slog.Level(-100)
+
+// parseBackendLine understands JSON lines, logfmt (level=... msg=...) and the
+// bracketed "[time] [level] [file:line] message" format CLIProxyAPI prints
+func parseBackendLine(line string) backendLine {
+	trimmed := strings.TrimSpace(line)
+
+	if strings.HasPrefix(trimmed, "{") {
+		if parsed, ok := parseJSONLine(trimmed); ok {
+			return parsed
+		}
+	}
+	if strings.Contains(trimmed, "level=") && strings.Contains(trimmed, "msg=") {
+		if parsed, ok := parseLogfmtLine(trimmed); ok {
+			return parsed
+		}
+	}
+	return parseBracketedLine(trimmed)
+}
+
+// parseJSONLine reads a JSON log object
+func parseJSONLine(line string) (backendLine, bool) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return backendLine{}, false
+	}
+
+	parsed := backendLine{level: levelUnknown}
+	for _, key := range []string{"level", "lvl", "severity"} {
+		if value, ok := fields[key].(string); ok {
+			parsed.level = backendLevel(value)
+			delete(fields, key)
+			break
+		}
+	}
+	for _, key := range []string{"msg", "message"} {
+		if value, ok := fields[key].(string); ok {
+			parsed.message = value
+			delete(fields, key)
+			break
+		}
+	}
+	delete(fields, "time")
+	delete(fields, "ts")
+	for _, key := range sortedFieldKeys(fields) {
+		parsed.attrs = append(parsed.attrs, slog.Any(key, fields[key]))
+	}
+	return parsed, parsed.message != ""
+}
+
+// parseLogfmtLine reads key=value pairs, allowing double-quoted values
+func parseLogfmtLine(line string) (backendLine, bool) {
+	parsed := backendLine{level: levelUnknown}
+	rest := line
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ")
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 || strings.ContainsAny(rest[:eq], " \"") {
+			return backendLine{}, false
+		}
+		key := rest[:eq]
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := 1
+			for end < len(rest) && (rest[end] != '"' || rest[end-1] == '\\') {
+				end++
+			}
+			if end >= len(rest) {
+				return backendLine{}, false
+			}
+			value = strings.ReplaceAll(rest[1:end], `\"`, `"`)
+			rest = rest[end+1:]
+		} else if space := strings.IndexByte(rest, ' '); space >= 0 {
+			value, rest = rest[:space], rest[space:]
+		} else {
+			value, rest = rest, ""
+		}
+
+		switch key {
+		case "level", "lvl":
+			parsed.level = backendLevel(value)
+		case "msg":
+			parsed.message = value
+		case "time", "ts":
+		default:
+			parsed.attrs = append(parsed.attrs, slog.String(key, value))
+		}
+	}
+	return parsed, parsed.message != ""
+}
+
+// parseBracketedLine strips leading [..] groups, taking the level and
+// source location from them
+func parseBracketedLine(line string) backendLine {
+	parsed := backendLine{level: levelUnknown}
+	rest := line
+	for strings.HasPrefix(rest, "[") {
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			break
+		}
+		group := strings.TrimSpace(rest[1:end])
+		switch {
+		case backendLevel(group) != levelUnknown && parsed.level == levelUnknown:
+			parsed.level = backendLevel(group)
+		case strings.Contains(group, ".go:"):
+			parsed.attrs = append(parsed.attrs, slog.String("source", group))
+		case strings.HasPrefix(group, "GIN-"):
+			if level := backendLevel(strings.TrimPrefix(group, "GIN-")); level != levelUnknown {
+				parsed.level = level
+			}
+		}
+		rest = strings.TrimLeft(rest[end+1:], " ")
+	}
+	parsed.message = rest
+	if parsed.message == "" {
+		parsed.message = line
+	}
+	return parsed
+}
+
+// backendLevel maps a level name to slog, or levelUnknown
+func backendLevel(name string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "trace", "debug":
+		return slog.LevelDebug
+	case "info":
+		return slog.LevelInfo
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error", "fatal", "panic":
+		return slog.LevelError
+	}
+	return levelUnknown
+}
+
+// sortedFieldKeys returns the keys of a decoded JSON object in a stable order
+func sortedFieldKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,212 @@
+package process
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+// Target is the proxy in front of the backend, switched over by Restart
+type Target interface {
+	// SetTargetPort sends new requests to the backend on port
+	SetTargetPort(port int)
+	// WaitUpstreamIdle waits until no request is using the backend on port
+	WaitUpstreamIdle(ctx context.Context, port int) error
+}
+
+// SetTarget sets the proxy that Restart switches to the new backend
+func (m *Manager) SetTarget(target Target) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.target = target
+}
+
+// SetDataDir sets where per-port copies of config.yaml are written, for
+// config directories VibeProxy cannot write to
+func (m *Manager) SetDataDir(dir string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dataDir = dir
+}
+
+// SetBackendConfig configures ports and timeouts for restarts
+func (m *Manager) SetBackendConfig(cfg config.BackendConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.alternatePort = cfg.AlternatePort
+	m.readyTimeout = cfg.ReadyTimeout
+	m.drainTimeout = cfg.DrainTimeout
+}
+
+// Restart replaces the backend without dropping requests: a new process is
+// started on the other port, the target is switched once it accepts
+// connections, and the old process is stopped when its requests have drained.
+// Restart returns after the switch; draining continues in the background and
+// a further restart waits for it. If the new process does not become ready
+// the old one keeps serving.
+func (m *Manager) Restart() error {
+	m.restartMu.Lock()
+
+	m.mu.RLock()
+	old, target := m.current, m.target
+	nextPort := m.alternatePort
+	if old != nil && old.port == m.alternatePort {
+		nextPort = m.primaryPort
+	}
+	readyTimeout, drainTimeout := m.readyTimeout, m.drainTimeout
+	m.mu.RUnlock()
+
+	if old == nil {
+		m.restartMu.Unlock()
+		return m.Start()
+	}
+	if nextPort == 0 || target == nil {
+		defer m.restartMu.Unlock()
+		logger.Warn("No alternate port configured, restarting in place")
+		m.stopBackend(old)
+		return m.Start()
+	}
+
+	if err := m.checkFiles(); err != nil {
+		m.restartMu.Unlock()
+		return err
+	}
+	if err := portFree(nextPort); err != nil {
+		m.restartMu.Unlock()
+		return err
+	}
+
+	logger.Info("Restarting backend", "from_port", old.port, "to_port", nextPort)
+	next, err := m.spawn(nextPort)
+	if err != nil {
+		m.restartMu.Unlock()
+		return err
+	}
+	if err := waitReady(next, readyTimeout); err != nil {
+		m.stopBackend(next)
+		m.mu.Lock()
+		m.config = old.config
+		m.mu.Unlock()
+		m.restartMu.Unlock()
+		return fmt.Errorf("new backend on port %d: %w", nextPort, err)
+	}
+
+	m.mu.Lock()
+	m.current, m.previous, m.port = next, old, nextPort
+	m.mu.Unlock()
+	target.SetTargetPort(nextPort)
+	logger.Info("Switched to new backend", "port", nextPort, "pid", next.cmd.Process.Pid)
+
+	go func() {
+		defer m.restartMu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		if err := target.WaitUpstreamIdle(ctx, old.port); err != nil {
+			logger.Warn("Old backend still busy after the drain timeout, stopping it", "port", old.port)
+		}
+		m.stopBackend(old)
+
+		m.mu.Lock()
+		if m.previous == old {
+			m.previous = nil
+		}
+		m.mu.Unlock()
+	}()
+
+	return nil
+}
+
+// waitReady waits until b accepts connections
+func waitReady(b *backend, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		select {
+		case <-b.exited:
+			return fmt.Errorf("exited before it was ready")
+		default:
+		}
+		if portOpen(b.port) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not listening after %s", timeout)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// portFree checks that nothing else is listening on port
+func portFree(port int) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return fmt.Errorf("port %d is in use", port)
+	}
+	listener.Close()
+	return nil
+}
+
+// ConfigChanged reports whether config.yaml differs from the file the
+// backend was last started from
+func (m *Manager) ConfigChanged() bool {
+	data, err := os.ReadFile(m.configPath)
+	if err != nil {
+		return true
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return !bytes.Equal(data, m.config)
+}
+
+// ConfigPath returns the path of CLIProxyAPI's config.yaml
+func (m *Manager) ConfigPath() string {
+	return m.configPath
+}
+
+// configForPort returns a config file that makes CLIProxyAPI listen on port:
+// config.yaml itself if it already names that port, otherwise a copy in the
+// data directory with the port replaced, which spawn keeps in step with
+// config.yaml. Loading it also catches broken edits before a restart. The
+// contents of config.yaml are returned as well.
+func (m *Manager) configForPort(port int) (string, []byte, error) {
+	data, err := os.ReadFile(m.configPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	cfg, err := config.ParseCLIProxyAPIConfig(data, m.configPath)
+	if err != nil {
+		return "", nil, err
+	}
+	if cfg.Port == port {
+		return m.configPath, data, nil
+	}
+
+	out, err := withPort(data, m.configPath, port)
+	if err != nil {
+		return "", nil, err
+	}
+	m.mu.RLock()
+	dir := m.dataDir
+	m.mu.RUnlock()
+	path := filepath.Join(dir, fmt.Sprintf(".config-%d.yaml", port))
+	if err := os.WriteFile(path, out, 0600); err != nil {
+		return "", nil, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return path, data, nil
+}
+
+// withPort returns config.yaml contents loaded from path with the port replaced
+func withPort(data []byte, path string, port int) ([]byte, error) {
+	cfg, err := config.ParseCLIProxyAPIConfig(data, path)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Port = port
+	return cfg.Marshal()
+}
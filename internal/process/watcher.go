@@ -0,0 +1,94 @@
+package process
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// configDebounce lets editors finish writing before the backend restarts
+const configDebounce = 500 * time.Millisecond
+
+// ConfigWatcher restarts the backend when config.yaml changes on disk
+type ConfigWatcher struct {
+	watcher *fsnotify.Watcher
+	manager *Manager
+	done    chan struct{}
+}
+
+// NewConfigWatcher watches the manager's config file. The directory is
+// watched so that editors which replace the file are noticed too.
+func NewConfigWatcher(manager *Manager) (*ConfigWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := watcher.Add(filepath.Dir(manager.configPath)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	w := &ConfigWatcher{
+		watcher: watcher,
+		manager: manager,
+		done:    make(chan struct{}),
+	}
+
+	go w.watch()
+
+	configLogger.Info("Watching for changes", "path", manager.configPath)
+
+	return w, nil
+}
+
+// watch restarts the backend once writes to the config file settle
+func (w *ConfigWatcher) watch() {
+	var timer *time.Timer
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(w.manager.configPath) ||
+				event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(configDebounce, func() {
+				// Skip writes matching the running config, such as those made
+				// by the config API, which restarts the backend itself
+				if !w.manager.ConfigChanged() {
+					return
+				}
+				configLogger.Info("config.yaml changed, restarting backend")
+				if err := w.manager.Restart(); err != nil {
+					configLogger.Error("Failed to restart backend", "error", err)
+				}
+			})
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			configLogger.Error("Watch error", "error", err)
+
+		case <-w.done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+	}
+}
+
+// Close stops the watcher
+func (w *ConfigWatcher) Close() error {
+	close(w.done)
+	return w.watcher.Close()
+}
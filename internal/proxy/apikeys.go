@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+// apiKeys follows the api-keys list in CLIProxyAPI's config.yaml, so that
+// responses VibeProxy answers itself, such as cache hits and replays, only
+// go to clients the backend would accept
+type apiKeys struct {
+	mu      sync.Mutex
+	path    string // empty accepts every client
+	modTime time.Time
+	keys    map[string]bool // nil if the file could not be read
+}
+
+// SetAPIKeysFile makes the proxy check client keys against the api-keys in
+// the CLIProxyAPI config at path before answering from its own stores
+func (tp *ThinkingProxy) SetAPIKeysFile(path string) {
+	tp.apiKeys.mu.Lock()
+	defer tp.apiKeys.mu.Unlock()
+	tp.apiKeys.path = path
+	tp.apiKeys.modTime = time.Time{}
+	tp.apiKeys.keys = nil
+}
+
+// allowed reports whether CLIProxyAPI accepts key. The file is re-read when
+// it changes; if it cannot be read no key is accepted.
+func (a *apiKeys) allowed(key string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.path == "" {
+		return true
+	}
+
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return false
+	}
+	if a.keys == nil || !info.ModTime().Equal(a.modTime) {
+		cfg, err := config.LoadCLIProxyAPIConfig(a.path)
+		if err != nil {
+			logger.Warn("Failed to read api-keys", "path", a.path, "error", err)
+			a.keys = nil
+			return false
+		}
+		a.keys = make(map[string]bool, len(cfg.APIKeys))
+		for _, k := range cfg.APIKeys {
+			a.keys[k] = true
+		}
+		a.modTime = info.ModTime()
+	}
+
+	// CLIProxyAPI accepts every client when no keys are configured
+	return len(a.keys) == 0 || a.keys[key]
+}
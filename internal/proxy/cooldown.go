@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+const (
+	// maxErrorBodySize bounds how much of an error response is buffered
+	maxErrorBodySize = 1 << 20
+
+	// maxCooldown caps reset hints so a bogus header cannot disable a model for days
+	maxCooldown = time.Hour
+)
+
+// rateLimitMarkers identify rate-limit and overload errors in response
+// bodies across providers
+var rateLimitMarkers = []string{
+	"rate_limit_error",        // Anthropic
+	"overloaded_error",        // Anthropic
+	"rate_limit_exceeded",     // OpenAI
+	"resource_exhausted",      // Gemini
+	"too many requests",       // generic
+	"throttling",              // Qwen
+	"model_cooldown",          // CLIProxyAPI
+	"quota exceeded",          // generic
+	"exceeded your current q", // OpenAI "exceeded your current quota"
+}
+
+// retryDelayPattern matches Gemini's RetryInfo "retryDelay": "30s"
+var retryDelayPattern = regexp.MustCompile(`"retryDelay"\s*:\s*"([0-9.]+s)"`)
+
+// CooldownState describes a model/account that is cooling down after an
+// upstream rate limit
+type CooldownState struct {
+	Model   string    `json:"model"`
+	Account string    `json:"account,omitempty"`
+	Until   time.Time `json:"until"`
+	Reason  string    `json:"reason"`
+}
+
+// cooldownTracker records when rate-limited models become available again
+type cooldownTracker struct {
+	mu      sync.Mutex
+	entries map[string]CooldownState
+}
+
+// newCooldownTracker creates an empty tracker
+func newCooldownTracker() *cooldownTracker {
+	return &cooldownTracker{entries: make(map[string]CooldownState)}
+}
+
+// cooldownKey identifies a model served by a specific account
+func cooldownKey(model, account string) string {
+	return model + "|" + account
+}
+
+// set puts model/account into cooldown for delay, extending any existing one
+func (c *cooldownTracker) set(model, account string, delay time.Duration, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cooldownKey(model, account)
+	until := time.Now().Add(delay)
+	if existing, ok := c.entries[key]; ok && existing.Until.After(until) {
+		return
+	}
+	c.entries[key] = CooldownState{Model: model, Account: account, Until: until, Reason: reason}
+}
+
+// remaining returns how long model/account is still cooling down
+func (c *cooldownTracker) remaining(model, account string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cooldownKey(model, account)
+	entry, ok := c.entries[key]
+	if !ok {
+		return 0
+	}
+	left := time.Until(entry.Until)
+	if left <= 0 {
+		delete(c.entries, key)
+		return 0
+	}
+	return left
+}
+
+// active returns all unexpired cooldowns ordered by expiry
+func (c *cooldownTracker) active() []CooldownState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	result := make([]CooldownState, 0, len(c.entries))
+	for key, entry := range c.entries {
+		if !entry.Until.After(now) {
+			delete(c.entries, key)
+			continue
+		}
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Until.Before(result[j].Until) })
+	return result
+}
+
+// SetUpstreamRetry replaces the upstream retry and cooldown settings
+func (tp *ThinkingProxy) SetUpstreamRetry(cfg config.UpstreamRetryConfig) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.upstreamRetry = cfg
+}
+
+// Cooldowns returns the models currently cooling down after upstream rate limits
+func (tp *ThinkingProxy) Cooldowns() []CooldownState {
+	return tp.cooldowns.active()
+}
+
+// waitForCooldown fails fast or waits while model/account is cooling down.
+// It returns false after sending a 429 to the client.
+func (tp *ThinkingProxy) waitForCooldown(ctx context.Context, model, account string, req *http.Request, clientConn net.Conn) bool {
+	if model == "" {
+		return true
+	}
+
+	left := tp.cooldowns.remaining(model, account)
+	if left == 0 {
+		return true
+	}
+
+	tp.mu.RLock()
+	retry := tp.upstreamRetry
+	tp.mu.RUnlock()
+
+	if retry.CooldownMode == "wait" && left <= retry.MaxDelay {
+		logger.Info("Model is cooling down, waiting", "model", model, "wait", left.Round(time.Second).String())
+		if sleep(ctx, left) {
+			return true
+		}
+		left = tp.cooldowns.remaining(model, account)
+	}
+
+	logger.Warn("Model is cooling down, rejecting request", "model", model, "remaining", left.Round(time.Second).String())
+	tp.sendError(clientConn, req, &proxyError{
+		status:     http.StatusTooManyRequests,
+		code:       errUpstreamCooldown,
+		message:    fmt.Sprintf("Model %s is rate limited upstream", model),
+		retryAfter: left,
+	})
+	return false
+}
+
+// isRateLimitStatus reports whether status may carry a rate-limit error
+func isRateLimitStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, 529, http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusBadGateway:
+		return true
+	}
+	return false
+}
+
+// rateLimitDelay decides whether resp is a rate-limit or overload error and
+// how long to wait before trying again
+func rateLimitDelay(resp *http.Response, body []byte, fallback time.Duration) (time.Duration, bool) {
+	limited := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == 529
+	if !limited {
+		lower := bytes.ToLower(body)
+		for _, marker := range rateLimitMarkers {
+			if bytes.Contains(lower, []byte(marker)) {
+				limited = true
+				break
+			}
+		}
+	}
+	if !limited {
+		return 0, false
+	}
+
+	delay := resetDelay(resp.Header, body)
+	if delay <= 0 {
+		delay = fallback
+	}
+	return min(delay, maxCooldown), true
+}
+
+// resetDelay extracts the longest reset hint from provider headers and bodies
+func resetDelay(header http.Header, body []byte) time.Duration {
+	var delay time.Duration
+	now := time.Now()
+
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			delay = max(delay, time.Duration(seconds)*time.Second)
+		} else if at, err := http.ParseTime(value); err == nil {
+			delay = max(delay, at.Sub(now))
+		}
+	}
+
+	if value := header.Get("Retry-After-Ms"); value != "" {
+		if ms, err := strconv.ParseFloat(value, 64); err == nil {
+			delay = max(delay, time.Duration(ms*float64(time.Millisecond)))
+		}
+	}
+
+	// Anthropic reports RFC 3339 reset timestamps
+	for name, values := range header {
+		if !strings.HasPrefix(strings.ToLower(name), "anthropic-ratelimit-") || !strings.HasSuffix(strings.ToLower(name), "-reset") {
+			continue
+		}
+		if at, err := time.Parse(time.RFC3339, values[0]); err == nil {
+			delay = max(delay, at.Sub(now))
+		}
+	}
+
+	// OpenAI reports durations such as "6m0s"
+	for _, name := range []string{"X-Ratelimit-Reset-Requests", "X-Ratelimit-Reset-Tokens"} {
+		if d, err := time.ParseDuration(header.Get(name)); err == nil {
+			delay = max(delay, d)
+		}
+	}
+
+	// Gemini reports RetryInfo in the error body
+	if match := retryDelayPattern.FindSubmatch(body); match != nil {
+		if d, err := time.ParseDuration(string(match[1])); err == nil {
+			delay = max(delay, d)
+		}
+	}
+
+	return delay
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+func TestRateLimitDelay(t *testing.T) {
+	now := time.Now()
+	for _, tt := range []struct {
+		name    string
+		status  int
+		header  map[string]string
+		body    string
+		limited bool
+		delay   time.Duration
+	}{
+		{name: "429 without hints uses the fallback", status: 429, limited: true, delay: 10 * time.Second},
+		{name: "Retry-After seconds", status: 429, header: map[string]string{"Retry-After": "7"}, limited: true, delay: 7 * time.Second},
+		{name: "Retry-After date", status: 429, header: map[string]string{"Retry-After": now.Add(90 * time.Second).UTC().Format(http.TimeFormat)}, limited: true, delay: 90 * time.Second},
+		{name: "Retry-After-Ms", status: 429, header: map[string]string{"Retry-After-Ms": "1500"}, limited: true, delay: 1500 * time.Millisecond},
+		{name: "Anthropic reset", status: 429, header: map[string]string{"Anthropic-Ratelimit-Tokens-Reset": now.Add(45 * time.Second).Format(time.RFC3339)}, limited: true, delay: 45 * time.Second},
+		{name: "OpenAI reset", status: 429, header: map[string]string{"X-Ratelimit-Reset-Requests": "6m0s"}, limited: true, delay: 6 * time.Minute},
+		{name: "Gemini retryDelay", status: 429, body: `{"error":{"details":[{"retryDelay":"30s"}]}}`, limited: true, delay: 30 * time.Second},
+		{name: "longest hint wins", status: 429, header: map[string]string{"Retry-After": "5", "X-Ratelimit-Reset-Tokens": "20s"}, limited: true, delay: 20 * time.Second},
+		{name: "capped", status: 429, header: map[string]string{"Retry-After": "86400"}, limited: true, delay: maxCooldown},
+		{name: "overloaded 529", status: 529, limited: true, delay: 10 * time.Second},
+		{name: "503 with a rate limit marker", status: 503, body: `{"type":"error","error":{"type":"overloaded_error"}}`, limited: true, delay: 10 * time.Second},
+		{name: "500 without a marker", status: 500, body: `{"error":"boom"}`},
+	} {
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		for name, value := range tt.header {
+			resp.Header.Set(name, value)
+		}
+		delay, limited := rateLimitDelay(resp, []byte(tt.body), 10*time.Second)
+		if limited != tt.limited {
+			t.Errorf("%s: limited %v, want %v", tt.name, limited, tt.limited)
+			continue
+		}
+		// Dates have whole seconds and are read a little after now
+		if diff := tt.delay - delay; diff < 0 || diff > 2*time.Second {
+			t.Errorf("%s: delay %v, want %v", tt.name, delay, tt.delay)
+		}
+	}
+}
+
+// rateLimitedUpstream serves failures 429 responses carrying header, then
+// 200s, and counts the requests it gets
+func rateLimitedUpstream(t *testing.T, failures int, header map[string]string) (port int, requests *atomic.Int32) {
+	t.Helper()
+	requests = &atomic.Int32{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(requests.Add(1)) <= failures {
+			for name, value := range header {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(w, `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"type":"message","usage":{"input_tokens":10,"output_tokens":5}}`)
+	}))
+	t.Cleanup(backend.Close)
+
+	u, _ := url.Parse(backend.URL)
+	port, _ = strconv.Atoi(u.Port())
+	return port, requests
+}
+
+// sendMessage sends a request for model through a new connection to tp and
+// returns the response
+func sendMessage(t *testing.T, tp *ThinkingProxy, model string) *http.Response {
+	t.Helper()
+	conn := serveProxy(t, tp)
+	body := fmt.Sprintf(`{"model":%q,"max_tokens":10}`, model)
+	fmt.Fprintf(conn, "POST /v1/messages HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+func TestForwardRetriesRateLimited(t *testing.T) {
+	quick := map[string]string{"Retry-After-Ms": "20"}
+	for _, tt := range []struct {
+		name       string
+		failures   int
+		header     map[string]string
+		maxRetries int
+		status     int
+		attempts   int32
+		retryAfter string // sent to the client with a final 429
+	}{
+		{name: "retried until success", failures: 2, header: quick, maxRetries: 2, status: 200, attempts: 3},
+		{name: "retry cap", failures: 5, header: quick, maxRetries: 2, status: 429, attempts: 3},
+		{name: "retries disabled", failures: 1, header: quick, maxRetries: 0, status: 429, attempts: 1},
+		{name: "delay over max-delay", failures: 1, header: map[string]string{"Retry-After": "60"}, maxRetries: 2, status: 429, attempts: 1, retryAfter: "60"},
+	} {
+		port, requests := rateLimitedUpstream(t, tt.failures, tt.header)
+		tp := NewThinkingProxy(0, port)
+		tp.SetUpstreamRetry(config.UpstreamRetryConfig{MaxRetries: tt.maxRetries, MaxDelay: 30 * time.Second, DefaultCooldown: time.Second, CooldownMode: "fail"})
+
+		resp := sendMessage(t, tp, "claude-sonnet-4-5")
+		if resp.StatusCode != tt.status || requests.Load() != tt.attempts {
+			t.Errorf("%s: %d after %d attempts, want %d after %d", tt.name, resp.StatusCode, requests.Load(), tt.status, tt.attempts)
+			continue
+		}
+		if tt.retryAfter != "" && resp.Header.Get("Retry-After") != tt.retryAfter {
+			t.Errorf("%s: Retry-After %q, want %q", tt.name, resp.Header.Get("Retry-After"), tt.retryAfter)
+		}
+		if tt.status == http.StatusTooManyRequests {
+			cooldowns := tp.Cooldowns()
+			if len(cooldowns) != 1 || cooldowns[0].Model != "claude-sonnet-4-5" {
+				t.Errorf("%s: cooldowns = %+v, want claude-sonnet-4-5", tt.name, cooldowns)
+			}
+		}
+	}
+}
+
+func TestCooldownWait(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		mode       string
+		cooldown   time.Duration
+		status     int
+		attempts   int32
+		minElapsed time.Duration
+		retryAfter string
+	}{
+		{name: "waited out", mode: "wait", cooldown: 200 * time.Millisecond, status: 200, attempts: 1, minElapsed: 150 * time.Millisecond},
+		{name: "longer than max-delay", mode: "wait", cooldown: 5 * time.Second, status: 429, retryAfter: "5"},
+		{name: "fail mode", mode: "fail", cooldown: 200 * time.Millisecond, status: 429, retryAfter: "1"},
+	} {
+		port, requests := rateLimitedUpstream(t, 0, nil)
+		tp := NewThinkingProxy(0, port)
+		tp.SetUpstreamRetry(config.UpstreamRetryConfig{MaxDelay: time.Second, DefaultCooldown: time.Second, CooldownMode: tt.mode})
+		tp.cooldowns.set("claude-sonnet-4-5", "", tt.cooldown, "upstream 429")
+
+		start := time.Now()
+		resp := sendMessage(t, tp, "claude-sonnet-4-5")
+		elapsed := time.Since(start)
+		switch {
+		case resp.StatusCode != tt.status || requests.Load() != tt.attempts:
+			t.Errorf("%s: %d after %d upstream requests, want %d after %d", tt.name, resp.StatusCode, requests.Load(), tt.status, tt.attempts)
+		case elapsed < tt.minElapsed:
+			t.Errorf("%s: answered after %v, before the %v cooldown ended", tt.name, elapsed, tt.cooldown)
+		case tt.status == http.StatusTooManyRequests && (resp.Header.Get("Retry-After") != tt.retryAfter || resp.Header.Get(errorHeader) != string(errUpstreamCooldown)):
+			t.Errorf("%s: Retry-After %q, %s %q; want %q, %s", tt.name, resp.Header.Get("Retry-After"), errorHeader, resp.Header.Get(errorHeader), tt.retryAfter, errUpstreamCooldown)
+		}
+	}
+}
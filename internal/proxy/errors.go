@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errorHeader carries the code of an error VibeProxy answered itself
+const errorHeader = "X-VibeProxy-Error"
+
+// errorCode identifies an error VibeProxy answers itself. The values are
+// stable; tooling may match on them.
+type errorCode string
+
+const (
+	errInvalidRequest        errorCode = "invalid_request"
+	errRequestTooLarge       errorCode = "request_too_large"
+	errRequestTimeout        errorCode = "request_timeout"
+	errInvalidThinkingBudget errorCode = "invalid_thinking_budget"
+	errInvalidAPIKey         errorCode = "invalid_api_key"
+	errModelNotAllowed       errorCode = "model_not_allowed"
+	errQuotaExceeded         errorCode = "quota_exceeded"
+	errRateLimited           errorCode = "rate_limited"
+	errUpstreamCooldown      errorCode = "upstream_cooldown"
+	errNoRecording           errorCode = "recording_not_found"
+	errProviderNotConnected  errorCode = "provider_not_connected"
+	errProviderTokenExpired  errorCode = "provider_token_expired"
+	errBackendUnavailable    errorCode = "backend_unavailable"
+	errBackendTimeout        errorCode = "backend_timeout"
+	errBackendError          errorCode = "backend_error"
+)
+
+// proxyError is an error response VibeProxy sends without asking CLIProxyAPI
+type proxyError struct {
+	status     int
+	code       errorCode
+	message    string
+	retryAfter time.Duration // sent as Retry-After with 429
+}
+
+func (e *proxyError) Error() string {
+	return e.message
+}
+
+// errorFormat returns the API whose error shape a client expects: "anthropic",
+// "gemini" or "openai"
+func errorFormat(req *http.Request) string {
+	if req == nil {
+		return "openai"
+	}
+	path := req.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/v1/messages"), req.Header.Get("Anthropic-Version") != "":
+		return "anthropic"
+	case strings.HasPrefix(path, "/v1beta/"), strings.Contains(path, ":generateContent"), strings.Contains(path, ":streamGenerateContent"):
+		return "gemini"
+	default:
+		return "openai"
+	}
+}
+
+// errorBody renders e the way the API named by format reports errors
+func errorBody(format string, e *proxyError) []byte {
+	var body interface{}
+	switch format {
+	case "anthropic":
+		body = map[string]interface{}{
+			"type": "error",
+			"error": map[string]interface{}{
+				"type":    anthropicErrorType(e.status),
+				"message": e.message,
+				"code":    e.code,
+			},
+		}
+	case "gemini":
+		body = map[string]interface{}{
+			"error": map[string]interface{}{
+				"code":    e.status,
+				"message": e.message,
+				"status":  geminiErrorStatus(e.status),
+				"details": []interface{}{map[string]interface{}{
+					"@type":  "type.googleapis.com/google.rpc.ErrorInfo",
+					"reason": e.code,
+					"domain": "vibeproxy",
+				}},
+			},
+		}
+	default:
+		body = map[string]interface{}{
+			"error": map[string]interface{}{
+				"message": e.message,
+				"type":    openAIErrorType(e.status),
+				"param":   nil,
+				"code":    e.code,
+			},
+		}
+	}
+	data, _ := json.Marshal(body)
+	return data
+}
+
+// anthropicErrorType maps a status to an Anthropic error type
+func anthropicErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return "timeout_error"
+	case 529:
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
+
+// openAIErrorType maps a status to an OpenAI error type
+func openAIErrorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= 500:
+		return "server_error"
+	default:
+		return "invalid_request_error"
+	}
+}
+
+// geminiErrorStatus maps a status to a Google RPC status name
+func geminiErrorStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		return "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED"
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return "DEADLINE_EXCEEDED"
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	default:
+		return "INTERNAL"
+	}
+}
+
+// sendError sends e in the error format req's client expects and closes
+// the connection; req is nil if the request could not be read
+func (tp *ThinkingProxy) sendError(conn net.Conn, req *http.Request, e *proxyError) {
+	body := errorBody(errorFormat(req), e)
+
+	var head strings.Builder
+	fmt.Fprintf(&head, "HTTP/1.1 %d %s\r\n", e.status, http.StatusText(e.status))
+	head.WriteString("Content-Type: application/json\r\n")
+	fmt.Fprintf(&head, "%s: %s\r\n", errorHeader, e.code)
+	if e.status == http.StatusTooManyRequests {
+		seconds := int(math.Ceil(e.retryAfter.Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		fmt.Fprintf(&head, "Retry-After: %d\r\n", seconds)
+	}
+	fmt.Fprintf(&head, "Content-Length: %d\r\n", len(body))
+	head.WriteString("Connection: close\r\n\r\n")
+
+	conn.Write(append([]byte(head.String()), body...))
+	conn.Close()
+}
+
+// rejectRequest sends e for a request turned away before it is recorded in
+// the inspector, and counts it in the request metrics
+func (tp *ThinkingProxy) rejectRequest(conn net.Conn, req *http.Request, e *proxyError) {
+	path := ""
+	if req != nil {
+		path = req.URL.Path
+	}
+	tp.metrics.observeRejected(path, e.status)
+	tp.sendError(conn, req, e)
+}
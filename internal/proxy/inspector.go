@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+// RequestRecord describes one exchange for the request inspector
+type RequestRecord struct {
+	ID             int64     `json:"id"`
+	Time           time.Time `json:"time"`
+	Client         string    `json:"client"`
+	ClientKey      string    `json:"clientKey,omitempty"`
+	User           string    `json:"user,omitempty"` // team member
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	Model          string    `json:"model,omitempty"`
+	BackendModel   string    `json:"backendModel,omitempty"`
+	ThinkingBudget int       `json:"thinkingBudget,omitempty"`
+	AccountHint    string    `json:"accountHint,omitempty"` // advisory, see accountHeader
+	Source         string    `json:"source,omitempty"`      // cache, replay or rejected when the backend was not called
+	Status         int       `json:"status"`
+	LatencyMs      int64     `json:"latencyMs"`
+	TTFTMs         int64     `json:"ttftMs,omitempty"`
+	Usage          Usage     `json:"usage"`
+
+	// Bodies are only returned by RequestDetail
+	RequestBody     string `json:"requestBody,omitempty"`
+	TransformedBody string `json:"transformedBody,omitempty"`
+}
+
+// requestLog keeps the most recent exchanges in a ring buffer
+type requestLog struct {
+	mu      sync.Mutex
+	config  config.InspectorConfig
+	entries []*RequestRecord
+	next    int
+	lastID  int64
+}
+
+// newRequestLog creates a log holding cfg.MaxEntries exchanges
+func newRequestLog(cfg config.InspectorConfig) *requestLog {
+	return &requestLog{config: cfg, entries: make([]*RequestRecord, 0, max(cfg.MaxEntries, 0))}
+}
+
+// add stores a finished exchange, redacting bodies as configured
+func (l *requestLog) add(rec *RequestRecord, original, transformed []byte) {
+	if l.config.MaxEntries <= 0 {
+		return
+	}
+
+	if l.config.Bodies {
+		rec.RequestBody = inspectorBody(original, l.config)
+		if !bytes.Equal(original, transformed) {
+			rec.TransformedBody = inspectorBody(transformed, l.config)
+		} else {
+			rec.TransformedBody = rec.RequestBody
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastID++
+	rec.ID = l.lastID
+	if len(l.entries) < l.config.MaxEntries {
+		l.entries = append(l.entries, rec)
+	} else {
+		l.entries[l.next] = rec
+	}
+	l.next = (l.next + 1) % l.config.MaxEntries
+}
+
+// recent returns up to limit exchanges, newest first, without bodies
+func (l *requestLog) recent(limit int) []RequestRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limit <= 0 || limit > len(l.entries) {
+		limit = len(l.entries)
+	}
+	result := make([]RequestRecord, 0, limit)
+	for i := 0; i < limit; i++ {
+		idx := (l.next - 1 - i + 2*len(l.entries)) % len(l.entries)
+		rec := *l.entries[idx]
+		rec.RequestBody = ""
+		rec.TransformedBody = ""
+		result = append(result, rec)
+	}
+	return result
+}
+
+// get returns one exchange including its bodies
+func (l *requestLog) get(id int64) (RequestRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, rec := range l.entries {
+		if rec.ID == id {
+			return *rec, true
+		}
+	}
+	return RequestRecord{}, false
+}
+
+// inspectorBody pretty-prints a JSON body for diffing, blanking redacted
+// fields and truncating it to the configured size
+func inspectorBody(body []byte, cfg config.InspectorConfig) string {
+	var parsed interface{}
+	if err := json.Unmarshal(body, &parsed); err == nil {
+		if len(cfg.RedactFields) > 0 {
+			redact := make(map[string]bool, len(cfg.RedactFields))
+			for _, field := range cfg.RedactFields {
+				redact[field] = true
+			}
+			parsed = redactFields(parsed, redact)
+		}
+		if pretty, err := json.MarshalIndent(parsed, "", "  "); err == nil {
+			body = pretty
+		}
+	}
+
+	if limit := cfg.MaxBodyKB << 10; limit > 0 && len(body) > limit {
+		return string(body[:limit]) + "\n… truncated"
+	}
+	return string(body)
+}
+
+// redactFields replaces the values of the named object keys at any depth
+func redactFields(value interface{}, redact map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if redact[key] {
+				v[key] = "[redacted]"
+			} else {
+				v[key] = redactFields(item, redact)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactFields(item, redact)
+		}
+	}
+	return value
+}
+
+// responseTiming notes when the first response body bytes arrive and how
+// many follow
+type responseTiming struct {
+	firstByte time.Time
+	bytes     int64
+}
+
+// observeHeader is a no-op; only the body matters
+func (o *responseTiming) observeHeader(resp *http.Response) {}
+
+// Write records the time of the first body bytes and counts the rest
+func (o *responseTiming) Write(p []byte) (int, error) {
+	if o.firstByte.IsZero() && len(p) > 0 {
+		o.firstByte = time.Now()
+	}
+	o.bytes += int64(len(p))
+	return len(p), nil
+}
+
+// newRequestRecord starts the inspector entry for a request
+func newRequestRecord(req *http.Request, clientConn net.Conn, model, backendModel string, body []byte, account string) *RequestRecord {
+	client := clientConn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+
+	rec := &RequestRecord{
+		Time:         time.Now(),
+		Client:       client,
+		Method:       req.Method,
+		Path:         req.URL.Path,
+		Model:        model,
+		BackendModel: backendModel,
+		AccountHint:  account,
+	}
+	if key := clientKey(req); key != "" {
+		rec.ClientKey = maskKey(key)
+	}
+
+	var params struct {
+		Thinking struct {
+			BudgetTokens int `json:"budget_tokens"`
+		} `json:"thinking"`
+	}
+	if json.Unmarshal(body, &params) == nil {
+		rec.ThinkingBudget = params.Thinking.BudgetTokens
+	}
+	return rec
+}
+
+// SetInspector replaces the request inspector configuration and drops its history
+func (tp *ThinkingProxy) SetInspector(cfg config.InspectorConfig) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.requests = newRequestLog(cfg)
+}
+
+// RecentRequests returns up to limit recent exchanges, newest first
+func (tp *ThinkingProxy) RecentRequests(limit int) []RequestRecord {
+	tp.mu.RLock()
+	requests := tp.requests
+	tp.mu.RUnlock()
+	return requests.recent(limit)
+}
+
+// RequestDetail returns one recent exchange with its request bodies
+func (tp *ThinkingProxy) RequestDetail(id int64) (RequestRecord, bool) {
+	tp.mu.RLock()
+	requests := tp.requests
+	tp.mu.RUnlock()
+	return requests.get(id)
+}
@@ -0,0 +1,343 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+// LimiterState describes the current state of one rate limiter
+type LimiterState struct {
+	Rule              string `json:"rule"`
+	ClientKey         string `json:"clientKey,omitempty"`
+	RequestsPerMinute int    `json:"requestsPerMinute,omitempty"`
+	RequestsAvailable int    `json:"requestsAvailable"`
+	TokensPerMinute   int    `json:"tokensPerMinute,omitempty"`
+	TokensAvailable   int    `json:"tokensAvailable"`
+	MaxConcurrent     int    `json:"maxConcurrent,omitempty"`
+	InFlight          int    `json:"inFlight"`
+	Waiting           int    `json:"waiting"`
+	Rejected          int    `json:"rejected"`
+}
+
+// tokenBucket refills continuously up to a per-minute capacity
+type tokenBucket struct {
+	capacity float64
+	level    float64
+	rate     float64 // tokens per second
+	last     time.Time
+}
+
+// newTokenBucket creates a full bucket, or nil when perMinute is unlimited
+func newTokenBucket(perMinute int, now time.Time) *tokenBucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &tokenBucket{
+		capacity: float64(perMinute),
+		level:    float64(perMinute),
+		rate:     float64(perMinute) / 60,
+		last:     now,
+	}
+}
+
+// wait returns how long until n tokens are available
+func (b *tokenBucket) wait(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.level = math.Min(b.capacity, b.level+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	n = math.Min(n, b.capacity)
+	if b.level >= n {
+		return 0
+	}
+	return time.Duration((n - b.level) / b.rate * float64(time.Second))
+}
+
+// take removes n tokens; callers must check wait first
+func (b *tokenBucket) take(n float64) {
+	if b == nil {
+		return
+	}
+	b.level -= math.Min(n, b.capacity)
+}
+
+// full reports whether the bucket has refilled completely
+func (b *tokenBucket) full() bool {
+	return b == nil || b.level >= b.capacity
+}
+
+// available returns the whole number of tokens in the bucket
+func (b *tokenBucket) available() int {
+	if b == nil {
+		return 0
+	}
+	return int(b.level)
+}
+
+// limitState holds the buckets and counters of one rule (and client key)
+type limitState struct {
+	rule     config.RateLimitRule
+	key      string
+	requests *tokenBucket
+	tokens   *tokenBucket
+	inFlight int
+	waiting  int
+	rejected int
+}
+
+// evictInterval is how often idle per-client-key limiters are dropped
+const evictInterval = time.Minute
+
+// rateLimiter enforces the configured rate limit rules
+type rateLimiter struct {
+	mu        sync.Mutex
+	config    config.RateLimitConfig
+	states    map[string]*limitState
+	order     []string
+	changed   chan struct{}
+	lastEvict time.Time
+	now       func() time.Time // time.Now, replaced in tests
+}
+
+// newRateLimiter creates a limiter for cfg
+func newRateLimiter(cfg config.RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		config:  cfg,
+		states:  make(map[string]*limitState),
+		changed: make(chan struct{}),
+		now:     time.Now,
+	}
+}
+
+// evictIdle drops per-client-key states that hold nothing: no requests in
+// flight or waiting and full buckets, so a new state would be identical.
+// Otherwise clients could grow the map without bound by rotating keys.
+// Callers must hold rl.mu.
+func (rl *rateLimiter) evictIdle(now time.Time) {
+	if now.Sub(rl.lastEvict) < evictInterval {
+		return
+	}
+	rl.lastEvict = now
+
+	order := rl.order[:0]
+	for _, id := range rl.order {
+		state := rl.states[id]
+		state.requests.wait(0, now)
+		state.tokens.wait(0, now)
+		if state.rule.PerClientKey && state.inFlight == 0 && state.waiting == 0 && state.requests.full() && state.tokens.full() {
+			delete(rl.states, id)
+			continue
+		}
+		order = append(order, id)
+	}
+	rl.order = order
+}
+
+// matching returns the states of every rule matching the request.
+// Callers must hold rl.mu.
+func (rl *rateLimiter) matching(key, provider, model string, now time.Time) []*limitState {
+	rl.evictIdle(now)
+
+	var states []*limitState
+	for i, rule := range rl.config.Rules {
+		if rule.Provider != "" && rule.Provider != provider {
+			continue
+		}
+		if rule.Model != "" && !globMatch(rule.Model, model) {
+			continue
+		}
+		if rule.ClientKey != "" && rule.ClientKey != key {
+			continue
+		}
+
+		id := fmt.Sprintf("%d", i)
+		stateKey := ""
+		if rule.PerClientKey {
+			stateKey = key
+			id += "/" + key
+		}
+
+		state, ok := rl.states[id]
+		if !ok {
+			state = &limitState{
+				rule:     rule,
+				key:      stateKey,
+				requests: newTokenBucket(rule.RequestsPerMinute, now),
+				tokens:   newTokenBucket(rule.TokensPerMinute, now),
+			}
+			rl.states[id] = state
+			rl.order = append(rl.order, id)
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// acquire waits until every matching limiter has capacity, up to MaxWait or
+// until ctx ends. On success it returns a release func to call when the
+// request finishes; otherwise it returns how long the client should wait
+// before retrying.
+func (rl *rateLimiter) acquire(ctx context.Context, key, provider, model string, estimatedTokens int) (func(), time.Duration, bool) {
+	deadline := rl.now().Add(rl.config.MaxWait)
+	tokens := float64(estimatedTokens)
+	var queued []*limitState
+
+	defer func() {
+		if len(queued) == 0 {
+			return
+		}
+		rl.mu.Lock()
+		for _, state := range queued {
+			state.waiting--
+		}
+		rl.mu.Unlock()
+	}()
+
+	for {
+		now := rl.now()
+		rl.mu.Lock()
+		states := rl.matching(key, provider, model, now)
+		if len(states) == 0 {
+			rl.mu.Unlock()
+			return func() {}, 0, true
+		}
+
+		var wait time.Duration
+		saturated := false
+		for _, state := range states {
+			if state.rule.MaxConcurrent > 0 && state.inFlight >= state.rule.MaxConcurrent {
+				saturated = true
+			}
+			wait = max(wait, state.requests.wait(1, now), state.tokens.wait(tokens, now))
+		}
+
+		if wait == 0 && !saturated {
+			for _, state := range states {
+				state.requests.take(1)
+				state.tokens.take(tokens)
+				state.inFlight++
+			}
+			rl.mu.Unlock()
+			return rl.releaser(states), 0, true
+		}
+
+		retryAfter := wait
+		if saturated {
+			retryAfter = max(retryAfter, time.Second)
+		}
+
+		remaining := deadline.Sub(now)
+		if remaining <= 0 || wait > remaining {
+			for _, state := range states {
+				state.rejected++
+			}
+			rl.mu.Unlock()
+			return nil, retryAfter, false
+		}
+
+		if queued == nil {
+			queued = states
+			for _, state := range queued {
+				state.waiting++
+			}
+		}
+		changed := rl.changed
+		rl.mu.Unlock()
+
+		if wait == 0 {
+			wait = remaining
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, retryAfter, false
+		}
+		timer.Stop()
+	}
+}
+
+// releaser returns a func that frees the concurrency slots held in states
+func (rl *rateLimiter) releaser(states []*limitState) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			rl.mu.Lock()
+			defer rl.mu.Unlock()
+			for _, state := range states {
+				state.inFlight--
+			}
+			close(rl.changed)
+			rl.changed = make(chan struct{})
+		})
+	}
+}
+
+// status returns the state of every limiter created so far
+func (rl *rateLimiter) status() []LimiterState {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	result := make([]LimiterState, 0, len(rl.order))
+	for _, id := range rl.order {
+		state := rl.states[id]
+		state.requests.wait(0, now)
+		state.tokens.wait(0, now)
+
+		name := state.rule.Name
+		if name == "" {
+			name = id
+		}
+		result = append(result, LimiterState{
+			Rule:              name,
+			ClientKey:         maskKey(state.key),
+			RequestsPerMinute: state.rule.RequestsPerMinute,
+			RequestsAvailable: state.requests.available(),
+			TokensPerMinute:   state.rule.TokensPerMinute,
+			TokensAvailable:   state.tokens.available(),
+			MaxConcurrent:     state.rule.MaxConcurrent,
+			InFlight:          state.inFlight,
+			Waiting:           state.waiting,
+			Rejected:          state.rejected,
+		})
+	}
+	return result
+}
+
+// SetRateLimits replaces the rate limit configuration, resetting all limiters
+func (tp *ThinkingProxy) SetRateLimits(cfg config.RateLimitConfig) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.limiter = newRateLimiter(cfg)
+}
+
+// RateLimitStatus returns the current state of all rate limiters
+func (tp *ThinkingProxy) RateLimitStatus() []LimiterState {
+	tp.mu.RLock()
+	limiter := tp.limiter
+	tp.mu.RUnlock()
+	return limiter.status()
+}
+
+// estimateTokens roughly estimates the prompt tokens in a request body
+func estimateTokens(body []byte) int {
+	return len(body)/4 + 1
+}
+
+// maskKey hides all but the first characters of a client key
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return key
+	}
+	return key[:4] + "…"
+}
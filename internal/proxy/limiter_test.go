@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+// fakeClock is a clock that only moves when advanced
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	start := newFakeClock().Now()
+	for _, tt := range []struct {
+		name      string
+		perMinute int
+		take      float64
+		elapsed   time.Duration
+		need      float64
+		wait      time.Duration
+		available int
+	}{
+		{"full", 60, 0, 0, 1, 0, 60},
+		{"empty", 60, 60, 0, 1, time.Second, 0},
+		{"half refilled", 60, 60, 30 * time.Second, 1, 0, 30},
+		{"refill capped at capacity", 60, 60, 5 * time.Minute, 1, 0, 60},
+		{"partial refill short of need", 120, 120, 5 * time.Second, 30, 10 * time.Second, 10},
+		{"need above capacity waits for a full bucket", 10, 5, 0, 50, 30 * time.Second, 5},
+	} {
+		b := newTokenBucket(tt.perMinute, start)
+		b.take(tt.take)
+		if wait := b.wait(tt.need, start.Add(tt.elapsed)); wait != tt.wait {
+			t.Errorf("%s: wait %v, want %v", tt.name, wait, tt.wait)
+		}
+		if available := b.available(); available != tt.available {
+			t.Errorf("%s: %d available, want %d", tt.name, available, tt.available)
+		}
+	}
+
+	if b := newTokenBucket(0, start); b != nil || b.wait(1e9, start) != 0 {
+		t.Error("unlimited bucket made a request wait")
+	}
+}
+
+func TestRateLimiterAcquire(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		key     string
+		tokens  int
+		release bool // release every slot held so far first
+		ok      bool
+		retry   time.Duration // when rejected
+	}
+	for _, tt := range []struct {
+		name  string
+		rule  config.RateLimitRule
+		steps []step
+	}{
+		{
+			name: "requests per minute",
+			rule: config.RateLimitRule{RequestsPerMinute: 2},
+			steps: []step{
+				{ok: true},
+				{ok: true},
+				{retry: 30 * time.Second},
+				{advance: 20 * time.Second, retry: 10 * time.Second},
+				{advance: 10 * time.Second, ok: true},
+			},
+		},
+		{
+			name: "tokens per minute",
+			rule: config.RateLimitRule{TokensPerMinute: 1200},
+			steps: []step{
+				{tokens: 800, ok: true},
+				{tokens: 800, retry: 20 * time.Second},
+				{tokens: 400, ok: true},
+				{advance: time.Minute, tokens: 800, ok: true},
+			},
+		},
+		{
+			name: "global concurrency",
+			rule: config.RateLimitRule{MaxConcurrent: 2},
+			steps: []step{
+				{key: "a", ok: true},
+				{key: "b", ok: true},
+				{key: "c", retry: time.Second},
+				{key: "c", release: true, ok: true},
+			},
+		},
+		{
+			name: "per-key concurrency",
+			rule: config.RateLimitRule{MaxConcurrent: 1, PerClientKey: true},
+			steps: []step{
+				{key: "a", ok: true},
+				{key: "a", retry: time.Second},
+				{key: "b", ok: true},
+				{key: "a", release: true, ok: true},
+			},
+		},
+		{
+			name: "per-key requests",
+			rule: config.RateLimitRule{RequestsPerMinute: 1, PerClientKey: true},
+			steps: []step{
+				{key: "a", ok: true},
+				{key: "b", ok: true},
+				{key: "a", retry: time.Minute},
+			},
+		},
+		{
+			name: "other client key not matched",
+			rule: config.RateLimitRule{ClientKey: "a", MaxConcurrent: 1},
+			steps: []step{
+				{key: "a", ok: true},
+				{key: "b", ok: true},
+				{key: "a", retry: time.Second},
+			},
+		},
+	} {
+		clock := newFakeClock()
+		rl := newRateLimiter(config.RateLimitConfig{Rules: []config.RateLimitRule{tt.rule}})
+		rl.now = clock.Now
+
+		var held []func()
+		for i, s := range tt.steps {
+			clock.advance(s.advance)
+			if s.release {
+				for _, release := range held {
+					release()
+				}
+				held = nil
+			}
+			release, retry, ok := rl.acquire(context.Background(), s.key, "claude", "claude-sonnet-4-5", s.tokens)
+			switch {
+			case ok != s.ok:
+				t.Fatalf("%s: step %d admitted %v, want %v", tt.name, i, ok, s.ok)
+			case ok:
+				held = append(held, release)
+			case retry != s.retry:
+				t.Fatalf("%s: step %d retry after %v, want %v", tt.name, i, retry, s.retry)
+			}
+		}
+	}
+}
+
+func TestRateLimiterQueuedRequestWokenByRelease(t *testing.T) {
+	clock := newFakeClock()
+	rl := newRateLimiter(config.RateLimitConfig{
+		MaxWait: 5 * time.Second,
+		Rules:   []config.RateLimitRule{{Name: "one", MaxConcurrent: 1}},
+	})
+	rl.now = clock.Now
+
+	release, _, ok := rl.acquire(context.Background(), "", "claude", "claude-sonnet-4-5", 0)
+	if !ok {
+		t.Fatal("first request rejected")
+	}
+	done := make(chan bool)
+	go func() {
+		release, _, ok := rl.acquire(context.Background(), "", "claude", "claude-sonnet-4-5", 0)
+		if ok {
+			release()
+		}
+		done <- ok
+	}()
+
+	for rl.status()[0].Waiting != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	release()
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatal("queued request rejected after release")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("queued request not woken by release")
+	}
+	if state := rl.status()[0]; state.InFlight != 0 || state.Waiting != 0 || state.Rejected != 0 {
+		t.Fatalf("state = %+v, want idle", state)
+	}
+}
+
+func TestRateLimitedRetryAfter(t *testing.T) {
+	for _, tt := range []struct {
+		retryAfter time.Duration
+		want       string
+	}{
+		{0, "1"},
+		{300 * time.Millisecond, "1"},
+		{time.Second, "1"},
+		{12*time.Second + time.Millisecond, "13"},
+		{time.Minute, "60"},
+	} {
+		client, server := net.Pipe()
+		req, _ := http.NewRequest(http.MethodPost, "/v1/messages", nil)
+		go NewThinkingProxy(0, 0).sendError(server, req, &proxyError{
+			status: http.StatusTooManyRequests, code: errRateLimited, message: "Rate limit exceeded", retryAfter: tt.retryAfter,
+		})
+
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		client.Close()
+		if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != tt.want {
+			t.Errorf("retry after %v: %d with Retry-After %q, want 429 with %q", tt.retryAfter, resp.StatusCode, resp.Header.Get("Retry-After"), tt.want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+// SetLimits replaces the request body limit and client/upstream timeouts
+func (tp *ThinkingProxy) SetLimits(cfg config.LimitsConfig) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.limits = cfg
+}
+
+// timeouts returns the client and upstream timeouts for a request
+func (tp *ThinkingProxy) timeouts(req *http.Request, model string, body []byte) (config.Timeouts, config.Timeouts) {
+	tp.mu.RLock()
+	limits := tp.limits
+	tp.mu.RUnlock()
+
+	return limits.Timeouts(func(route config.TimeoutRoute) bool {
+		if route.Path != "" && !globMatch(route.Path, req.URL.Path) {
+			return false
+		}
+		if route.Model != "" && !globMatch(route.Model, model) {
+			return false
+		}
+		return !route.Stream || requestStream(body)
+	})
+}
+
+// bodyTooLarge is the error for a request body over limit bytes
+func bodyTooLarge(limit int64) *proxyError {
+	return &proxyError{
+		status:  http.StatusRequestEntityTooLarge,
+		code:    errRequestTooLarge,
+		message: fmt.Sprintf("Request body exceeds the %d MB limit", limit>>20),
+	}
+}
+
+// requestStream reports whether a JSON request body asks for a streamed response
+func requestStream(body []byte) bool {
+	var req struct {
+		Stream bool `json:"stream"`
+	}
+	return json.Unmarshal(body, &req) == nil && req.Stream
+}
+
+// isTimeout reports whether err is a deadline set by a timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// errorWriteTimeout bounds sending an error to a client that ran out of time
+const errorWriteTimeout = 5 * time.Second
+
+// Bounds on draining a refused request body before closing
+const (
+	lingerTimeout = 500 * time.Millisecond
+	lingerBytes   = 4 << 20
+)
+
+// deadlineConn moves its deadlines before every read and write, so a peer
+// that stops sending or receiving for the idle timeout, or overruns the
+// overall deadline, gets a timeout error instead of holding the connection
+type deadlineConn struct {
+	net.Conn
+	mu       sync.Mutex
+	idle     time.Duration
+	deadline time.Time // zero for none
+	received int64     // bytes read so far
+}
+
+// limit sets the idle timeout and overall deadline; zero values disable them
+func (c *deadlineConn) limit(idle time.Duration, deadline time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.idle, c.deadline = idle, deadline
+}
+
+// next returns the deadline for an operation starting now
+func (c *deadlineConn) next() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deadline := c.deadline
+	if c.idle > 0 {
+		if idle := time.Now().Add(c.idle); deadline.IsZero() || idle.Before(deadline) {
+			deadline = idle
+		}
+	}
+	return deadline
+}
+
+func (c *deadlineConn) Read(p []byte) (int, error) {
+	c.Conn.SetReadDeadline(c.next())
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.received += int64(n)
+	c.mu.Unlock()
+	return n, err
+}
+
+// started reports whether the peer has sent anything
+func (c *deadlineConn) started() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.received > 0
+}
+
+func (c *deadlineConn) Write(p []byte) (int, error) {
+	c.Conn.SetWriteDeadline(c.next())
+	return c.Conn.Write(p)
+}
+
+// lingerConn closes after draining what the client still sends, for a
+// moment. Closing with unread data resets the connection, which can discard
+// the response before the client reads it.
+type lingerConn struct {
+	*deadlineConn
+}
+
+func (c lingerConn) Close() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	c.limit(0, time.Now().Add(lingerTimeout))
+	io.Copy(io.Discard, io.LimitReader(c.deadlineConn, lingerBytes))
+	return c.deadlineConn.Close()
+}
+
+// clientContext returns a context that ends when the client hangs up, the
+// proxy shuts down or deadline (zero for none) passes, for waits that do not
+// touch the connection. The request must have been read completely; stop
+// ends the watch and must be called before conn is read again.
+func (tp *ThinkingProxy) clientContext(conn net.Conn, deadline time.Time) (context.Context, func()) {
+	base, cancel := context.WithCancel(context.Background())
+	ctx, cancelDeadline := base, context.CancelFunc(func() {})
+	if !deadline.IsZero() {
+		ctx, cancelDeadline = context.WithDeadline(base, deadline)
+	}
+	tp.mu.RLock()
+	done := tp.done
+	tp.mu.RUnlock()
+
+	// Nothing more is expected from the client, so a read returns only
+	// when it closes the connection
+	var mu sync.Mutex
+	stopped := false
+	watching := make(chan struct{})
+	go func() {
+		defer close(watching)
+		mu.Lock()
+		if stopped {
+			mu.Unlock()
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+		mu.Unlock()
+
+		var b [1]byte
+		_, err := conn.Read(b[:])
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil && !stopped {
+			cancel()
+		}
+	}()
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		mu.Lock()
+		stopped = true
+		conn.SetReadDeadline(time.Now())
+		mu.Unlock()
+		<-watching
+		cancelDeadline()
+		cancel()
+	}
+}
+
+// sleep waits for d, returning false if ctx ends first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// after returns the time d from start, or zero when d is zero
+func after(start time.Time, d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return start.Add(d)
+}
+
+// earliest returns the earlier of two deadlines, where zero means none
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+// dialProxy starts a proxy with limits on a loopback listener and returns a
+// connection to it
+func dialProxy(t *testing.T, limits config.LimitsConfig) net.Conn {
+	t.Helper()
+	tp := NewThinkingProxy(0, 0)
+	tp.SetLimits(limits)
+	return serveProxy(t, tp)
+}
+
+// serveProxy serves tp on a loopback listener and returns a connection to it
+func serveProxy(t *testing.T, tp *ThinkingProxy) net.Conn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go tp.handleConnection(conn)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// drip writes data a few bytes at a time until it is written or the proxy
+// hangs up
+func drip(conn net.Conn, data string, pause time.Duration) {
+	for i := 0; i < len(data); i += 4 {
+		end := min(i+4, len(data))
+		if _, err := conn.Write([]byte(data[i:end])); err != nil {
+			return
+		}
+		time.Sleep(pause)
+	}
+}
+
+// expectClosed reads the proxy's response and fails unless it has status and
+// the proxy then closes the connection
+func expectClosed(t *testing.T, conn net.Conn, status int) {
+	t.Helper()
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Fatalf("status %d, want %d", resp.StatusCode, status)
+	}
+	if _, err := reader.ReadByte(); !errors.Is(err, io.EOF) {
+		t.Fatalf("connection still open after %d: read error %v", status, err)
+	}
+}
+
+func TestHeaderDripPastHeaderTimeout(t *testing.T) {
+	conn := dialProxy(t, config.LimitsConfig{
+		MaxBodyMB: 1,
+		Client:    config.Timeouts{Header: 200 * time.Millisecond, Idle: 5 * time.Second},
+	})
+
+	// Every byte arrives well within the idle timeout, the head never ends
+	head := "POST /v1/messages HTTP/1.1\r\nHost: localhost\r\n" + strings.Repeat("X-Padding: drip\r\n", 50)
+	go drip(conn, head, 20*time.Millisecond)
+
+	start := time.Now()
+	expectClosed(t, conn, http.StatusRequestTimeout)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("closed after %v, want about the 200ms header timeout", elapsed)
+	}
+}
+
+func TestBodyStallPastIdleTimeout(t *testing.T) {
+	conn := dialProxy(t, config.LimitsConfig{
+		MaxBodyMB: 1,
+		Client:    config.Timeouts{Header: 5 * time.Second, Idle: 200 * time.Millisecond},
+	})
+
+	// Part of the promised body, then nothing
+	req := "POST /v1/messages HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{\"model\":"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	expectClosed(t, conn, http.StatusRequestTimeout)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("closed after %v, want about the 200ms idle timeout", elapsed)
+	}
+}
+
+func TestBodyOverSizeLimit(t *testing.T) {
+	limits := config.LimitsConfig{
+		MaxBodyMB: 1,
+		Client:    config.Timeouts{Header: 5 * time.Second, Idle: 5 * time.Second},
+	}
+
+	t.Run("content-length", func(t *testing.T) {
+		conn := dialProxy(t, limits)
+		req := "POST /v1/messages HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/json\r\nContent-Length: 2097152\r\n\r\n"
+		if _, err := conn.Write([]byte(req)); err != nil {
+			t.Fatal(err)
+		}
+		expectClosed(t, conn, http.StatusRequestEntityTooLarge)
+	})
+
+	t.Run("chunked", func(t *testing.T) {
+		conn := dialProxy(t, limits)
+		chunk := strings.Repeat("a", 64<<10)
+		var req strings.Builder
+		req.WriteString("POST /v1/messages HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/json\r\nTransfer-Encoding: chunked\r\n\r\n")
+		for i := 0; i < 17; i++ { // 17 x 64 KB, just over 1 MB
+			req.WriteString("10000\r\n" + chunk + "\r\n")
+		}
+		req.WriteString("0\r\n\r\n")
+		go conn.Write([]byte(req.String()))
+
+		expectClosed(t, conn, http.StatusRequestEntityTooLarge)
+	})
+}
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/metrics"
+)
+
+var (
+	// latencyBuckets covers quick errors up to long agent turns, in seconds
+	latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+	// ttfbBuckets covers time to the first response byte, in seconds
+	ttfbBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+)
+
+// maxModelLabels bounds the distinct values of the model label
+const maxModelLabels = 100
+
+// otherLabel replaces label values outside the known set
+const otherLabel = "other"
+
+// metricPaths are the API routes reported as path label values
+var metricPaths = map[string]bool{
+	"/v1/messages":              true,
+	"/v1/messages/count_tokens": true,
+	"/v1/chat/completions":      true,
+	"/v1/completions":           true,
+	"/v1/responses":             true,
+	"/v1/models":                true,
+	"/v1beta/models":            true,
+}
+
+// metricPath maps a request path to a known route, with Gemini's model
+// name replaced, or to "other"
+func metricPath(path string) string {
+	if metricPaths[path] {
+		return path
+	}
+	if rest, ok := strings.CutPrefix(path, "/v1beta/models/"); ok {
+		if _, method, ok := strings.Cut(rest, ":"); ok {
+			switch method {
+			case "generateContent", "streamGenerateContent", "countTokens":
+				return "/v1beta/models/{model}:" + method
+			}
+		}
+	}
+	return otherLabel
+}
+
+// modelLabels admits a model as a label value once the backend has served
+// it, so clients cannot create series by sending made-up names
+type modelLabels struct {
+	mu    sync.Mutex
+	known map[string]bool
+}
+
+// label returns model, or "other" for a model the backend has not served;
+// served admits it, up to maxModelLabels models
+func (l *modelLabels) label(model string, served bool) string {
+	if model == "" {
+		return ""
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.known[model] {
+		return model
+	}
+	if served && len(l.known) < maxModelLabels {
+		l.known[model] = true
+		return model
+	}
+	return otherLabel
+}
+
+// proxyMetrics are the Prometheus metrics collected by ThinkingProxy
+type proxyMetrics struct {
+	models          modelLabels
+	requests        *metrics.CounterVec
+	duration        *metrics.HistogramVec
+	ttfb            *metrics.HistogramVec
+	transformations *metrics.CounterVec
+	responseBytes   *metrics.CounterVec
+	tokens          *metrics.CounterVec
+}
+
+// newProxyMetrics creates the proxy metric families
+func newProxyMetrics() *proxyMetrics {
+	return &proxyMetrics{
+		models: modelLabels{known: make(map[string]bool)},
+		requests: metrics.NewCounterVec("vibeproxy_requests_total",
+			"Requests handled by the thinking proxy.", "path", "model", "status"),
+		duration: metrics.NewHistogramVec("vibeproxy_request_duration_seconds",
+			"Time from reading the request to the end of the response.", latencyBuckets, "path", "model"),
+		ttfb: metrics.NewHistogramVec("vibeproxy_time_to_first_byte_seconds",
+			"Time from reading the request to the first response body byte.", ttfbBuckets, "path", "model"),
+		transformations: metrics.NewCounterVec("vibeproxy_transformations_total",
+			"Request bodies rewritten by the proxy, by transformation.", "type"),
+		responseBytes: metrics.NewCounterVec("vibeproxy_response_bytes_total",
+			"Decoded response body bytes streamed to clients.", "path", "model"),
+		tokens: metrics.NewCounterVec("vibeproxy_tokens_total",
+			"Tokens reported by providers, by direction.", "model", "type"),
+	}
+}
+
+// observe records a finished exchange
+func (m *proxyMetrics) observe(rec *RequestRecord, latency, ttfb time.Duration, bytes int64) {
+	served := rec.Source == "" && rec.Status >= 200 && rec.Status < 300
+	path, model := metricPath(rec.Path), m.models.label(rec.BackendModel, served)
+	m.requests.Inc(path, model, strconv.Itoa(rec.Status))
+	m.duration.Observe(latency.Seconds(), path, model)
+	if ttfb > 0 {
+		m.ttfb.Observe(ttfb.Seconds(), path, model)
+	}
+	if bytes > 0 {
+		m.responseBytes.Add(float64(bytes), path, model)
+	}
+	if rec.Usage.InputTokens > 0 {
+		m.tokens.Add(float64(rec.Usage.InputTokens), model, "input")
+	}
+	if rec.Usage.OutputTokens > 0 {
+		m.tokens.Add(float64(rec.Usage.OutputTokens), model, "output")
+	}
+}
+
+// observeRejected counts a request rejected before it was recorded, such as
+// a malformed request or a body over the size limit; path is empty when the
+// request line could not be read
+func (m *proxyMetrics) observeRejected(path string, status int) {
+	m.requests.Inc(metricPath(path), "", strconv.Itoa(status))
+}
+
+// RegisterMetrics adds the proxy metrics to reg
+func (tp *ThinkingProxy) RegisterMetrics(reg *metrics.Registry) {
+	m := tp.metrics
+	reg.Register(m.requests, m.duration, m.ttfb, m.transformations, m.responseBytes, m.tokens)
+	reg.Register(metrics.NewGaugeFunc("vibeproxy_active_connections",
+		"Open client connections, including streaming responses.", nil,
+		func(emit func(float64, ...string)) { emit(float64(tp.ActiveConnections())) }))
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+	"github.com/automazeio/vibeproxy/internal/metrics"
+)
+
+func TestMetricPath(t *testing.T) {
+	for path, want := range map[string]string{
+		"/v1/messages":                                  "/v1/messages",
+		"/v1/messages/count_tokens":                     "/v1/messages/count_tokens",
+		"/v1/chat/completions":                          "/v1/chat/completions",
+		"/v1beta/models/gemini-2.5-pro:generateContent": "/v1beta/models/{model}:generateContent",
+		"/v1beta/models/anything:streamGenerateContent": "/v1beta/models/{model}:streamGenerateContent",
+		"/v1beta/models/x:deleteEverything":             "other",
+		"/v1/messages/../../etc/passwd":                 "other",
+		"/random-1234":                                  "other",
+		"":                                              "other",
+	} {
+		if got := metricPath(path); got != want {
+			t.Errorf("metricPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestModelLabelsAdmitServedModelsOnly(t *testing.T) {
+	m := newProxyMetrics()
+
+	served := &RequestRecord{Path: "/v1/messages", BackendModel: "claude-sonnet-4-5", Status: 200}
+	m.observe(served, time.Second, 0, 0)
+	for i := 0; i < 50; i++ {
+		m.observe(&RequestRecord{Path: fmt.Sprintf("/junk-%d", i), BackendModel: fmt.Sprintf("made-up-%d", i), Status: 400}, time.Second, 0, 0)
+	}
+	cached := &RequestRecord{Path: "/v1/messages", BackendModel: "never-served", Status: 200, Source: "cache"}
+	m.observe(cached, time.Second, 0, 0)
+
+	out := writeMetrics(t, m)
+	for _, want := range []string{
+		`vibeproxy_requests_total{path="/v1/messages",model="claude-sonnet-4-5",status="200"} 1`,
+		`vibeproxy_requests_total{path="other",model="other",status="400"} 50`,
+		`vibeproxy_requests_total{path="/v1/messages",model="other",status="200"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "made-up") || strings.Contains(out, "junk") || strings.Contains(out, "never-served") {
+		t.Errorf("client-chosen label values exported:\n%s", out)
+	}
+
+	for i := 0; i < maxModelLabels+10; i++ {
+		m.models.label(fmt.Sprintf("served-%d", i), true)
+	}
+	if got := m.models.label("one-too-many", true); got != otherLabel {
+		t.Errorf("label past the limit = %q, want %q", got, otherLabel)
+	}
+}
+
+func TestRejectedRequestsAreCounted(t *testing.T) {
+	tp := NewThinkingProxy(0, 0)
+	tp.SetLimits(config.LimitsConfig{MaxBodyMB: 1, Client: config.Timeouts{Header: 5 * time.Second, Idle: 5 * time.Second}})
+
+	send := func(req string, status int) {
+		t.Helper()
+		conn := serveProxy(t, tp)
+		if _, err := conn.Write([]byte(req)); err != nil {
+			t.Fatal(err)
+		}
+		expectClosed(t, conn, status)
+	}
+	send("POST /v1/messages HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2097152\r\n\r\n", http.StatusRequestEntityTooLarge)
+	send("garbage\r\n\r\n", http.StatusBadRequest)
+	body := `{"model":"claude-sonnet-4-5-thinking-abc"}`
+	send(fmt.Sprintf("POST /v1/messages HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n%s", len(body), body), http.StatusBadRequest)
+
+	out := writeMetrics(t, tp.metrics)
+	for _, want := range []string{
+		`vibeproxy_requests_total{path="/v1/messages",model="",status="413"} 1`,
+		`vibeproxy_requests_total{path="other",model="",status="400"} 1`,
+		`vibeproxy_requests_total{path="/v1/messages",model="",status="400"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s in:\n%s", want, out)
+		}
+	}
+}
+
+// writeMetrics returns m in the Prometheus text format
+func writeMetrics(t *testing.T, m *proxyMetrics) string {
+	t.Helper()
+	reg := metrics.NewRegistry()
+	reg.Register(m.requests)
+	var buf bytes.Buffer
+	if err := reg.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// maxCacheBreakpoints is Anthropic's limit of cache_control blocks per request
+const maxCacheBreakpoints = 4
+
+// PromptCacheStats summarizes prompt cache usage reported by Anthropic
+type PromptCacheStats struct {
+	Enabled             bool `json:"enabled"`
+	InjectedRequests    int  `json:"injectedRequests"`
+	InjectedBreakpoints int  `json:"injectedBreakpoints"`
+	Responses           int  `json:"responses"`
+	CacheReadTokens     int  `json:"cacheReadTokens"`
+	CacheCreationTokens int  `json:"cacheCreationTokens"`
+	UncachedInputTokens int  `json:"uncachedInputTokens"`
+}
+
+// promptCache tracks injection and cache hit/miss counters
+type promptCache struct {
+	mu    sync.Mutex
+	stats PromptCacheStats
+}
+
+// SetPromptCacheInjection enables or disables automatic cache_control breakpoints
+func (tp *ThinkingProxy) SetPromptCacheInjection(enabled bool) {
+	tp.promptCache.mu.Lock()
+	defer tp.promptCache.mu.Unlock()
+	tp.promptCache.stats.Enabled = enabled
+}
+
+// PromptCacheStats returns prompt cache counters since startup
+func (tp *ThinkingProxy) PromptCacheStats() PromptCacheStats {
+	tp.promptCache.mu.Lock()
+	defer tp.promptCache.mu.Unlock()
+	return tp.promptCache.stats
+}
+
+// promptCacheEnabled reports whether breakpoints should be injected
+func (tp *ThinkingProxy) promptCacheEnabled() bool {
+	tp.promptCache.mu.Lock()
+	defer tp.promptCache.mu.Unlock()
+	return tp.promptCache.stats.Enabled
+}
+
+// applyPromptCache injects breakpoints into Anthropic Messages requests
+func (tp *ThinkingProxy) applyPromptCache(path string, bodyBytes []byte) ([]byte, bool) {
+	if !tp.promptCacheEnabled() || !strings.HasPrefix(path, "/v1/messages") || strings.HasPrefix(path, "/v1/messages/count_tokens") {
+		return bodyBytes, false
+	}
+
+	modified, added := injectPromptCache(bodyBytes)
+	if added == 0 {
+		return bodyBytes, false
+	}
+
+	tp.promptCache.mu.Lock()
+	tp.promptCache.stats.InjectedRequests++
+	tp.promptCache.stats.InjectedBreakpoints += added
+	tp.promptCache.mu.Unlock()
+
+	logger.Debug("Injected prompt cache breakpoints", "breakpoints", added)
+	return modified, true
+}
+
+// recordPromptCacheUsage adds the cache counters of one response
+func (tp *ThinkingProxy) recordPromptCacheUsage(usage Usage) {
+	if usage.IsZero() || !tp.promptCacheEnabled() {
+		return
+	}
+
+	tp.promptCache.mu.Lock()
+	tp.promptCache.stats.Responses++
+	tp.promptCache.stats.CacheReadTokens += usage.CacheReadInputTokens
+	tp.promptCache.stats.CacheCreationTokens += usage.CacheCreationInputTokens
+	tp.promptCache.stats.UncachedInputTokens += usage.InputTokens
+	tp.promptCache.mu.Unlock()
+
+	logger.Debug("Prompt cache usage", "read_tokens", usage.CacheReadInputTokens,
+		"written_tokens", usage.CacheCreationInputTokens, "uncached_tokens", usage.InputTokens)
+}
+
+// injectPromptCache places ephemeral cache_control breakpoints on the tool
+// list, the system prompt and the latest message, in that order, without
+// exceeding the four breakpoints Anthropic allows. Returns the new body and
+// the number of breakpoints added.
+func injectPromptCache(bodyBytes []byte) ([]byte, int) {
+	var jsonBody map[string]interface{}
+	if err := json.Unmarshal(bodyBytes, &jsonBody); err != nil {
+		return bodyBytes, 0
+	}
+
+	model, _ := jsonBody["model"].(string)
+	if !strings.HasPrefix(model, "claude-") {
+		return bodyBytes, 0
+	}
+
+	available := maxCacheBreakpoints - countCacheBreakpoints(jsonBody)
+	added := 0
+
+	// Tools: the last definition caches the whole list
+	if tools, ok := jsonBody["tools"].([]interface{}); ok && len(tools) > 0 && available > 0 {
+		if markBlock(tools[len(tools)-1]) {
+			added++
+			available--
+		}
+	}
+
+	// System prompt: promote a plain string to a single text block
+	if system, ok := jsonBody["system"].(string); ok && system != "" {
+		jsonBody["system"] = []interface{}{map[string]interface{}{"type": "text", "text": system}}
+	}
+	if blocks, ok := jsonBody["system"].([]interface{}); ok && available > 0 {
+		if markLastBlock(blocks) {
+			added++
+			available--
+		}
+	}
+
+	// Latest message: caches the conversation prefix for the next turn
+	if messages, ok := jsonBody["messages"].([]interface{}); ok && len(messages) > 0 && available > 0 {
+		if message, ok := messages[len(messages)-1].(map[string]interface{}); ok {
+			if text, ok := message["content"].(string); ok && text != "" {
+				message["content"] = []interface{}{map[string]interface{}{"type": "text", "text": text}}
+			}
+			if blocks, ok := message["content"].([]interface{}); ok && markLastBlock(blocks) {
+				added++
+			}
+		}
+	}
+
+	if added == 0 {
+		return bodyBytes, 0
+	}
+
+	modified, err := json.Marshal(jsonBody)
+	if err != nil {
+		return bodyBytes, 0
+	}
+	return modified, added
+}
+
+// countCacheBreakpoints counts cache_control markers the client already set
+func countCacheBreakpoints(jsonBody map[string]interface{}) int {
+	count := 0
+	countIn := func(items []interface{}) {
+		for _, item := range items {
+			if block, ok := item.(map[string]interface{}); ok && block["cache_control"] != nil {
+				count++
+			}
+		}
+	}
+
+	if tools, ok := jsonBody["tools"].([]interface{}); ok {
+		countIn(tools)
+	}
+	if system, ok := jsonBody["system"].([]interface{}); ok {
+		countIn(system)
+	}
+	if messages, ok := jsonBody["messages"].([]interface{}); ok {
+		for _, m := range messages {
+			if message, ok := m.(map[string]interface{}); ok {
+				if blocks, ok := message["content"].([]interface{}); ok {
+					countIn(blocks)
+				}
+			}
+		}
+	}
+	return count
+}
+
+// markLastBlock marks the last content block that may carry cache_control
+func markLastBlock(blocks []interface{}) bool {
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block, ok := blocks[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// Thinking blocks cannot be cached directly
+		if blockType, _ := block["type"].(string); blockType == "thinking" || blockType == "redacted_thinking" {
+			continue
+		}
+		return markBlock(block)
+	}
+	return false
+}
+
+// markBlock adds an ephemeral cache_control unless one is already present
+func markBlock(item interface{}) bool {
+	block, ok := item.(map[string]interface{})
+	if !ok || block["cache_control"] != nil {
+		return false
+	}
+	block["cache_control"] = map[string]interface{}{"type": "ephemeral"}
+	return true
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/auth"
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+// providerPrefixes maps model name prefixes to the provider that serves them
+var providerPrefixes = []struct {
+	prefix   string
+	provider string
+}{
+	{"claude-", "claude"},
+	{"gpt-", "codex"},
+	{"codex-", "codex"},
+	{"o1", "codex"},
+	{"o3", "codex"},
+	{"o4", "codex"},
+	{"gemini-", "gemini"},
+	{"qwen", "qwen"},
+}
+
+// providerNames are the providers' names in error messages
+var providerNames = map[string]string{
+	"claude": "Claude",
+	"codex":  "Codex",
+	"gemini": "Gemini",
+	"qwen":   "Qwen",
+}
+
+// providerForModel returns the provider serving model, or "" if unknown
+func providerForModel(model string) string {
+	model = strings.ToLower(model)
+	for _, p := range providerPrefixes {
+		if strings.HasPrefix(model, p.prefix) {
+			return p.provider
+		}
+	}
+	return ""
+}
+
+// SetProviders replaces the model to provider mapping and the provider check
+func (tp *ThinkingProxy) SetProviders(cfg config.ProvidersConfig) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.providers = cfg
+}
+
+// SetAuth lets the proxy reject requests for providers without a usable
+// account in accounts. loginHint tells the user how to log in, e.g. "open
+// http://localhost:8319/static/"; <provider> in it is replaced.
+func (tp *ThinkingProxy) SetAuth(accounts *auth.Manager, loginHint string) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.accounts = accounts
+	tp.loginHint = loginHint
+}
+
+// providerFor returns the provider serving model, using the configured
+// mapping before the built-in prefixes
+func (tp *ThinkingProxy) providerFor(model string) string {
+	tp.mu.RLock()
+	mappings := tp.providers.Models
+	tp.mu.RUnlock()
+
+	for _, mapping := range mappings {
+		if globMatch(mapping.Model, model) {
+			if mapping.Provider == "none" {
+				return ""
+			}
+			return mapping.Provider
+		}
+	}
+	return providerForModel(model)
+}
+
+// checkProvider returns an error if no account can serve a request for
+// provider: the hinted account when it is one of the provider's, or else
+// any of the provider's, as CLIProxyAPI picks one itself when the hint does
+// not apply. With providers.expired set to "fail", an account whose token
+// has expired and cannot be refreshed does not count.
+func (tp *ThinkingProxy) checkProvider(provider, account string) *proxyError {
+	tp.mu.RLock()
+	cfg, accounts, hint := tp.providers, tp.accounts, tp.loginHint
+	tp.mu.RUnlock()
+	if !cfg.CheckAuth || accounts == nil || provider == "" {
+		return nil
+	}
+
+	var candidates []auth.Account
+	if hinted, ok := accounts.FindAccount(account); ok && account != "" && hinted.Type == provider {
+		candidates = append(candidates, hinted)
+	} else {
+		for _, a := range accounts.Accounts() {
+			if a.Type == provider {
+				candidates = append(candidates, a)
+			}
+		}
+	}
+
+	name := providerNames[provider]
+	hint = strings.ReplaceAll(hint, "<provider>", provider)
+	if len(candidates) == 0 {
+		return &proxyError{
+			status:  http.StatusServiceUnavailable,
+			code:    errProviderNotConnected,
+			message: fmt.Sprintf("%s is not connected; %s to log in", name, hint),
+		}
+	}
+	if cfg.Expired != "fail" {
+		return nil
+	}
+	now := time.Now()
+	for _, a := range candidates {
+		if a.Expired.IsZero() || a.Expired.After(now) || a.Refreshable {
+			return nil
+		}
+	}
+	if len(candidates) == 1 && candidates[0].Matches(account) {
+		name = fmt.Sprintf("%s account %s", name, account)
+	}
+	return &proxyError{
+		status:  http.StatusServiceUnavailable,
+		code:    errProviderTokenExpired,
+		message: fmt.Sprintf("%s login has expired; %s to log in again", name, hint),
+	}
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/automazeio/vibeproxy/internal/auth"
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+// proxyWithAccounts returns a proxy checking providers against auth files
+// written to a custom auth-dir
+func proxyWithAccounts(t *testing.T, expired string, files map[string]string) *ThinkingProxy {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "auths")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	accounts := auth.NewManager(dir)
+	if err := accounts.CheckAuthStatus(); err != nil {
+		t.Fatal(err)
+	}
+	tp := NewThinkingProxy(0, 0)
+	tp.SetProviders(config.ProvidersConfig{CheckAuth: true, Expired: expired})
+	tp.SetAuth(accounts, "open http://localhost:8319")
+	return tp
+}
+
+func TestCheckProvider(t *testing.T) {
+	files := map[string]string{
+		"claude-work":  `{"type":"claude","email":"work@example.com","expired":"2099-01-01T00:00:00Z"}`,
+		"claude-old":   `{"type":"claude","email":"old@example.com","expired":"2020-01-01T00:00:00Z"}`,
+		"codex-renew":  `{"type":"codex","email":"me@example.com","expired":"2020-01-01T00:00:00Z","refresh_token":"r"}`,
+		"gemini-stale": `{"type":"gemini","email":"g@example.com","expired":"2020-01-01T00:00:00Z"}`,
+	}
+
+	for _, tt := range []struct {
+		name     string
+		expired  string
+		provider string
+		account  string
+		want     errorCode // empty when the request is forwarded
+	}{
+		{"connected", "fail", "claude", "", ""},
+		{"not connected", "fail", "qwen", "", errProviderNotConnected},
+		{"expired forwarded by default", "forward", "gemini", "", ""},
+		{"expired without refresh token", "fail", "gemini", "", errProviderTokenExpired},
+		{"expired with refresh token", "fail", "codex", "", ""},
+		{"hinted account expired", "fail", "claude", "old@example.com", errProviderTokenExpired},
+		{"hinted account valid", "fail", "claude", "claude-work", ""},
+		{"hinted account of another provider", "fail", "claude", "codex-renew", ""},
+		{"hinted account missing", "fail", "claude", "nobody@example.com", ""},
+	} {
+		tp := proxyWithAccounts(t, tt.expired, files)
+		err := tp.checkProvider(tt.provider, tt.account)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: rejected with %s: %s", tt.name, err.code, err.message)
+		case tt.want != "" && err == nil:
+			t.Errorf("%s: forwarded, want %s", tt.name, tt.want)
+		case tt.want != "" && (err.code != tt.want || err.status != http.StatusServiceUnavailable):
+			t.Errorf("%s: got %d %s, want 503 %s", tt.name, err.status, err.code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+const (
+	// maxRecordedBody bounds the response body kept in one recording
+	maxRecordedBody = 32 << 20
+
+	// replayHeader marks responses served from a recording
+	replayHeader = "X-VibeProxy-Replay"
+)
+
+// redactedHeaders never reach the recording store
+var redactedHeaders = []string{"Authorization", "X-Api-Key", "Cookie", "Proxy-Authorization"}
+
+// Recording is one captured exchange as stored on disk
+type Recording struct {
+	ID          string           `json:"id"`
+	Key         string           `json:"key"`
+	Time        time.Time        `json:"time"`
+	DurationMs  int64            `json:"durationMs"`
+	Request     RecordedRequest  `json:"request"`
+	Transformed RecordedRequest  `json:"transformed"`
+	Response    RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request as received from the client or as forwarded
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body"`
+}
+
+// RecordedResponse is the upstream response; SSE bodies are kept as events
+type RecordedResponse struct {
+	Status int             `json:"status"`
+	Header http.Header     `json:"header"`
+	Body   string          `json:"body,omitempty"`
+	Events []RecordedEvent `json:"events,omitempty"`
+}
+
+// RecordedEvent is one SSE event with its offset from the response head
+type RecordedEvent struct {
+	OffsetMs int64  `json:"offsetMs"`
+	Data     string `json:"data"`
+}
+
+// recordingObserver captures the response of one exchange as it streams
+type recordingObserver struct {
+	headAt   time.Time
+	response RecordedResponse
+	sse      bool
+	pending  []byte
+	body     bytes.Buffer
+	size     int
+	overflow bool // the body exceeded maxRecordedBody and was cut short
+}
+
+// observeHeader records the status and headers
+func (r *recordingObserver) observeHeader(resp *http.Response) {
+	r.headAt = time.Now()
+	r.response.Status = resp.StatusCode
+	r.response.Header = resp.Header.Clone()
+	for _, name := range uncachedResponseHeaders {
+		r.response.Header.Del(name)
+	}
+	r.sse = strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+}
+
+// Write splits SSE streams into timed events and buffers other bodies
+func (r *recordingObserver) Write(p []byte) (int, error) {
+	if r.overflow || r.size+len(p) > maxRecordedBody {
+		r.overflow = true
+		return len(p), nil
+	}
+	r.size += len(p)
+
+	if !r.sse {
+		r.body.Write(p)
+		return len(p), nil
+	}
+
+	r.pending = append(r.pending, p...)
+	for {
+		idx := bytes.Index(r.pending, []byte("\n\n"))
+		if idx < 0 {
+			break
+		}
+		r.response.Events = append(r.response.Events, RecordedEvent{
+			OffsetMs: time.Since(r.headAt).Milliseconds(),
+			Data:     string(r.pending[:idx+2]),
+		})
+		r.pending = r.pending[idx+2:]
+	}
+	return len(p), nil
+}
+
+// finish returns the captured response
+func (r *recordingObserver) finish() RecordedResponse {
+	if r.sse && len(r.pending) > 0 {
+		r.response.Events = append(r.response.Events, RecordedEvent{
+			OffsetMs: time.Since(r.headAt).Milliseconds(),
+			Data:     string(r.pending),
+		})
+		r.pending = nil
+	}
+	if !r.sse {
+		r.response.Body = r.body.String()
+	}
+	return r.response
+}
+
+// recordingStore keeps recordings as JSON files in a rotating directory
+type recordingStore struct {
+	mu     sync.Mutex
+	config config.RecordingConfig
+	index  map[string]string // key → newest file
+}
+
+// newRecordingStore opens the recording directory and indexes its files
+func newRecordingStore(cfg config.RecordingConfig) (*recordingStore, error) {
+	store := &recordingStore{config: cfg, index: make(map[string]string)}
+	if cfg.Mode == "" {
+		return store, nil
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+
+	files, err := store.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var rec struct {
+			Key string `json:"key"`
+		}
+		if json.Unmarshal(data, &rec) == nil && rec.Key != "" {
+			store.index[rec.Key] = file
+		}
+	}
+
+	logger.Info("Recording enabled", "mode", cfg.Mode, "dir", cfg.Dir, "recordings", len(store.index))
+	return store, nil
+}
+
+// files returns the recording files, oldest first
+func (s *recordingStore) files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.config.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// save writes a recording and rotates out the oldest files
+func (s *recordingStore) save(rec *Recording) {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		logger.Error("Failed to encode recording", "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file := filepath.Join(s.config.Dir, rec.ID+".json")
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		logger.Error("Failed to write recording", "error", err)
+		return
+	}
+	s.index[rec.Key] = file
+	s.rotate()
+}
+
+// rotate deletes the oldest recordings beyond the file count and size limits.
+// Callers must hold s.mu.
+func (s *recordingStore) rotate() {
+	files, err := s.files()
+	if err != nil {
+		return
+	}
+
+	var total int64
+	sizes := make([]int64, len(files))
+	for i, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+
+	for i := 0; i < len(files) && (len(files)-i > s.config.MaxFiles || total > s.config.MaxBytes()); i++ {
+		if err := os.Remove(files[i]); err != nil {
+			continue
+		}
+		total -= sizes[i]
+		for key, indexed := range s.index {
+			if indexed == files[i] {
+				delete(s.index, key)
+			}
+		}
+	}
+}
+
+// lookup loads the newest recording stored under key
+func (s *recordingStore) lookup(key string) (*Recording, bool) {
+	s.mu.Lock()
+	file, ok := s.index[key]
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, false
+	}
+	var rec Recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		logger.Warn("Corrupt recording", "file", file, "error", err)
+		return nil, false
+	}
+	return &rec, true
+}
+
+// SetRecording switches capture/replay mode
+func (tp *ThinkingProxy) SetRecording(cfg config.RecordingConfig) error {
+	store, err := newRecordingStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.recordings = store
+	return nil
+}
+
+// recordingMode returns the store and its mode ("", "capture" or "replay")
+func (tp *ThinkingProxy) recordingMode() (*recordingStore, string) {
+	tp.mu.RLock()
+	defer tp.mu.RUnlock()
+	return tp.recordings, tp.recordings.config.Mode
+}
+
+// newRecording builds the stored form of an exchange
+func newRecording(key string, started time.Time, original []byte, ex *exchange, response RecordedResponse) *Recording {
+	forwarded := ex.req.Header.Clone()
+	forwarded.Set("Content-Length", fmt.Sprint(len(ex.body)))
+	if ex.account != "" {
+		forwarded.Set(accountHeader, ex.account)
+	}
+
+	return &Recording{
+		ID:         fmt.Sprintf("%s-%s", started.UTC().Format("20060102T150405.000000000"), key[:12]),
+		Key:        key,
+		Time:       started,
+		DurationMs: time.Since(started).Milliseconds(),
+		Request: RecordedRequest{
+			Method: ex.req.Method,
+			Path:   ex.req.URL.RequestURI(),
+			Header: redactHeaders(ex.req.Header),
+			Body:   string(original),
+		},
+		Transformed: RecordedRequest{
+			Method: ex.req.Method,
+			Path:   ex.req.URL.RequestURI(),
+			Header: redactHeaders(forwarded),
+			Body:   string(ex.body),
+		},
+		Response: response,
+	}
+}
+
+// redactHeaders copies header without credentials
+func redactHeaders(header http.Header) http.Header {
+	clean := header.Clone()
+	for _, name := range redactedHeaders {
+		if clean.Get(name) != "" {
+			clean.Set(name, "[redacted]")
+		}
+	}
+	return clean
+}
+
+// replayRecording writes a recorded response to the client, re-creating the
+// original event timing when timed is set
+func replayRecording(conn io.Writer, rec *Recording, extraHeaders http.Header, timed bool) error {
+	resp := rec.Response
+	var head bytes.Buffer
+	head.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", resp.Status, http.StatusText(resp.Status)))
+	for _, headers := range []http.Header{resp.Header, extraHeaders} {
+		for name, values := range headers {
+			for _, value := range values {
+				head.WriteString(fmt.Sprintf("%s: %s\r\n", name, value))
+			}
+		}
+	}
+	if len(resp.Events) == 0 {
+		head.WriteString(fmt.Sprintf("Content-Length: %d\r\n", len(resp.Body)))
+	}
+	head.WriteString("Connection: close\r\n\r\n")
+
+	if _, err := conn.Write(head.Bytes()); err != nil {
+		return err
+	}
+	if len(resp.Events) == 0 {
+		_, err := io.WriteString(conn, resp.Body)
+		return err
+	}
+
+	// Without a Content-Length the stream ends when the connection closes
+	start := time.Now()
+	for _, event := range resp.Events {
+		if timed {
+			if wait := time.Duration(event.OffsetMs)*time.Millisecond - time.Since(start); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		if _, err := io.WriteString(conn, event.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+// eventGap is the pause between the fake backend's two SSE events
+const eventGap = 200 * time.Millisecond
+
+// sseBackend streams two SSE events eventGap apart, counting requests and
+// keeping the body of the last one
+func sseBackend(t *testing.T) (port int, requests *atomic.Int32, lastBody *atomic.Value) {
+	t.Helper()
+	requests, lastBody = &atomic.Int32{}, &atomic.Value{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		lastBody.Store(string(body))
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Request-Id", "req_123")
+		io.WriteString(w, "event: message_start\ndata: {\"type\":\"message_start\"}\n\n")
+		w.(http.Flusher).Flush()
+		time.Sleep(eventGap)
+		io.WriteString(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	}))
+	t.Cleanup(backend.Close)
+
+	u, _ := url.Parse(backend.URL)
+	port, _ = strconv.Atoi(u.Port())
+	return port, requests, lastBody
+}
+
+// streamMessage sends body through tp and returns the response with the
+// time each SSE event arrived after the response head
+func streamMessage(t *testing.T, tp *ThinkingProxy, body string) (*http.Response, []string, []time.Duration) {
+	t.Helper()
+	conn := serveProxy(t, tp)
+	fmt.Fprintf(conn, "POST /v1/messages HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/json\r\n"+
+		"Anthropic-Version: 2023-06-01\r\nX-Api-Key: sk-client-secret\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	defer resp.Body.Close()
+
+	start := time.Now()
+	var events []string
+	var offsets []time.Duration
+	reader := bufio.NewReader(resp.Body)
+	var event strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		event.WriteString(line)
+		if line == "\n" {
+			events = append(events, event.String())
+			offsets = append(offsets, time.Since(start))
+			event.Reset()
+		}
+		if err != nil {
+			break
+		}
+	}
+	return resp, events, offsets
+}
+
+// waitForRecording returns the single recording saved in dir
+func waitForRecording(t *testing.T, dir string) *Recording {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		if len(files) == 1 {
+			data, err := os.ReadFile(files[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rec Recording
+			if err := json.Unmarshal(data, &rec); err != nil {
+				t.Fatal(err)
+			}
+			return &rec
+		}
+		if len(files) > 1 || time.Now().After(deadline) {
+			t.Fatalf("%d recordings saved, want 1", len(files))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRecordingCaptureReplay(t *testing.T) {
+	const body = `{"model":"claude-sonnet-4-5-thinking-4000","max_tokens":1000,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	dir := t.TempDir()
+	port, requests, lastBody := sseBackend(t)
+
+	// Capture the exchange through the backend
+	capturing := NewThinkingProxy(0, port)
+	if err := capturing.SetRecording(config.RecordingConfig{Mode: "capture", Dir: dir, MaxFiles: 10, MaxSizeMB: 10}); err != nil {
+		t.Fatal(err)
+	}
+	resp, captured, _ := streamMessage(t, capturing, body)
+	if resp.StatusCode != http.StatusOK || len(captured) != 2 {
+		t.Fatalf("capture: %d with %d events, want 200 with 2", resp.StatusCode, len(captured))
+	}
+	rec := waitForRecording(t, dir)
+
+	if rec.Request.Method != http.MethodPost || rec.Request.Path != "/v1/messages" || rec.Request.Body != body {
+		t.Errorf("original request = %+v, want the client's", rec.Request)
+	}
+	var transformed struct {
+		Model    string `json:"model"`
+		Thinking struct {
+			BudgetTokens int `json:"budget_tokens"`
+		} `json:"thinking"`
+	}
+	if err := json.Unmarshal([]byte(rec.Transformed.Body), &transformed); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Transformed.Body != lastBody.Load() || transformed.Model != "claude-sonnet-4-5" || transformed.Thinking.BudgetTokens != 4000 {
+		t.Errorf("transformed body = %s, want what the backend got with the thinking budget applied", rec.Transformed.Body)
+	}
+	if got := rec.Transformed.Header.Get("Content-Length"); got != strconv.Itoa(len(rec.Transformed.Body)) {
+		t.Errorf("transformed Content-Length %s, want %d", got, len(rec.Transformed.Body))
+	}
+	for _, header := range []http.Header{rec.Request.Header, rec.Transformed.Header} {
+		if header.Get("X-Api-Key") != "[redacted]" || header.Get("Anthropic-Version") != "2023-06-01" {
+			t.Errorf("recorded headers = %v, want the API key redacted and the rest kept", header)
+		}
+	}
+	if data, _ := json.Marshal(rec); strings.Contains(string(data), "sk-client-secret") {
+		t.Error("recording contains the client's API key")
+	}
+
+	response := rec.Response
+	if response.Status != http.StatusOK || response.Header.Get("Request-Id") != "req_123" || len(response.Events) != 2 {
+		t.Fatalf("recorded response = %+v, want 200 with 2 events", response)
+	}
+	for i, event := range response.Events {
+		if event.Data != captured[i] {
+			t.Errorf("recorded event %d = %q, want %q", i, event.Data, captured[i])
+		}
+	}
+	if gap := response.Events[1].OffsetMs - response.Events[0].OffsetMs; gap < eventGap.Milliseconds()-20 {
+		t.Errorf("recorded events %dms apart, want about %v", gap, eventGap)
+	}
+
+	// Replay it with the backend gone
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	replaying := NewThinkingProxy(0, closedPort)
+	if err := replaying.SetRecording(config.RecordingConfig{Mode: "replay", Dir: dir, MaxFiles: 10, MaxSizeMB: 10, ReplayTiming: true}); err != nil {
+		t.Fatal(err)
+	}
+	resp, replayed, offsets := streamMessage(t, replaying, body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(replayHeader) != rec.ID || resp.Header.Get("Request-Id") != "req_123" {
+		t.Fatalf("replay: %d with headers %v, want 200 from recording %s", resp.StatusCode, resp.Header, rec.ID)
+	}
+	if strings.Join(replayed, "") != strings.Join(captured, "") {
+		t.Errorf("replayed %q, want %q", replayed, captured)
+	}
+	if len(offsets) == 2 && offsets[1]-offsets[0] < eventGap-20*time.Millisecond {
+		t.Errorf("replayed events %v apart, want about %v", offsets[1]-offsets[0], eventGap)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("backend got %d requests, want only the captured one", requests.Load())
+	}
+
+	// A request that was never captured is not forwarded
+	resp, _, _ = streamMessage(t, replaying, strings.Replace(body, "hi", "hello", 1))
+	if resp.StatusCode != http.StatusNotFound || resp.Header.Get(errorHeader) != string(errNoRecording) {
+		t.Errorf("unrecorded request: %d %s, want 404 %s", resp.StatusCode, resp.Header.Get(errorHeader), errNoRecording)
+	}
+}
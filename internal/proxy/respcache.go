@@ -0,0 +1,277 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+const (
+	// cacheHeader reports HIT/MISS/BYPASS to the client; a request carrying
+	// "bypass" or "no-cache" skips the cache
+	cacheHeader = "X-VibeProxy-Cache"
+)
+
+// cacheKeyHeaders are the request headers that change the upstream response
+var cacheKeyHeaders = []string{"Anthropic-Version", "Anthropic-Beta", "Openai-Beta"}
+
+// uncachedResponseHeaders are dropped from stored responses; the replayed
+// body is sent decoded with its own framing
+var uncachedResponseHeaders = []string{"Connection", "Content-Length", "Content-Encoding", "Date", "Keep-Alive", "Transfer-Encoding"}
+
+// ResponseCacheStats summarizes the local response cache
+type ResponseCacheStats struct {
+	Enabled   bool  `json:"enabled"`
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+	Hits      int   `json:"hits"`
+	Misses    int   `json:"misses"`
+	Bypassed  int   `json:"bypassed"`
+	Stored    int   `json:"stored"`
+	Evictions int   `json:"evictions"`
+}
+
+// cachedResponse is a stored upstream response, JSON or SSE
+type cachedResponse struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// responseCache is an LRU cache of responses to deterministic requests
+type responseCache struct {
+	mu      sync.Mutex
+	config  config.ResponseCacheConfig
+	entries map[string]*list.Element
+	lru     *list.List
+	bytes   int64
+	stats   ResponseCacheStats
+	now     func() time.Time // time.Now, replaced in tests
+}
+
+// newResponseCache creates a cache for cfg
+func newResponseCache(cfg config.ResponseCacheConfig) *responseCache {
+	return &responseCache{
+		config:  cfg,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		stats:   ResponseCacheStats{Enabled: cfg.Enabled},
+		now:     time.Now,
+	}
+}
+
+// cacheable reports whether the response to this request may be cached
+func (c *responseCache) cacheable(req *http.Request, body []byte) bool {
+	if !c.config.Enabled || req.Method != http.MethodPost || len(body) == 0 {
+		return false
+	}
+	if c.config.AnyTemperature {
+		return true
+	}
+
+	var params struct {
+		Temperature *float64 `json:"temperature"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return false
+	}
+	return params.Temperature != nil && *params.Temperature == 0
+}
+
+// bypassed reports whether the client asked to skip the cache
+func (c *responseCache) bypassed(req *http.Request) bool {
+	value := strings.ToLower(req.Header.Get(cacheHeader))
+	if value == "bypass" || value == "no-cache" {
+		c.mu.Lock()
+		c.stats.Bypassed++
+		c.mu.Unlock()
+		return true
+	}
+	return false
+}
+
+// cacheKey hashes the request line, the relevant headers, the hinted
+// account, the caller (team user or API key) and the normalized JSON body
+func cacheKey(req *http.Request, body []byte, account, caller string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n%s\n%s\n", req.Method, req.URL.RequestURI(), account, caller)
+	for _, name := range cacheKeyHeaders {
+		fmt.Fprintf(h, "%s: %s\n", name, req.Header.Get(name))
+	}
+
+	// Re-encoding sorts object keys so formatting differences hash alike
+	var parsed interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&parsed); err == nil {
+		if normalized, err := json.Marshal(parsed); err == nil {
+			body = normalized
+		}
+	}
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// get returns the unexpired response stored under key
+func (c *responseCache) get(key string) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	entry := elem.Value.(*cachedResponse)
+	if c.now().After(entry.expires) {
+		c.removeElement(elem)
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	c.stats.Hits++
+	return entry, true
+}
+
+// put stores a response, evicting the least recently used entries to stay
+// within the configured limits
+func (c *responseCache) put(entry *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if int64(len(entry.body)) > c.config.MaxEntryBytes() {
+		return
+	}
+	if elem, ok := c.entries[entry.key]; ok {
+		c.removeElement(elem)
+	}
+
+	entry.expires = c.now().Add(c.config.TTL)
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.bytes += int64(len(entry.body))
+	c.stats.Stored++
+
+	for c.lru.Len() > c.config.MaxEntries || c.bytes > c.config.MaxBytes() {
+		c.removeElement(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// removeElement drops one entry. Callers must hold c.mu.
+func (c *responseCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*cachedResponse)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.bytes -= int64(len(entry.body))
+}
+
+// clear drops every entry
+func (c *responseCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.bytes = 0
+}
+
+// status returns the cache counters
+func (c *responseCache) status() ResponseCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.Bytes = c.bytes
+	return stats
+}
+
+// cacheRecorder captures a response for the cache as it streams
+type cacheRecorder struct {
+	limit    int64
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+// observeHeader records the status and headers
+func (r *cacheRecorder) observeHeader(resp *http.Response) {
+	r.status = resp.StatusCode
+	r.header = resp.Header.Clone()
+	for _, name := range uncachedResponseHeaders {
+		r.header.Del(name)
+	}
+}
+
+// Write records body bytes until the entry size limit is exceeded
+func (r *cacheRecorder) Write(p []byte) (int, error) {
+	if !r.overflow {
+		if int64(r.body.Len()+len(p)) > r.limit {
+			r.overflow = true
+			r.body.Reset()
+		} else {
+			r.body.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// SetResponseCache replaces the response cache configuration and drops all entries
+func (tp *ThinkingProxy) SetResponseCache(cfg config.ResponseCacheConfig) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.responseCache = newResponseCache(cfg)
+}
+
+// ResponseCacheStats returns the response cache counters
+func (tp *ThinkingProxy) ResponseCacheStats() ResponseCacheStats {
+	tp.mu.RLock()
+	cache := tp.responseCache
+	tp.mu.RUnlock()
+	return cache.status()
+}
+
+// ClearResponseCache drops every cached response
+func (tp *ThinkingProxy) ClearResponseCache() {
+	tp.mu.RLock()
+	cache := tp.responseCache
+	tp.mu.RUnlock()
+	cache.clear()
+}
+
+// writeCachedResponse replays a stored response to the client
+func writeCachedResponse(conn io.Writer, entry *cachedResponse, extraHeaders http.Header) error {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", entry.status, http.StatusText(entry.status)))
+	for name, values := range entry.header {
+		for _, value := range values {
+			buf.WriteString(fmt.Sprintf("%s: %s\r\n", name, value))
+		}
+	}
+	for name, values := range extraHeaders {
+		for _, value := range values {
+			buf.WriteString(fmt.Sprintf("%s: %s\r\n", name, value))
+		}
+	}
+	buf.WriteString(fmt.Sprintf("Content-Length: %d\r\n", len(entry.body)))
+	buf.WriteString("Connection: close\r\n")
+	buf.WriteString("\r\n")
+	buf.Write(entry.body)
+
+	_, err := conn.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+// cacheRequest builds a Messages API request with body
+func cacheRequest(method, body string) *http.Request {
+	req, _ := http.NewRequest(method, "http://localhost/v1/messages", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Anthropic-Version", "2023-06-01")
+	return req
+}
+
+func TestCacheKey(t *testing.T) {
+	const body = `{"model":"claude-sonnet-4-5","temperature":0,"messages":[{"role":"user","content":"hi"}]}`
+	base := cacheKey(cacheRequest(http.MethodPost, body), []byte(body), "", "")
+
+	for _, tt := range []struct {
+		name    string
+		change  func(req *http.Request) (body, account, caller string)
+		sameKey bool
+	}{
+		{"user agent", func(req *http.Request) (string, string, string) {
+			req.Header.Set("User-Agent", "other-client/2.0")
+			return body, "", ""
+		}, true},
+		{"request id and trace headers", func(req *http.Request) (string, string, string) {
+			req.Header.Set("X-Request-Id", "abc")
+			req.Header.Set("Traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+			return body, "", ""
+		}, true},
+		{"bypass header", func(req *http.Request) (string, string, string) {
+			req.Header.Set(cacheHeader, "bypass")
+			return body, "", ""
+		}, true},
+		{"body key order and spacing", func(*http.Request) (string, string, string) {
+			return `{ "messages": [{"content":"hi","role":"user"}], "temperature": 0, "model": "claude-sonnet-4-5" }`, "", ""
+		}, true},
+		{"anthropic version", func(req *http.Request) (string, string, string) {
+			req.Header.Set("Anthropic-Version", "2024-01-01")
+			return body, "", ""
+		}, false},
+		{"anthropic beta", func(req *http.Request) (string, string, string) {
+			req.Header.Set("Anthropic-Beta", "prompt-caching-2024-07-31")
+			return body, "", ""
+		}, false},
+		{"prompt", func(*http.Request) (string, string, string) {
+			return strings.Replace(body, "hi", "hello", 1), "", ""
+		}, false},
+		{"hinted account", func(*http.Request) (string, string, string) {
+			return body, "work@example.com", ""
+		}, false},
+		{"caller", func(*http.Request) (string, string, string) {
+			return body, "", "alice"
+		}, false},
+	} {
+		req := cacheRequest(http.MethodPost, body)
+		changed, account, caller := tt.change(req)
+		if same := cacheKey(req, []byte(changed), account, caller) == base; same != tt.sameKey {
+			t.Errorf("%s: same key %v, want %v", tt.name, same, tt.sameKey)
+		}
+	}
+}
+
+func TestResponseCacheCacheable(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		cfg       config.ResponseCacheConfig
+		method    string
+		body      string
+		cacheable bool
+	}{
+		{"temperature 0", config.ResponseCacheConfig{Enabled: true}, http.MethodPost, `{"temperature":0}`, true},
+		{"temperature 0.0", config.ResponseCacheConfig{Enabled: true}, http.MethodPost, `{"temperature":0.0}`, true},
+		{"temperature 0.7", config.ResponseCacheConfig{Enabled: true}, http.MethodPost, `{"temperature":0.7}`, false},
+		{"default temperature", config.ResponseCacheConfig{Enabled: true}, http.MethodPost, `{"model":"claude-sonnet-4-5"}`, false},
+		{"invalid JSON", config.ResponseCacheConfig{Enabled: true}, http.MethodPost, `{"temperature":0`, false},
+		{"GET", config.ResponseCacheConfig{Enabled: true}, http.MethodGet, `{"temperature":0}`, false},
+		{"disabled", config.ResponseCacheConfig{}, http.MethodPost, `{"temperature":0}`, false},
+		{"any temperature", config.ResponseCacheConfig{Enabled: true, AnyTemperature: true}, http.MethodPost, `{"temperature":0.7}`, true},
+	} {
+		c := newResponseCache(tt.cfg)
+		if got := c.cacheable(cacheRequest(tt.method, tt.body), []byte(tt.body)); got != tt.cacheable {
+			t.Errorf("%s: cacheable %v, want %v", tt.name, got, tt.cacheable)
+		}
+	}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	clock := newFakeClock()
+	c := newResponseCache(config.ResponseCacheConfig{Enabled: true, TTL: time.Minute, MaxEntries: 10, MaxSizeMB: 1, MaxEntrySizeMB: 1})
+	c.now = clock.Now
+
+	c.put(&cachedResponse{key: "a", status: http.StatusOK, body: []byte("cached")})
+	clock.advance(59 * time.Second)
+	if entry, ok := c.get("a"); !ok || string(entry.body) != "cached" {
+		t.Fatal("entry missing before its TTL")
+	}
+	clock.advance(2 * time.Second)
+	if _, ok := c.get("a"); ok {
+		t.Fatal("entry served after its TTL")
+	}
+	if stats := c.status(); stats.Entries != 0 || stats.Bytes != 0 || stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("stats = %+v, want the expired entry dropped", stats)
+	}
+}
+
+func TestResponseCacheSizeBound(t *testing.T) {
+	large := bytes.Repeat([]byte("x"), 400<<10)
+	for _, tt := range []struct {
+		name    string
+		cfg     config.ResponseCacheConfig
+		puts    []string
+		get     string // read between the puts, making it most recently used
+		body    []byte
+		entries []string
+	}{
+		{
+			name:    "entry count",
+			cfg:     config.ResponseCacheConfig{MaxEntries: 2, MaxSizeMB: 1, MaxEntrySizeMB: 1},
+			puts:    []string{"a", "b", "c"},
+			body:    []byte("small"),
+			entries: []string{"b", "c"},
+		},
+		{
+			name:    "least recently used evicted",
+			cfg:     config.ResponseCacheConfig{MaxEntries: 2, MaxSizeMB: 1, MaxEntrySizeMB: 1},
+			puts:    []string{"a", "b", "c"},
+			get:     "a",
+			body:    []byte("small"),
+			entries: []string{"a", "c"},
+		},
+		{
+			name:    "total bytes",
+			cfg:     config.ResponseCacheConfig{MaxEntries: 10, MaxSizeMB: 1, MaxEntrySizeMB: 1},
+			puts:    []string{"a", "b", "c"},
+			body:    large,
+			entries: []string{"b", "c"},
+		},
+		{
+			name:    "entry over the entry limit",
+			cfg:     config.ResponseCacheConfig{MaxEntries: 10, MaxSizeMB: 4, MaxEntrySizeMB: 1},
+			puts:    []string{"a"},
+			body:    bytes.Repeat([]byte("x"), 2<<20),
+			entries: nil,
+		},
+	} {
+		tt.cfg.Enabled, tt.cfg.TTL = true, time.Hour
+		c := newResponseCache(tt.cfg)
+		for i, key := range tt.puts {
+			if i == len(tt.puts)-1 && tt.get != "" {
+				c.get(tt.get)
+			}
+			c.put(&cachedResponse{key: key, status: http.StatusOK, body: tt.body})
+		}
+
+		stats := c.status()
+		if stats.Entries != len(tt.entries) || stats.Bytes != int64(len(tt.entries)*len(tt.body)) {
+			t.Errorf("%s: %d entries of %d bytes, want %v", tt.name, stats.Entries, stats.Bytes, tt.entries)
+		}
+		for _, key := range tt.entries {
+			if _, ok := c.get(key); !ok {
+				t.Errorf("%s: %s evicted", tt.name, key)
+			}
+		}
+	}
+}
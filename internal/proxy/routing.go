@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+const (
+	// accountHeader carries the hinted account to CLIProxyAPI and back to
+	// the client. It is advisory: it does not pin the account, CLIProxyAPI
+	// keeps choosing one itself unless it reads the header.
+	accountHeader = "X-VibeProxy-Account-Hint"
+)
+
+// SetAccountHints replaces the account hints
+func (tp *ThinkingProxy) SetAccountHints(hints []config.AccountHint) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.accountHints = hints
+}
+
+// accountHint returns the first account hint matching the request
+func (tp *ThinkingProxy) accountHint(req *http.Request, model string) (config.AccountHint, bool) {
+	tp.mu.RLock()
+	rules := tp.accountHints
+	tp.mu.RUnlock()
+
+	key := clientKey(req)
+	for _, rule := range rules {
+		if ruleMatches(rule, req, key, model) {
+			return rule, true
+		}
+	}
+	return config.AccountHint{}, false
+}
+
+// ruleMatches checks every non-empty matcher of rule against the request
+func ruleMatches(rule config.AccountHint, req *http.Request, key, model string) bool {
+	if rule.ClientKey != "" && rule.ClientKey != key {
+		return false
+	}
+	if rule.Model != "" && !globMatch(rule.Model, model) {
+		return false
+	}
+	if rule.Path != "" && !globMatch(rule.Path, req.URL.Path) {
+		return false
+	}
+	for name, value := range rule.Headers {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// globMatch reports whether value matches a shell-style pattern
+func globMatch(pattern, value string) bool {
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
+
+// clientKey extracts the API key the client authenticated with
+func clientKey(req *http.Request) string {
+	if key := req.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// requestModel extracts the model name from a JSON request body
+func requestModel(bodyBytes []byte) string {
+	var body struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(bodyBytes, &body); err != nil {
+		return ""
+	}
+	return body.Model
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// shutdownPollInterval is how often Shutdown checks for drained connections
+const shutdownPollInterval = 100 * time.Millisecond
+
+// trackConn registers a newly accepted, idle connection
+func (tp *ThinkingProxy) trackConn(conn net.Conn) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.conns[conn] = false
+}
+
+// markBusy notes that conn has a request in flight
+func (tp *ThinkingProxy) markBusy(conn net.Conn) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	if _, ok := tp.conns[conn]; ok {
+		tp.conns[conn] = true
+	}
+}
+
+// untrackConn forgets a closed connection
+func (tp *ThinkingProxy) untrackConn(conn net.Conn) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	delete(tp.conns, conn)
+}
+
+// ActiveConnections returns the number of open client connections,
+// including ones still streaming a response
+func (tp *ThinkingProxy) ActiveConnections() int {
+	tp.mu.RLock()
+	defer tp.mu.RUnlock()
+	return len(tp.conns)
+}
+
+// closeConns closes tracked connections, only idle ones unless all is set,
+// and returns how many are left open
+func (tp *ThinkingProxy) closeConns(all bool) int {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	for conn, busy := range tp.conns {
+		if all || !busy {
+			conn.Close()
+			delete(tp.conns, conn)
+		}
+	}
+	return len(tp.conns)
+}
+
+// Shutdown stops accepting connections, closes idle ones and waits for
+// in-flight requests, including streaming responses, to finish. If ctx
+// expires first the remaining connections are closed and ctx.Err() is
+// returned.
+func (tp *ThinkingProxy) Shutdown(ctx context.Context) error {
+	tp.closeListener()
+
+	// Team usage is written in batches; keep what the drained requests add
+	tp.mu.RLock()
+	members := tp.team
+	tp.mu.RUnlock()
+	defer members.flush()
+
+	remaining := tp.closeConns(false)
+	if remaining > 0 {
+		logger.Info("Draining in-flight requests", "connections", remaining)
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for remaining > 0 {
+		select {
+		case <-ctx.Done():
+			logger.Warn("Drain timed out, closing connections", "connections", tp.closeConns(true))
+			return ctx.Err()
+		case <-ticker.C:
+			remaining = tp.closeConns(false)
+		}
+	}
+
+	logger.Info("Stopped")
+	return nil
+}
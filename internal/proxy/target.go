@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SetTargetPort sends new requests to the backend on port; requests already
+// forwarded keep their connection to the previous backend
+func (tp *ThinkingProxy) SetTargetPort(port int) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.targetPort = port
+	logger.Info("Switched backend", "port", port)
+}
+
+// WaitUpstreamIdle waits until no request is connected to the backend on
+// port, returning ctx.Err() if ctx expires first
+func (tp *ThinkingProxy) WaitUpstreamIdle(ctx context.Context, port int) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		tp.mu.RLock()
+		busy := tp.upstreams[port]
+		tp.mu.RUnlock()
+		if busy == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// dialTarget connects to the current backend within timeout (zero for none)
+// and counts the connection against its port until it is closed
+func (tp *ThinkingProxy) dialTarget(timeout time.Duration) (net.Conn, string, error) {
+	tp.mu.Lock()
+	host, port := tp.targetHost, tp.targetPort
+	tp.upstreams[port]++
+	tp.mu.Unlock()
+
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		tp.releaseUpstream(port)
+		return nil, addr, err
+	}
+	return &upstreamConn{Conn: conn, release: func() { tp.releaseUpstream(port) }}, addr, nil
+}
+
+// releaseUpstream forgets a closed backend connection
+func (tp *ThinkingProxy) releaseUpstream(port int) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	if tp.upstreams[port]--; tp.upstreams[port] <= 0 {
+		delete(tp.upstreams, port)
+	}
+}
+
+// upstreamConn is a backend connection that is uncounted once closed
+type upstreamConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *upstreamConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
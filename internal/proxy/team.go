@@ -0,0 +1,331 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+// UserUsage is one team member's usage since local midnight
+type UserUsage struct {
+	Name          string    `json:"name"`
+	Role          string    `json:"role"`
+	Requests      int       `json:"requests"`
+	Rejected      int       `json:"rejected"`
+	InputTokens   int       `json:"inputTokens"`
+	OutputTokens  int       `json:"outputTokens"`
+	DailyRequests int       `json:"dailyRequests,omitempty"`
+	DailyTokens   int       `json:"dailyTokens,omitempty"`
+	Models        []string  `json:"models,omitempty"`
+	LastRequest   time.Time `json:"lastRequest,omitempty"`
+}
+
+// teamSaveDelay batches writes of the usage file
+const teamSaveDelay = 5 * time.Second
+
+// userCounters are the persisted part of UserUsage
+type userCounters struct {
+	Requests     int       `json:"requests"`
+	Rejected     int       `json:"rejected"`
+	InputTokens  int       `json:"inputTokens"`
+	OutputTokens int       `json:"outputTokens"`
+	LastRequest  time.Time `json:"lastRequest"`
+
+	reserved int // estimated tokens of requests in flight
+}
+
+// tokens returns the tokens counted against the daily quota
+func (c *userCounters) tokens() int {
+	return c.InputTokens + c.OutputTokens
+}
+
+// team authenticates team members by key and enforces their daily quotas
+// and model lists. A nil team lets every request through. A team is not
+// changed after creation; updates create a new one sharing the usage.
+type team struct {
+	config     config.TeamConfig
+	backendKey string
+	byKey      map[string]config.TeamUser
+	usage      *teamUsage
+}
+
+// teamUsage holds the members' counters for the day. It outlives team
+// updates, so requests admitted before an update are released and recorded
+// against the counters later requests are admitted on.
+type teamUsage struct {
+	mu     sync.Mutex
+	day    string                   // local date the counters belong to
+	users  map[string]*userCounters // by user name
+	path   string                   // where counters are saved; empty keeps them in memory
+	saving *time.Timer              // pending write of the usage file
+
+	saveMu sync.Mutex // orders writes of the usage file
+}
+
+// newTeam creates the team for cfg, keeping the counters of prev or, on
+// startup, today's counters from the usage file
+func newTeam(cfg config.TeamConfig, backendKey, path string, prev *team) *team {
+	t := &team{
+		config:     cfg,
+		backendKey: backendKey,
+		byKey:      make(map[string]config.TeamUser),
+	}
+	for _, user := range cfg.Users {
+		for _, key := range user.Keys {
+			t.byKey[key] = user
+		}
+	}
+
+	if prev != nil {
+		t.usage = prev.usage
+		t.usage.mu.Lock()
+		t.usage.path = path
+		t.usage.mu.Unlock()
+		return t
+	}
+	t.usage = &teamUsage{
+		day:   time.Now().Format(time.DateOnly),
+		users: make(map[string]*userCounters),
+		path:  path,
+	}
+	if path != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			var saved struct {
+				Day   string                   `json:"day"`
+				Users map[string]*userCounters `json:"users"`
+			}
+			if json.Unmarshal(data, &saved) == nil && saved.Day == t.usage.day && saved.Users != nil {
+				t.usage.users = saved.Users
+			}
+		}
+	}
+	return t
+}
+
+// lookup returns the user a key belongs to
+func (t *team) lookup(key string) (config.TeamUser, bool) {
+	if t == nil || key == "" {
+		return config.TeamUser{}, false
+	}
+	user, ok := t.byKey[key]
+	return user, ok
+}
+
+// admit checks a request's key, model and the user's quotas, and counts
+// the request against them. The estimated tokens are reserved until release,
+// so concurrent requests cannot all pass a nearly used-up token quota.
+func (t *team) admit(key, model string, estimated int) (string, *proxyError) {
+	if t == nil || !t.config.Enabled {
+		return "", nil
+	}
+
+	user, ok := t.byKey[key]
+	if !ok {
+		return "", &proxyError{status: http.StatusUnauthorized, code: errInvalidAPIKey, message: "Invalid API key"}
+	}
+
+	u := t.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := time.Now()
+	u.rollover(now)
+	counters := u.counters(user.Name)
+	counters.LastRequest = now
+	u.scheduleSave()
+
+	if model != "" && len(user.Models) > 0 && !slices.ContainsFunc(user.Models, func(pattern string) bool { return globMatch(pattern, model) }) {
+		counters.Rejected++
+		return user.Name, &proxyError{status: http.StatusForbidden, code: errModelNotAllowed, message: fmt.Sprintf("Model %s is not allowed for %s", model, user.Name)}
+	}
+	untilMidnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location()).Sub(now)
+	if user.DailyRequests > 0 && counters.Requests >= user.DailyRequests {
+		counters.Rejected++
+		return user.Name, &proxyError{status: http.StatusTooManyRequests, code: errQuotaExceeded, message: "Daily request quota exceeded", retryAfter: untilMidnight}
+	}
+	if user.DailyTokens > 0 && counters.tokens()+counters.reserved+max(estimated, 1) > user.DailyTokens {
+		counters.Rejected++
+		return user.Name, &proxyError{status: http.StatusTooManyRequests, code: errQuotaExceeded, message: "Daily token quota exceeded", retryAfter: untilMidnight}
+	}
+	counters.Requests++
+	counters.reserved += estimated
+	return user.Name, nil
+}
+
+// release ends the reservation admit made for a user's request
+func (t *team) release(name string, estimated int) {
+	if t == nil || name == "" {
+		return
+	}
+	u := t.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if counters, ok := u.users[name]; ok {
+		counters.reserved = max(counters.reserved-estimated, 0)
+	}
+}
+
+// record adds the tokens a user's request consumed
+func (t *team) record(name string, usage Usage) {
+	if t == nil || name == "" {
+		return
+	}
+	u := t.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rollover(time.Now())
+	counters := u.counters(name)
+	counters.InputTokens += usage.InputTokens
+	counters.OutputTokens += usage.OutputTokens
+	u.scheduleSave()
+}
+
+// flush writes a pending change to the usage file now
+func (t *team) flush() {
+	if t == nil {
+		return
+	}
+	t.usage.flush()
+}
+
+// scheduleSave writes the usage file after teamSaveDelay unless a write is
+// already pending; callers hold u.mu
+func (u *teamUsage) scheduleSave() {
+	if u.path == "" || u.saving != nil {
+		return
+	}
+	u.saving = time.AfterFunc(teamSaveDelay, u.flush)
+}
+
+// flush writes a pending change to the usage file now
+func (u *teamUsage) flush() {
+	u.mu.Lock()
+	pending := u.saving != nil
+	if pending {
+		u.saving.Stop()
+		u.saving = nil
+	}
+	u.mu.Unlock()
+	if pending {
+		u.save()
+	}
+}
+
+// rollover starts new counters at local midnight; callers hold u.mu
+func (u *teamUsage) rollover(now time.Time) {
+	if day := now.Format(time.DateOnly); day != u.day {
+		u.day = day
+		u.users = make(map[string]*userCounters)
+	}
+}
+
+// counters returns a user's counters; callers hold u.mu
+func (u *teamUsage) counters(name string) *userCounters {
+	counters, ok := u.users[name]
+	if !ok {
+		counters = &userCounters{}
+		u.users[name] = counters
+	}
+	return counters
+}
+
+// save writes today's counters so a restart does not reset the quotas
+func (u *teamUsage) save() {
+	u.saveMu.Lock()
+	defer u.saveMu.Unlock()
+
+	u.mu.Lock()
+	path := u.path
+	data, err := json.Marshal(map[string]interface{}{"day": u.day, "users": u.users})
+	u.mu.Unlock()
+	if path == "" || err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		logger.Warn("Failed to save team usage", "error", err)
+		return
+	}
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		logger.Warn("Failed to save team usage", "error", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		logger.Warn("Failed to save team usage", "error", err)
+	}
+}
+
+// status returns every user's usage today, sorted by name
+func (t *team) status() []UserUsage {
+	if t == nil {
+		return []UserUsage{}
+	}
+	u := t.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rollover(time.Now())
+
+	out := make([]UserUsage, 0, len(t.config.Users))
+	for _, user := range t.config.Users {
+		role := user.Role
+		if role == "" {
+			role = "member"
+		}
+		usage := UserUsage{
+			Name:          user.Name,
+			Role:          role,
+			DailyRequests: user.DailyRequests,
+			DailyTokens:   user.DailyTokens,
+			Models:        user.Models,
+		}
+		if counters, ok := u.users[user.Name]; ok {
+			usage.Requests = counters.Requests
+			usage.Rejected = counters.Rejected
+			usage.InputTokens = counters.InputTokens
+			usage.OutputTokens = counters.OutputTokens
+			usage.LastRequest = counters.LastRequest
+		}
+		out = append(out, usage)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// SetTeam enables or updates team mode. backendKey replaces the members'
+// keys on requests to CLIProxyAPI; usageFile keeps today's counters across
+// restarts. Counters survive updates.
+func (tp *ThinkingProxy) SetTeam(cfg config.TeamConfig, backendKey, usageFile string) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.team = newTeam(cfg, backendKey, usageFile, tp.team)
+}
+
+// TeamEnabled reports whether requests must carry a team member's key
+func (tp *ThinkingProxy) TeamEnabled() bool {
+	tp.mu.RLock()
+	defer tp.mu.RUnlock()
+	return tp.team != nil && tp.team.config.Enabled
+}
+
+// TeamUser returns the team member a key belongs to
+func (tp *ThinkingProxy) TeamUser(key string) (config.TeamUser, bool) {
+	tp.mu.RLock()
+	team := tp.team
+	tp.mu.RUnlock()
+	return team.lookup(key)
+}
+
+// TeamUsage returns each team member's usage today
+func (tp *ThinkingProxy) TeamUsage() []UserUsage {
+	tp.mu.RLock()
+	team := tp.team
+	tp.mu.RUnlock()
+	return team.status()
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+func TestTeamUsageSurvivesUpdate(t *testing.T) {
+	cfg := config.TeamConfig{
+		Enabled: true,
+		Users:   []config.TeamUser{{Name: "bob", Keys: []string{"bob-key"}, DailyTokens: 1000}},
+	}
+	tp := NewThinkingProxy(0, 0)
+	tp.SetTeam(cfg, "", "")
+	before := tp.team
+
+	// A request admitted before the update still holds its reservation
+	if _, err := before.admit("bob-key", "", 600); err != nil {
+		t.Fatalf("first request rejected: %v", err.message)
+	}
+	tp.SetTeam(cfg, "", "")
+	after := tp.team
+	if _, err := after.admit("bob-key", "", 600); err == nil {
+		t.Fatal("second request passed while the first reserved most of the quota")
+	}
+
+	before.record("bob", Usage{InputTokens: 300, OutputTokens: 100})
+	before.release("bob", 600)
+	if _, err := after.admit("bob-key", "", 500); err != nil {
+		t.Fatalf("request within the quota rejected after release: %v", err.message)
+	}
+	after.release("bob", 500)
+
+	usage := tp.TeamUsage()
+	if len(usage) != 1 || usage[0].Requests != 2 || usage[0].Rejected != 1 || usage[0].InputTokens != 300 {
+		t.Fatalf("usage = %+v, want 2 requests, 1 rejected, 300 input tokens", usage)
+	}
+}
+
+func TestTeamConcurrentUpdates(t *testing.T) {
+	cfg := config.TeamConfig{
+		Enabled: true,
+		Users:   []config.TeamUser{{Name: "bob", Keys: []string{"bob-key"}}},
+	}
+	tp := NewThinkingProxy(0, 0)
+	tp.SetTeam(cfg, "", "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				tp.mu.RLock()
+				members := tp.team
+				tp.mu.RUnlock()
+				user, _ := members.admit("bob-key", "", 10)
+				members.record(user, Usage{InputTokens: 1})
+				members.release(user, 10)
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		tp.SetTeam(cfg, "", "")
+	}
+	wg.Wait()
+
+	if usage := tp.TeamUsage(); usage[0].Requests != 800 || usage[0].InputTokens != 800 {
+		t.Fatalf("usage = %+v, want 800 requests and input tokens", usage[0])
+	}
+}
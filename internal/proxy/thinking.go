@@ -3,17 +3,32 @@ package proxy
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/auth"
+	"github.com/automazeio/vibeproxy/internal/config"
+	"github.com/automazeio/vibeproxy/internal/listen"
+	"github.com/automazeio/vibeproxy/internal/logging"
+	"github.com/automazeio/vibeproxy/internal/tracing"
 )
 
+var logger = logging.Component("ThinkingProxy")
+
+// tlsHandshakeTimeout bounds how long a client may take to set up TLS
+const tlsHandshakeTimeout = 10 * time.Second
+
 // ThinkingProxy is a lightweight HTTP proxy that intercepts requests to add
 // extended thinking parameters for Claude models based on model name suffixes.
 //
@@ -24,21 +39,55 @@ import (
 // before forwarding to CLIProxyAPI.
 type ThinkingProxy struct {
 	mu         sync.RWMutex
-	listener   net.Listener
+	listen     config.ListenerConfig
+	tlsConfig  *tls.Config
+	listeners  []net.Listener
 	proxyPort  int
 	targetPort int
 	targetHost string
 	isRunning  bool
 	done       chan struct{}
+	conns      map[net.Conn]bool // open client connections; true once a request was read
+	upstreams  map[int]int       // open backend connections by port
+
+	accountHints  []config.AccountHint
+	providers     config.ProvidersConfig
+	accounts      *auth.Manager // nil skips the provider check
+	loginHint     string
+	limiter       *rateLimiter
+	upstreamRetry config.UpstreamRetryConfig
+	cooldowns     *cooldownTracker
+	promptCache   promptCache
+	responseCache *responseCache
+	recordings    *recordingStore
+	requests      *requestLog
+	metrics       *proxyMetrics
+	tracer        *tracing.Tracer
+	team          *team
+	apiKeys       apiKeys
+	limits        config.LimitsConfig
 }
 
 // NewThinkingProxy creates a new thinking proxy
 func NewThinkingProxy(proxyPort, targetPort int) *ThinkingProxy {
 	return &ThinkingProxy{
-		proxyPort:  proxyPort,
-		targetPort: targetPort,
-		targetHost: "127.0.0.1",
-		done:       make(chan struct{}),
+		proxyPort:     proxyPort,
+		targetPort:    targetPort,
+		targetHost:    "127.0.0.1",
+		listen:        config.DefaultSettings().Listen.Proxy,
+		done:          make(chan struct{}),
+		conns:         make(map[net.Conn]bool),
+		upstreams:     make(map[int]int),
+		limiter:       newRateLimiter(config.RateLimitConfig{}),
+		upstreamRetry: config.DefaultSettings().UpstreamRetry,
+		providers:     config.DefaultSettings().Providers,
+		limits:        config.DefaultSettings().Limits,
+		cooldowns:     newCooldownTracker(),
+		responseCache: newResponseCache(config.ResponseCacheConfig{}),
+		recordings:    &recordingStore{},
+		requests:      newRequestLog(config.DefaultSettings().Inspector),
+		metrics:       newProxyMetrics(),
+		tracer:        tracing.NewTracer("", "", nil, 0),
 	}
 }
 
@@ -47,48 +96,68 @@ func (tp *ThinkingProxy) Start() error {
 	tp.mu.Lock()
 	if tp.isRunning {
 		tp.mu.Unlock()
-		log.Printf("[ThinkingProxy] Already running")
+		logger.Warn("Already running")
 		return nil
 	}
 	tp.mu.Unlock()
 
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", tp.proxyPort))
+	tp.mu.RLock()
+	cfg, tlsConfig := tp.listen, tp.tlsConfig
+	tp.mu.RUnlock()
+	listeners, err := listen.Open(cfg, tp.proxyPort, tlsConfig)
 	if err != nil {
 		return fmt.Errorf("failed to start listener: %w", err)
 	}
 
 	tp.mu.Lock()
-	tp.listener = listener
+	tp.listeners = listeners
 	tp.isRunning = true
 	tp.mu.Unlock()
 
-	log.Printf("[ThinkingProxy] Listening on port %d", tp.proxyPort)
+	logger.Info("Listening", "addresses", listen.Addrs(listeners))
 
-	go tp.acceptConnections()
+	for _, listener := range listeners {
+		go tp.acceptConnections(listener)
+	}
 
 	return nil
 }
 
-// Stop stops the thinking proxy server
+// SetListen sets the TCP port and Unix socket Start listens on
+func (tp *ThinkingProxy) SetListen(cfg config.ListenerConfig) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.listen = cfg
+}
+
+// SetTLS sets the certificate served when the listener has tls enabled
+func (tp *ThinkingProxy) SetTLS(tlsConfig *tls.Config) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.tlsConfig = tlsConfig
+}
+
+// Stop stops the thinking proxy server immediately, closing open
+// connections; use Shutdown to let in-flight requests finish
 func (tp *ThinkingProxy) Stop() error {
+	tp.closeListener()
+	tp.closeConns(true)
+
+	logger.Info("Stopped")
+	return nil
+}
+
+// closeListener stops accepting new connections
+func (tp *ThinkingProxy) closeListener() {
 	tp.mu.Lock()
+	defer tp.mu.Unlock()
 	if !tp.isRunning {
-		tp.mu.Unlock()
-		return nil
+		return
 	}
-	tp.mu.Unlock()
 
 	close(tp.done)
-
-	tp.mu.Lock()
-	if tp.listener != nil {
-		tp.listener.Close()
-	}
+	listen.Close(tp.listeners)
 	tp.isRunning = false
-	tp.mu.Unlock()
-
-	log.Printf("[ThinkingProxy] Stopped")
-	return nil
 }
 
 // IsRunning returns true if the proxy is running
@@ -98,20 +167,21 @@ func (tp *ThinkingProxy) IsRunning() bool {
 	return tp.isRunning
 }
 
-// acceptConnections accepts incoming connections
-func (tp *ThinkingProxy) acceptConnections() {
+// acceptConnections accepts incoming connections on one listener
+func (tp *ThinkingProxy) acceptConnections(listener net.Listener) {
 	for {
-		conn, err := tp.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			select {
 			case <-tp.done:
 				return
 			default:
-				log.Printf("[ThinkingProxy] Accept error: %v", err)
+				logger.Error("Accept error", "error", err)
 				continue
 			}
 		}
 
+		tp.trackConn(conn)
 		go tp.handleConnection(conn)
 	}
 }
@@ -119,24 +189,90 @@ func (tp *ThinkingProxy) acceptConnections() {
 // handleConnection handles a single client connection
 func (tp *ThinkingProxy) handleConnection(clientConn net.Conn) {
 	defer clientConn.Close()
+	defer tp.untrackConn(clientConn)
+
+	// Finish the TLS handshake up front so a failed one is logged rather
+	// than answered with a plain-text error
+	if tlsConn, ok := clientConn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			logger.Debug("TLS handshake failed", "client", clientConn.RemoteAddr().String(), "error", err)
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+	}
+
+	// Bound how long the client may take to send the request head
+	tp.mu.RLock()
+	limits := tp.limits
+	tp.mu.RUnlock()
+	accepted := time.Now()
+	conn := &deadlineConn{Conn: clientConn}
+	conn.limit(0, earliest(after(accepted, limits.Client.Header), after(accepted, limits.Client.Total)))
 
 	// Read the HTTP request
-	reader := bufio.NewReader(clientConn)
+	reader := bufio.NewReader(conn)
 	req, err := http.ReadRequest(reader)
 	if err != nil {
-		tp.sendError(clientConn, http.StatusBadRequest, "Invalid request")
+		if isTimeout(err) {
+			logger.Debug("Timed out reading request", "client", clientConn.RemoteAddr().String())
+			// A client that started a request is told why it was cut off
+			if conn.started() {
+				conn.limit(0, time.Now().Add(errorWriteTimeout))
+				tp.rejectRequest(conn, nil, &proxyError{status: http.StatusRequestTimeout, code: errRequestTimeout, message: "Timed out reading the request head"})
+			}
+			return
+		}
+		tp.rejectRequest(conn, nil, &proxyError{status: http.StatusBadRequest, code: errInvalidRequest, message: "Invalid request"})
 		return
 	}
+	tp.markBusy(clientConn)
+	conn.limit(limits.Client.Idle, after(accepted, limits.Client.Total))
+
+	// Trace the request, continuing the client's trace if it sent one
+	tp.mu.RLock()
+	tracer := tp.tracer
+	tp.mu.RUnlock()
+	parent, _ := tracing.ParseTraceparent(req.Header.Get("Traceparent"))
+	span := tracer.Start(req.Method+" "+req.URL.Path, tracing.KindServer, parent)
+	defer span.End()
+	reqLog := requestLogger(span)
 
 	// Read request body
 	var bodyBytes []byte
+	if req.ContentLength > limits.MaxBodyBytes() {
+		reqLog.Warn("Request body too large", "method", req.Method, "path", req.URL.Path, "size", req.ContentLength)
+		span.SetError("request body too large")
+		tp.rejectRequest(lingerConn{conn}, req, bodyTooLarge(limits.MaxBodyBytes()))
+		return
+	}
 	if req.Body != nil {
-		bodyBytes, err = io.ReadAll(req.Body)
-		req.Body.Close()
+		readSpan := span.StartChild("read_body", tracing.KindInternal)
+		// Not closing the body on error: that would read the rest of it, and
+		// the connection is closed anyway
+		bodyBytes, err = io.ReadAll(http.MaxBytesReader(nil, req.Body, limits.MaxBodyBytes()))
+		readSpan.SetAttribute("http.request.body.size", len(bodyBytes))
 		if err != nil {
-			tp.sendError(clientConn, http.StatusBadRequest, "Failed to read body")
+			readSpan.SetError(err.Error())
+			readSpan.End()
+			var tooLarge *http.MaxBytesError
+			switch {
+			case errors.As(err, &tooLarge):
+				reqLog.Warn("Request body too large", "method", req.Method, "path", req.URL.Path, "limit", tooLarge.Limit)
+				span.SetError("request body too large")
+				tp.rejectRequest(lingerConn{conn}, req, bodyTooLarge(tooLarge.Limit))
+			case isTimeout(err):
+				reqLog.Warn("Timed out reading request body", "method", req.Method, "path", req.URL.Path, "read", len(bodyBytes))
+				span.SetError("request body timeout")
+				tp.rejectRequest(conn, req, &proxyError{status: http.StatusRequestTimeout, code: errRequestTimeout, message: "Timed out reading the request body"})
+			default:
+				span.SetError("failed to read body")
+				tp.rejectRequest(conn, req, &proxyError{status: http.StatusBadRequest, code: errInvalidRequest, message: "Failed to read the request body"})
+			}
 			return
 		}
+		req.Body.Close()
+		readSpan.End()
 	}
 
 	// Process thinking parameter for POST requests with JSON body
@@ -144,57 +280,255 @@ func (tp *ThinkingProxy) handleConnection(clientConn net.Conn) {
 	transformationApplied := false
 
 	if req.Method == "POST" && len(bodyBytes) > 0 {
-		if modified, applied := tp.processThinkingParameter(bodyBytes); modified != nil {
+		transformSpan := span.StartChild("transform", tracing.KindInternal)
+		modified, applied, budgetErr := tp.processThinkingParameter(bodyBytes)
+		if budgetErr != nil {
+			transformSpan.SetError(budgetErr.message)
+			transformSpan.End()
+			span.SetError("invalid thinking budget")
+			reqLog.Warn("Rejected request", "method", req.Method, "path", req.URL.Path, "reason", budgetErr.message)
+			tp.rejectRequest(conn, req, budgetErr)
+			return
+		}
+		if modified != nil {
 			modifiedBody = modified
 			transformationApplied = applied
+			if applied {
+				tp.metrics.transformations.Inc("thinking")
+			}
+		}
+		if modified, applied := tp.applyPromptCache(req.URL.Path, modifiedBody); applied {
+			modifiedBody = modified
+			transformationApplied = true
+			tp.metrics.transformations.Inc("prompt_cache")
+		}
+		transformSpan.SetAttribute("vibeproxy.transformed", transformationApplied)
+		transformSpan.End()
+	}
+
+	model := requestModel(bodyBytes)
+	clientTimeouts, upstreamTimeouts := tp.timeouts(req, model, bodyBytes)
+	conn.limit(clientTimeouts.Idle, after(accepted, clientTimeouts.Total))
+	ctx, stopWatching := tp.clientContext(clientConn, after(accepted, clientTimeouts.Total))
+	defer stopWatching()
+
+	// Select the account that should handle this request
+	account := ""
+	if rule, ok := tp.accountHint(req, model); ok {
+		account = rule.Account
+		reqLog.Info("Hinted account", "method", req.Method, "path", req.URL.Path, "account", account, "rule", rule.Name)
+	}
+
+	ex := &exchange{
+		req:          req,
+		body:         modifiedBody,
+		transformed:  transformationApplied,
+		account:      account,
+		timeouts:     upstreamTimeouts,
+		ctx:          ctx,
+		extraHeaders: http.Header{requestIDHeader: {span.TraceID()}},
+		span:         span,
+		log:          reqLog,
+	}
+
+	// Note the exchange for the request inspector once it is done
+	backendModel := requestModel(modifiedBody)
+	started := time.Now()
+	tp.mu.RLock()
+	requests := tp.requests
+	tp.mu.RUnlock()
+	record := newRequestRecord(req, conn, model, backendModel, modifiedBody, account)
+	timing := &responseTiming{}
+	ex.observers = append(ex.observers, timing)
+	defer func() {
+		latency := time.Since(started)
+		record.LatencyMs = latency.Milliseconds()
+		var ttfb time.Duration
+		if !timing.firstByte.IsZero() {
+			ttfb = timing.firstByte.Sub(started)
+			record.TTFTMs = ttfb.Milliseconds()
 		}
+		tp.metrics.observe(record, latency, ttfb, timing.bytes)
+		annotate(span, record)
+		requests.add(record, bodyBytes, modifiedBody)
+	}()
+
+	// In team mode, check the member's key, models and quotas
+	tp.mu.RLock()
+	members := tp.team
+	tp.mu.RUnlock()
+	estimated := estimateTokens(bodyBytes)
+	user, teamErr := members.admit(clientKey(req), model, estimated)
+	record.User = user
+	if teamErr != nil {
+		reqLog.Warn("Rejected team request", "user", user, "model", model, "reason", teamErr.message)
+		record.Source, record.Status = "rejected", teamErr.status
+		tp.sendError(conn, req, teamErr)
+		return
+	}
+	defer members.release(user, estimated)
+	if user != "" {
+		ex.apiKey = members.backendKey
+	}
+	// Answers that skip CLIProxyAPI go only to callers it would accept
+	caller, trusted := user, user != ""
+	if caller == "" {
+		caller = clientKey(req)
+		trusted = tp.apiKeys.allowed(caller)
 	}
 
+	// Serve or capture recorded exchanges
+	recordings, recordingMode := tp.recordingMode()
+	recordingKey := ""
+	var capture *recordingObserver
+	switch recordingMode {
+	case "replay":
+		if !trusted {
+			reqLog.Warn("Rejected replay for unknown API key", "method", req.Method, "path", req.URL.Path)
+			record.Source, record.Status = "rejected", http.StatusUnauthorized
+			tp.sendError(conn, req, &proxyError{status: http.StatusUnauthorized, code: errInvalidAPIKey, message: "Invalid API key"})
+			return
+		}
+		recordingKey = cacheKey(req, bodyBytes, "", "")
+		if rec, ok := recordings.lookup(recordingKey); ok {
+			reqLog.Info("Replaying recording", "recording", rec.ID, "method", req.Method, "path", req.URL.Path)
+			record.Source, record.Status = "replay", rec.Response.Status
+			ex.extraHeaders.Set(replayHeader, rec.ID)
+			if err := replayRecording(conn, rec, ex.extraHeaders, recordings.config.ReplayTiming); err != nil {
+				reqLog.Warn("Write error", "error", err)
+			}
+			return
+		}
+		if !recordings.config.ReplayFallthrough {
+			reqLog.Warn("No recording", "method", req.Method, "path", req.URL.Path)
+			record.Source, record.Status = "replay", http.StatusNotFound
+			tp.sendError(conn, req, &proxyError{status: http.StatusNotFound, code: errNoRecording, message: "No recorded response"})
+			return
+		}
+	case "capture":
+		recordingKey = cacheKey(req, bodyBytes, "", "")
+		capture = &recordingObserver{}
+		ex.observers = append(ex.observers, capture)
+	}
+
+	// Serve deterministic requests from the local response cache
+	tp.mu.RLock()
+	cache := tp.responseCache
+	tp.mu.RUnlock()
+	var recorder *cacheRecorder
+	key := ""
+	if trusted && cache.cacheable(req, modifiedBody) {
+		if cache.bypassed(req) {
+			ex.extraHeaders.Set(cacheHeader, "BYPASS")
+		} else {
+			key = cacheKey(req, modifiedBody, account, caller)
+			if entry, ok := cache.get(key); ok {
+				reqLog.Info("Response cache hit", "method", req.Method, "path", req.URL.Path, "model", model)
+				record.Source, record.Status = "cache", entry.status
+				ex.extraHeaders.Set(cacheHeader, "HIT")
+				if err := writeCachedResponse(conn, entry, ex.extraHeaders); err != nil {
+					reqLog.Warn("Write error", "error", err)
+				}
+				return
+			}
+			ex.extraHeaders.Set(cacheHeader, "MISS")
+			recorder = &cacheRecorder{limit: cache.config.MaxEntryBytes()}
+			ex.observers = append(ex.observers, recorder)
+		}
+	}
+
+	// Fail fast when no account can serve the model's provider
+	provider := tp.providerFor(model)
+	if providerErr := tp.checkProvider(provider, account); providerErr != nil {
+		reqLog.Warn("Rejected request", "method", req.Method, "path", req.URL.Path, "model", model, "reason", providerErr.message)
+		record.Source, record.Status = "rejected", providerErr.status
+		tp.sendError(conn, req, providerErr)
+		return
+	}
+
+	// Honor upstream cooldowns for the model the backend will see
+	if !tp.waitForCooldown(ctx, backendModel, account, req, conn) {
+		record.Source, record.Status = "rejected", http.StatusTooManyRequests
+		return
+	}
+
+	// Wait for rate limit capacity
+	tp.mu.RLock()
+	limiter := tp.limiter
+	tp.mu.RUnlock()
+	release, retryAfter, ok := limiter.acquire(ctx, clientKey(req), provider, backendModel, estimated)
+	if !ok {
+		reqLog.Warn("Rate limited", "method", req.Method, "path", req.URL.Path, "model", model, "retry_after", retryAfter.Round(time.Second).String())
+		record.Source, record.Status = "rejected", http.StatusTooManyRequests
+		tp.sendError(conn, req, &proxyError{status: http.StatusTooManyRequests, code: errRateLimited, message: "Rate limit exceeded", retryAfter: retryAfter})
+		return
+	}
+	defer release()
+
 	// Forward request to CLIProxyAPI
-	tp.forwardRequest(req, modifiedBody, clientConn, transformationApplied)
+	result := tp.forwardRequest(ex, conn)
+	record.Status, record.Usage = result.status, result.usage
+	tp.recordPromptCacheUsage(result.usage)
+	members.record(user, result.usage)
+
+	if capture != nil && capture.response.Status != 0 {
+		// A partial recording would replay as if it were the whole response
+		if result.complete && !capture.overflow {
+			recordings.save(newRecording(recordingKey, started, bodyBytes, ex, capture.finish()))
+		} else {
+			reqLog.Warn("Not saving incomplete recording", "method", req.Method, "path", req.URL.Path, "truncated", capture.overflow)
+		}
+	}
+
+	if recorder != nil && result.complete && result.status == http.StatusOK && !recorder.overflow {
+		cache.put(&cachedResponse{key: key, status: recorder.status, header: recorder.header, body: recorder.body.Bytes()})
+	}
 }
 
 // processThinkingParameter processes the JSON body to add thinking parameter
-// Returns (modifiedJSON, needsTransformation)
-func (tp *ThinkingProxy) processThinkingParameter(bodyBytes []byte) ([]byte, bool) {
+// Returns (modifiedJSON, needsTransformation), or an error for a thinking
+// suffix without a positive budget
+func (tp *ThinkingProxy) processThinkingParameter(bodyBytes []byte) ([]byte, bool, *proxyError) {
 	var jsonBody map[string]interface{}
 	if err := json.Unmarshal(bodyBytes, &jsonBody); err != nil {
-		return bodyBytes, false
+		return bodyBytes, false, nil
 	}
 
 	model, ok := jsonBody["model"].(string)
 	if !ok || !strings.HasPrefix(model, "claude-") {
-		return bodyBytes, false
+		return bodyBytes, false, nil
 	}
 
 	// Check for thinking suffix pattern: -thinking-NUMBER
 	thinkingPrefix := "-thinking-"
 	idx := strings.LastIndex(model, thinkingPrefix)
 	if idx == -1 {
-		return bodyBytes, false
+		return bodyBytes, false, nil
 	}
 
 	// Extract the budget number after "-thinking-"
 	budgetStr := model[idx+len(thinkingPrefix):]
 	cleanModel := model[:idx]
 
-	// Strip the thinking suffix from model name
-	jsonBody["model"] = cleanModel
-
 	// Only add thinking parameter if it's a valid integer
 	budget, err := strconv.Atoi(budgetStr)
 	if err != nil || budget <= 0 {
-		log.Printf("[ThinkingProxy] Stripped invalid thinking suffix from '%s' → '%s' (no thinking)", model, cleanModel)
-		modified, _ := json.Marshal(jsonBody)
-		return modified, true
+		return nil, false, &proxyError{
+			status:  http.StatusBadRequest,
+			code:    errInvalidThinkingBudget,
+			message: fmt.Sprintf("Invalid thinking budget %q in model %s; use a positive number of tokens", budgetStr, model),
+		}
 	}
 
+	// Strip the thinking suffix from model name
+	jsonBody["model"] = cleanModel
+
 	// Apply hard cap
 	const hardCap = 32000
 	effectiveBudget := budget
 	if effectiveBudget >= hardCap {
 		effectiveBudget = hardCap - 1
-		log.Printf("[ThinkingProxy] Adjusted thinking budget from %d to %d to stay within limits", budget, effectiveBudget)
+		logger.Info("Adjusted thinking budget to stay within limits", "requested", budget, "budget", effectiveBudget)
 	}
 
 	// Add thinking parameter
@@ -247,26 +581,148 @@ func (tp *ThinkingProxy) processThinkingParameter(bodyBytes []byte) ([]byte, boo
 		}
 	}
 
-	log.Printf("[ThinkingProxy] Transformed model '%s' → '%s' with thinking budget %d", model, cleanModel, effectiveBudget)
+	logger.Info("Transformed model", "model", model, "backend_model", cleanModel, "thinking_budget", effectiveBudget)
 
 	modified, err := json.Marshal(jsonBody)
 	if err != nil {
-		return bodyBytes, false
+		return bodyBytes, false, nil
 	}
 
-	return modified, true
+	return modified, true, nil
 }
 
-// forwardRequest forwards the request to CLIProxyAPI
-func (tp *ThinkingProxy) forwardRequest(req *http.Request, body []byte, clientConn net.Conn, forceConnectionClose bool) {
-	// Connect to CLIProxyAPI
-	targetConn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", tp.targetHost, tp.targetPort))
+// exchange is one client request on its way through the proxy
+type exchange struct {
+	req          *http.Request
+	body         []byte // body as forwarded to CLIProxyAPI
+	transformed  bool   // a transformation rewrote the body
+	account      string
+	apiKey       string          // replaces the client's key in team mode
+	timeouts     config.Timeouts // upstream timeouts for this request
+	ctx          context.Context // ends when the client or proxy gives up
+	extraHeaders http.Header     // added to the response sent to the client
+	observers    []responseObserver
+	span         *tracing.Span
+	log          *slog.Logger // tagged with the trace ID
+}
+
+// upstreamResult summarizes the response relayed for an exchange
+type upstreamResult struct {
+	status   int
+	usage    Usage
+	complete bool // the whole response body reached the client
+}
+
+// forwardRequest forwards the request to CLIProxyAPI, retrying rate-limited
+// attempts before anything has been streamed to the client
+func (tp *ThinkingProxy) forwardRequest(ex *exchange, clientConn net.Conn) upstreamResult {
+	if ex.account != "" {
+		ex.extraHeaders.Set(accountHeader, ex.account)
+	}
+
+	tp.mu.RLock()
+	retry := tp.upstreamRetry
+	tp.mu.RUnlock()
+	model := requestModel(ex.body)
+
+	for attempt := 0; ; attempt++ {
+		upstream := ex.span.StartChild("upstream", tracing.KindClient)
+		upstream.SetAttribute("vibeproxy.attempt", attempt+1)
+		targetConn, reader, head, err := tp.sendUpstream(ex, upstream)
+		if err != nil {
+			upstream.SetError(err.Error())
+			upstream.End()
+			if isTimeout(err) {
+				ex.log.Error("Upstream timed out", "error", err)
+				tp.sendError(clientConn, ex.req, &proxyError{status: http.StatusGatewayTimeout, code: errBackendTimeout, message: "CLIProxyAPI did not respond in time"})
+				return upstreamResult{status: http.StatusGatewayTimeout}
+			}
+			ex.log.Error("Failed to connect to target", "error", err)
+			tp.sendError(clientConn, ex.req, &proxyError{status: http.StatusBadGateway, code: errBackendUnavailable, message: "CLIProxyAPI is not reachable"})
+			return upstreamResult{status: http.StatusBadGateway}
+		}
+
+		status := responseStatus(head)
+		upstream.SetAttribute("http.response.status_code", status)
+		if !isRateLimitStatus(status) {
+			scanner := &usageScanner{}
+			tap := newResponseTap(head, ex.req, append([]responseObserver{scanner}, ex.observers...)...)
+			streamed := tp.streamResponse(reader, head, clientConn, ex.extraHeaders, tap)
+			targetConn.Close()
+			decoded := tap.Close()
+			upstream.End()
+			return upstreamResult{status: status, usage: scanner.result(), complete: streamed && decoded}
+		}
+
+		// Buffer the (small) error response so it can be inspected
+		resp, err := http.ReadResponse(bufio.NewReader(io.MultiReader(bytes.NewReader(head), reader)), ex.req)
+		if err != nil {
+			targetConn.Close()
+			upstream.SetError(err.Error())
+			upstream.End()
+			ex.log.Error("Read error", "error", err)
+			tp.sendError(clientConn, ex.req, &proxyError{status: http.StatusBadGateway, code: errBackendError, message: "Invalid response from CLIProxyAPI"})
+			return upstreamResult{status: http.StatusBadGateway}
+		}
+		respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize+1))
+		if err != nil {
+			targetConn.Close()
+			upstream.SetError(err.Error())
+			upstream.End()
+			ex.log.Error("Read error", "error", err)
+			tp.sendError(clientConn, ex.req, &proxyError{status: http.StatusBadGateway, code: errBackendError, message: "Invalid response from CLIProxyAPI"})
+			return upstreamResult{status: http.StatusBadGateway}
+		}
+		if len(respBody) > maxErrorBodySize {
+			// Too large to be a rate-limit error; pass it on as it is
+			result := tp.relayResponse(resp, respBody, clientConn, ex)
+			targetConn.Close()
+			upstream.End()
+			return result
+		}
+		resp.Body.Close()
+		targetConn.Close()
+		upstream.End()
+
+		delay, limited := rateLimitDelay(resp, respBody, retry.DefaultCooldown)
+		if !limited {
+			return tp.writeBufferedResponse(resp, respBody, clientConn, ex)
+		}
+
+		tp.cooldowns.set(model, ex.account, delay, fmt.Sprintf("upstream %d", resp.StatusCode))
+
+		// A rate-limited request was rejected, not processed, so it is safe
+		// to send again as long as the client has not seen any bytes yet
+		if attempt >= retry.MaxRetries || delay > retry.MaxDelay {
+			ex.log.Warn("Upstream rate limited, cooling down", "model", model, "status", resp.StatusCode, "cooldown", delay.Round(time.Second).String())
+			return tp.writeBufferedResponse(resp, respBody, clientConn, ex)
+		}
+
+		ex.log.Warn("Upstream rate limited, retrying", "model", model, "status", resp.StatusCode, "delay", delay.Round(time.Millisecond).String(), "attempt", attempt+1, "max_retries", retry.MaxRetries)
+		if !sleep(ex.ctx, delay) {
+			return tp.writeBufferedResponse(resp, respBody, clientConn, ex)
+		}
+	}
+}
+
+// sendUpstream sends the request to CLIProxyAPI and reads the response head,
+// propagating the upstream span as the W3C trace context
+func (tp *ThinkingProxy) sendUpstream(ex *exchange, upstream *tracing.Span) (net.Conn, *bufio.Reader, []byte, error) {
+	req, body, account := ex.req, ex.body, ex.account
+
+	// Connect to CLIProxyAPI, which must answer within the header timeout
+	started := time.Now()
+	connectSpan := upstream.StartChild("connect", tracing.KindInternal)
+	conn, targetAddr, err := tp.dialTarget(ex.timeouts.Dial)
 	if err != nil {
-		log.Printf("[ThinkingProxy] Failed to connect to target: %v", err)
-		tp.sendError(clientConn, http.StatusBadGateway, "Bad Gateway")
-		return
+		connectSpan.SetError(err.Error())
+		connectSpan.End()
+		return nil, nil, nil, err
 	}
-	defer targetConn.Close()
+	connectSpan.End()
+	total := after(started, ex.timeouts.Total)
+	targetConn := &deadlineConn{Conn: conn}
+	targetConn.limit(0, earliest(after(started, ex.timeouts.Header), total))
 
 	// Build forwarded request
 	var buf bytes.Buffer
@@ -274,67 +730,185 @@ func (tp *ThinkingProxy) forwardRequest(req *http.Request, body []byte, clientCo
 
 	// Copy headers except excluded ones
 	excludedHeaders := map[string]bool{
-		"content-length":    true,
-		"host":              true,
-		"transfer-encoding": true,
+		"Content-Length":                       true,
+		"Host":                                 true,
+		"Transfer-Encoding":                    true,
+		"Traceparent":                          true,
+		http.CanonicalHeaderKey(accountHeader): true,
+		http.CanonicalHeaderKey(cacheHeader):   true,
 	}
 
 	for name, values := range req.Header {
-		if excludedHeaders[strings.ToLower(name)] {
+		if excludedHeaders[http.CanonicalHeaderKey(name)] {
 			continue
 		}
 		for _, value := range values {
+			if ex.apiKey != "" {
+				switch strings.ToLower(name) {
+				case "authorization":
+					value = "Bearer " + ex.apiKey
+				case "x-api-key":
+					value = ex.apiKey
+				}
+			}
 			buf.WriteString(fmt.Sprintf("%s: %s\r\n", name, value))
 		}
 	}
 
 	// Add required headers
-	buf.WriteString(fmt.Sprintf("Host: %s:%d\r\n", tp.targetHost, tp.targetPort))
+	buf.WriteString(fmt.Sprintf("Host: %s\r\n", targetAddr))
 	buf.WriteString("Connection: close\r\n") // Always close connections
+	if account != "" {
+		buf.WriteString(fmt.Sprintf("%s: %s\r\n", accountHeader, account))
+	}
+	buf.WriteString(fmt.Sprintf("Traceparent: %s\r\n", upstream.Context().Traceparent()))
 	buf.WriteString(fmt.Sprintf("Content-Length: %d\r\n", len(body)))
 	buf.WriteString("\r\n")
 	buf.Write(body)
 
 	// Send to CLIProxyAPI
 	if _, err := targetConn.Write(buf.Bytes()); err != nil {
-		log.Printf("[ThinkingProxy] Send error: %v", err)
-		return
+		targetConn.Close()
+		return nil, nil, nil, fmt.Errorf("send error: %w", err)
+	}
+
+	waitSpan := upstream.StartChild("wait_first_byte", tracing.KindInternal)
+	reader := bufio.NewReaderSize(targetConn, 65536)
+	head, err := readResponseHead(reader)
+	waitSpan.End()
+	if err != nil {
+		targetConn.Close()
+		return nil, nil, nil, fmt.Errorf("read error: %w", err)
 	}
+	targetConn.limit(ex.timeouts.Idle, total)
 
-	// Stream response back to client
-	tp.streamResponse(targetConn, clientConn)
+	return targetConn, reader, head, nil
 }
 
-// streamResponse streams the response from target to client
-func (tp *ThinkingProxy) streamResponse(targetConn, clientConn net.Conn) {
+// streamResponse streams the response from target to client, inserting
+// extraHeaders into the response head and copying the body to tap.
+// Returns true if the backend closed the stream normally.
+func (tp *ThinkingProxy) streamResponse(reader *bufio.Reader, head []byte, clientConn net.Conn, extraHeaders http.Header, tap io.Writer) bool {
+	if _, err := clientConn.Write(withHeaders(head, extraHeaders)); err != nil {
+		logger.Warn("Write error", "error", err)
+		return false
+	}
+
 	buf := make([]byte, 65536)
 	for {
-		n, err := targetConn.Read(buf)
+		n, err := reader.Read(buf)
 		if n > 0 {
 			if _, writeErr := clientConn.Write(buf[:n]); writeErr != nil {
-				log.Printf("[ThinkingProxy] Write error: %v", writeErr)
-				return
+				logger.Warn("Write error", "error", writeErr)
+				return false
 			}
+			tap.Write(buf[:n])
 		}
 		if err != nil {
 			if err != io.EOF {
-				log.Printf("[ThinkingProxy] Read error: %v", err)
+				logger.Warn("Read error", "error", err)
+				return false
 			}
-			return
+			return true
+		}
+	}
+}
+
+// writeBufferedResponse writes a fully read response to the client and
+// shows it to the exchange's observers
+func (tp *ThinkingProxy) writeBufferedResponse(resp *http.Response, body []byte, clientConn net.Conn, ex *exchange) upstreamResult {
+	for _, observer := range ex.observers {
+		observer.observeHeader(resp)
+		observer.Write(body)
+	}
+
+	for name, values := range ex.extraHeaders {
+		resp.Header[name] = values
+	}
+	resp.Header.Del("Transfer-Encoding")
+	resp.TransferEncoding = nil
+	resp.ContentLength = int64(len(body))
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.Close = true
+
+	if err := resp.Write(clientConn); err != nil {
+		ex.log.Warn("Write error", "error", err)
+		return upstreamResult{status: resp.StatusCode}
+	}
+	return upstreamResult{status: resp.StatusCode, usage: parseUsage(body), complete: true}
+}
+
+// relayResponse streams a parsed response to the client, starting with the
+// already read part of its body, and shows it to the exchange's observers
+func (tp *ThinkingProxy) relayResponse(resp *http.Response, start []byte, clientConn net.Conn, ex *exchange) upstreamResult {
+	for _, observer := range ex.observers {
+		observer.observeHeader(resp)
+	}
+
+	for name, values := range ex.extraHeaders {
+		resp.Header[name] = values
+	}
+	body := io.MultiReader(bytes.NewReader(start), resp.Body)
+	resp.Body = io.NopCloser(io.TeeReader(body, observerWriter(ex.observers)))
+	resp.Close = true
+
+	if err := resp.Write(clientConn); err != nil {
+		ex.log.Warn("Write error", "error", err)
+		return upstreamResult{status: resp.StatusCode}
+	}
+	return upstreamResult{status: resp.StatusCode, complete: true}
+}
+
+// observerWriter shows written bytes to every observer
+type observerWriter []responseObserver
+
+func (w observerWriter) Write(p []byte) (int, error) {
+	for _, observer := range w {
+		observer.Write(p)
+	}
+	return len(p), nil
+}
+
+// responseStatus parses the status code from a raw response head
+func responseStatus(head []byte) int {
+	fields := strings.Fields(string(head[:bytes.IndexByte(head, '\n')+1]))
+	if len(fields) < 2 {
+		return 0
+	}
+	status, _ := strconv.Atoi(fields[1])
+	return status
+}
+
+// readResponseHead reads the raw status line and headers, including the
+// terminating blank line
+func readResponseHead(reader *bufio.Reader) ([]byte, error) {
+	var head []byte
+	for {
+		line, err := reader.ReadSlice('\n')
+		head = append(head, line...)
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return head, nil
 		}
 	}
 }
 
-// sendError sends an HTTP error response
-func (tp *ThinkingProxy) sendError(conn net.Conn, statusCode int, message string) {
-	body := message
-	response := fmt.Sprintf("HTTP/1.1 %d %s\r\n"+
-		"Content-Type: text/plain\r\n"+
-		"Content-Length: %d\r\n"+
-		"Connection: close\r\n"+
-		"\r\n"+
-		"%s", statusCode, message, len(body), body)
-
-	conn.Write([]byte(response))
-	conn.Close()
+// withHeaders inserts headers before the blank line that ends a raw response head
+func withHeaders(head []byte, headers http.Header) []byte {
+	end := len(head) - len("\r\n")
+	if !bytes.HasSuffix(head, []byte("\r\n\r\n")) {
+		end = len(head) - len("\n")
+	}
+
+	var buf bytes.Buffer
+	buf.Write(head[:end])
+	for name, values := range headers {
+		for _, value := range values {
+			buf.WriteString(fmt.Sprintf("%s: %s\r\n", name, value))
+		}
+	}
+	buf.Write(head[end:])
+	return buf.Bytes()
 }
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestProcessThinkingParameter(t *testing.T) {
+	tp := NewThinkingProxy(0, 0)
+
+	for _, tt := range []struct {
+		model   string
+		budget  int    // expected budget_tokens; 0 for no thinking
+		forward string // expected model sent on
+		invalid bool
+	}{
+		{model: "claude-sonnet-4-5-thinking-10000", budget: 10000, forward: "claude-sonnet-4-5"},
+		{model: "claude-sonnet-4-5-thinking-50000", budget: 31999, forward: "claude-sonnet-4-5"},
+		{model: "claude-sonnet-4-5", forward: "claude-sonnet-4-5"},
+		{model: "gpt-5-thinking-100", forward: "gpt-5-thinking-100"},
+		{model: "claude-sonnet-4-5-thinking-blabla", invalid: true},
+		{model: "claude-sonnet-4-5-thinking-0", invalid: true},
+		{model: "claude-sonnet-4-5-thinking--5", invalid: true},
+	} {
+		body, _ := json.Marshal(map[string]interface{}{"model": tt.model, "max_tokens": 1000})
+		modified, _, err := tp.processThinkingParameter(body)
+		if tt.invalid {
+			if err == nil || err.status != http.StatusBadRequest || err.code != errInvalidThinkingBudget {
+				t.Errorf("%s: got %v, want 400 %s", tt.model, err, errInvalidThinkingBudget)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: rejected: %s", tt.model, err.message)
+			continue
+		}
+
+		var out struct {
+			Model    string `json:"model"`
+			Thinking *struct {
+				BudgetTokens int `json:"budget_tokens"`
+			} `json:"thinking"`
+			MaxTokens int `json:"max_tokens"`
+		}
+		if err := json.Unmarshal(modified, &out); err != nil {
+			t.Fatalf("%s: %v", tt.model, err)
+		}
+		if out.Model != tt.forward {
+			t.Errorf("%s: forwarded model %q, want %q", tt.model, out.Model, tt.forward)
+		}
+		switch {
+		case tt.budget == 0 && out.Thinking != nil:
+			t.Errorf("%s: thinking added", tt.model)
+		case tt.budget != 0 && (out.Thinking == nil || out.Thinking.BudgetTokens != tt.budget):
+			t.Errorf("%s: thinking = %+v, want budget %d", tt.model, out.Thinking, tt.budget)
+		case tt.budget != 0 && out.MaxTokens <= tt.budget:
+			t.Errorf("%s: max_tokens %d not above the budget", tt.model, out.MaxTokens)
+		}
+	}
+}
+
+func TestInvalidThinkingBudgetErrorShape(t *testing.T) {
+	_, _, e := NewThinkingProxy(0, 0).processThinkingParameter([]byte(`{"model":"claude-opus-4-1-thinking-abc"}`))
+	if e == nil {
+		t.Fatal("invalid budget accepted")
+	}
+
+	var anthropic struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type string `json:"type"`
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(errorBody("anthropic", e), &anthropic); err != nil {
+		t.Fatal(err)
+	}
+	if anthropic.Type != "error" || anthropic.Error.Type != "invalid_request_error" || anthropic.Error.Code != "invalid_thinking_budget" {
+		t.Errorf("anthropic body = %+v", anthropic)
+	}
+
+	var openai struct {
+		Error struct {
+			Type string `json:"type"`
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(errorBody("openai", e), &openai); err != nil {
+		t.Fatal(err)
+	}
+	if openai.Error.Type != "invalid_request_error" || openai.Error.Code != "invalid_thinking_budget" {
+		t.Errorf("openai body = %+v", openai)
+	}
+}
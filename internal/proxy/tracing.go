@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"log/slog"
+
+	"github.com/automazeio/vibeproxy/internal/tracing"
+)
+
+// requestIDHeader carries the trace ID back to the client
+const requestIDHeader = "X-Request-Id"
+
+// SetTracer replaces the tracer used for request spans
+func (tp *ThinkingProxy) SetTracer(tracer *tracing.Tracer) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.tracer = tracer
+}
+
+// requestLogger returns the proxy logger tagged with the request's trace ID
+func requestLogger(span *tracing.Span) *slog.Logger {
+	return logger.With("trace_id", span.TraceID())
+}
+
+// annotate copies the inspector record onto the request span
+func annotate(span *tracing.Span, rec *RequestRecord) {
+	span.SetAttribute("http.request.method", rec.Method)
+	span.SetAttribute("url.path", rec.Path)
+	span.SetAttribute("client.address", rec.Client)
+	span.SetAttribute("http.response.status_code", rec.Status)
+	if rec.Model != "" {
+		span.SetAttribute("gen_ai.request.model", rec.Model)
+	}
+	if rec.BackendModel != rec.Model {
+		span.SetAttribute("vibeproxy.backend_model", rec.BackendModel)
+	}
+	if rec.ThinkingBudget > 0 {
+		span.SetAttribute("vibeproxy.thinking_budget", rec.ThinkingBudget)
+	}
+	if rec.AccountHint != "" {
+		span.SetAttribute("vibeproxy.account_hint", rec.AccountHint)
+	}
+	if rec.Source != "" {
+		span.SetAttribute("vibeproxy.source", rec.Source)
+	}
+	if !rec.Usage.IsZero() {
+		span.SetAttribute("gen_ai.usage.input_tokens", rec.Usage.InputTokens)
+		span.SetAttribute("gen_ai.usage.output_tokens", rec.Usage.OutputTokens)
+	}
+	if rec.Status == 0 || rec.Status >= 500 {
+		span.SetError("upstream error")
+	}
+}
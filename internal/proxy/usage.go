@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxScannedJSONBody bounds how much of a non-streaming body is kept for parsing
+const maxScannedJSONBody = 8 << 20
+
+// Usage holds token counts reported by the provider for one response
+type Usage struct {
+	InputTokens              int `json:"inputTokens"`
+	OutputTokens             int `json:"outputTokens"`
+	CacheCreationInputTokens int `json:"cacheCreationInputTokens,omitempty"`
+	CacheReadInputTokens     int `json:"cacheReadInputTokens,omitempty"`
+}
+
+// IsZero reports whether no usage was found
+func (u Usage) IsZero() bool {
+	return u == Usage{}
+}
+
+// merge keeps the largest value of each counter; streaming APIs report
+// cumulative counts spread across several events
+func (u *Usage) merge(other Usage) {
+	u.InputTokens = max(u.InputTokens, other.InputTokens)
+	u.OutputTokens = max(u.OutputTokens, other.OutputTokens)
+	u.CacheCreationInputTokens = max(u.CacheCreationInputTokens, other.CacheCreationInputTokens)
+	u.CacheReadInputTokens = max(u.CacheReadInputTokens, other.CacheReadInputTokens)
+}
+
+// usagePayload matches the usage objects of Anthropic and OpenAI responses
+type usagePayload struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	PromptTokens             int `json:"prompt_tokens"`
+	CompletionTokens         int `json:"completion_tokens"`
+	PromptTokensDetails      struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+// toUsage normalizes provider-specific field names
+func (p *usagePayload) toUsage() Usage {
+	if p == nil {
+		return Usage{}
+	}
+	return Usage{
+		InputTokens:              max(p.InputTokens, p.PromptTokens),
+		OutputTokens:             max(p.OutputTokens, p.CompletionTokens),
+		CacheCreationInputTokens: p.CacheCreationInputTokens,
+		CacheReadInputTokens:     max(p.CacheReadInputTokens, p.PromptTokensDetails.CachedTokens),
+	}
+}
+
+// parseUsage extracts usage from a JSON response or SSE event payload
+func parseUsage(data []byte) Usage {
+	var payload struct {
+		Usage   *usagePayload `json:"usage"`
+		Message struct {
+			Usage *usagePayload `json:"usage"`
+		} `json:"message"`
+		Response struct {
+			Usage *usagePayload `json:"usage"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return Usage{}
+	}
+
+	usage := payload.Usage.toUsage()
+	usage.merge(payload.Message.Usage.toUsage())
+	usage.merge(payload.Response.Usage.toUsage())
+	return usage
+}
+
+// responseObserver receives the decoded response body as it streams to the client
+type responseObserver interface {
+	io.Writer
+	observeHeader(resp *http.Response)
+}
+
+// usageScanner collects token usage from JSON and SSE responses
+type usageScanner struct {
+	mu      sync.Mutex
+	sse     bool
+	pending []byte
+	body    bytes.Buffer
+	usage   Usage
+}
+
+// observeHeader decides between SSE and plain JSON parsing
+func (s *usageScanner) observeHeader(resp *http.Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sse = strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+}
+
+// Write parses complete SSE lines or buffers JSON bodies
+func (s *usageScanner) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.sse {
+		if s.body.Len() < maxScannedJSONBody {
+			s.body.Write(p)
+		}
+		return len(p), nil
+	}
+
+	s.pending = append(s.pending, p...)
+	for {
+		idx := bytes.IndexByte(s.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		line := bytes.TrimSpace(s.pending[:idx])
+		s.pending = s.pending[idx+1:]
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			s.usage.merge(parseUsage(bytes.TrimSpace(data)))
+		}
+	}
+	return len(p), nil
+}
+
+// result returns the usage found in the response
+func (s *usageScanner) result() Usage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.sse && s.body.Len() > 0 {
+		s.usage.merge(parseUsage(s.body.Bytes()))
+		s.body.Reset()
+	}
+	return s.usage
+}
+
+// responseTap decodes a copy of the raw response stream (chunked framing,
+// gzip) and hands the plain body to observers. The pipe is synchronous: each
+// write returns only once the decoder has taken it, so the client stream
+// moves at the pace of the observers, which must not block.
+type responseTap struct {
+	pw      *io.PipeWriter
+	done    chan struct{}
+	decoded bool
+}
+
+// newResponseTap starts decoding the response that begins with head
+func newResponseTap(head []byte, req *http.Request, observers ...responseObserver) *responseTap {
+	pr, pw := io.Pipe()
+	tap := &responseTap{pw: pw, done: make(chan struct{})}
+
+	go func() {
+		defer close(tap.done)
+		// Keep draining so writes never block once decoding stops
+		defer io.Copy(io.Discard, pr)
+
+		resp, err := http.ReadResponse(bufio.NewReader(io.MultiReader(bytes.NewReader(head), pr)), req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+
+		writers := make([]io.Writer, 0, len(observers))
+		for _, observer := range observers {
+			observer.observeHeader(resp)
+			writers = append(writers, observer)
+		}
+
+		body := io.Reader(resp.Body)
+		if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+			gz, err := gzip.NewReader(body)
+			if err != nil {
+				return
+			}
+			body = gz
+		}
+		_, err = io.Copy(io.MultiWriter(writers...), body)
+		tap.decoded = err == nil
+	}()
+
+	return tap
+}
+
+// Write feeds raw response bytes (after the head) to the decoder, waiting
+// until it has read them
+func (t *responseTap) Write(p []byte) (int, error) {
+	t.pw.Write(p)
+	return len(p), nil
+}
+
+// Close ends the stream and waits for observers to see all of it. Returns
+// true if the body was decoded completely.
+func (t *responseTap) Close() bool {
+	t.pw.Close()
+	<-t.done
+	return t.decoded
+}
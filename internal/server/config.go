@@ -0,0 +1,295 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+// SetSettingsHandler enables editing vibeproxy.yaml at path through
+// /api/config; apply is called with the saved settings
+func (s *UIServer) SetSettingsHandler(path string, apply func(*config.Settings) error) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	s.settingsPath = path
+	s.applySettings = apply
+}
+
+// handleConfig returns (GET) or changes (PUT) config.yaml and vibeproxy.yaml
+func (s *UIServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getConfig(w)
+	case http.MethodPut:
+		s.updateConfig(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getConfig returns both files as validated, keyed like the YAML, with
+// secrets masked
+func (s *UIServer) getConfig(w http.ResponseWriter) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	backendPath := s.processManager.ConfigPath()
+	backend, err := config.LoadCLIProxyAPIConfig(backendPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	backendValues, err := backend.MaskedValues()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"backend":     backendValues,
+		"backendPath": backendPath,
+		"backups": map[string]bool{
+			"backend":  fileExists(config.BackupPath(backendPath)),
+			"settings": s.applySettings != nil && fileExists(config.BackupPath(s.settingsPath)),
+		},
+	}
+
+	if s.applySettings != nil {
+		settings, err := config.LoadSettings(s.settingsPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		settingsValues, err := settings.MaskedValues()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response["settings"] = settingsValues
+		response["settingsPath"] = s.settingsPath
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// updateConfig applies the keys present in the request to each file; masked
+// secrets keep their stored value. Both are validated before either is
+// written; the backend is restarted when config.yaml changed and settings
+// are applied without a restart. The files as they were before this save
+// are kept as backups for /api/config/rollback.
+func (s *UIServer) updateConfig(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Backend  map[string]interface{} `json:"backend"`
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	var backend *config.CLIProxyAPIConfig
+	if len(req.Backend) > 0 {
+		var err error
+		backend, err = config.LoadCLIProxyAPIConfig(s.processManager.ConfigPath())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := backend.Unmask(req.Backend); err != nil {
+			http.Error(w, "config.yaml: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := backend.Update(req.Backend); err != nil {
+			http.Error(w, "config.yaml: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var settings *config.Settings
+	if len(req.Settings) > 0 {
+		if s.applySettings == nil {
+			http.Error(w, "Settings cannot be edited", http.StatusBadRequest)
+			return
+		}
+		var err error
+		settings, err = config.LoadSettings(s.settingsPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := settings.Unmask(req.Settings); err != nil {
+			http.Error(w, "vibeproxy.yaml: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := settings.Update(req.Settings); err != nil {
+			http.Error(w, "vibeproxy.yaml: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	backendSaved := backend != nil && backend.Modified()
+	settingsSaved := settings != nil && settings.Modified()
+
+	restarted := false
+	if backendSaved {
+		var err error
+		if restarted, err = s.saveBackendConfig(backend); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if settingsSaved {
+		if err := s.saveSettings(settings); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// A rollback undoes this save only, not an older change to the other file
+	if backendSaved && !settingsSaved && s.applySettings != nil {
+		if err := config.RemoveBackup(s.settingsPath); err != nil {
+			logger.Warn("Failed to remove settings backup", "error", err)
+		}
+	}
+	if settingsSaved && !backendSaved {
+		if err := config.RemoveBackup(s.processManager.ConfigPath()); err != nil {
+			logger.Warn("Failed to remove config.yaml backup", "error", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"restarted": restarted,
+		"port":      s.processManager.Port(),
+	})
+}
+
+// saveBackendConfig writes config.yaml, keeping the previous version as a
+// backup, and restarts the backend if it is running. The backup is put back
+// when the new config does not come up.
+func (s *UIServer) saveBackendConfig(cfg *config.CLIProxyAPIConfig) (bool, error) {
+	path := cfg.Path()
+	if !cfg.Modified() {
+		return false, nil
+	}
+
+	undo, err := config.Backup(path)
+	if err != nil {
+		return false, err
+	}
+	if err := cfg.Save(path); err != nil {
+		return false, err
+	}
+	logger.Info("Saved config.yaml", "path", path)
+
+	if !s.processManager.IsRunning() || !s.processManager.ConfigChanged() {
+		return false, nil
+	}
+	if err := s.processManager.Restart(); err != nil {
+		if undoErr := undo(); undoErr != nil {
+			logger.Error("Failed to restore config.yaml", "error", undoErr)
+		}
+		return false, fmt.Errorf("backend did not start with the new config, restored the previous one: %w", err)
+	}
+	return true, nil
+}
+
+// saveSettings writes vibeproxy.yaml, keeping the previous version as a
+// backup, and applies it. The backup is put back when applying fails.
+func (s *UIServer) saveSettings(settings *config.Settings) error {
+	path := settings.Path()
+	if !settings.Modified() {
+		return nil
+	}
+
+	undo, err := config.Backup(path)
+	if err != nil {
+		return err
+	}
+	if err := settings.Save(path); err != nil {
+		return err
+	}
+	logger.Info("Saved settings", "path", path)
+
+	if err := s.applySettings(settings); err != nil {
+		if undoErr := undo(); undoErr != nil {
+			logger.Error("Failed to restore vibeproxy.yaml", "error", undoErr)
+		}
+		return fmt.Errorf("settings could not be applied, restored the previous ones: %w", err)
+	}
+	return nil
+}
+
+// handleConfigRollback puts the backups of config.yaml and vibeproxy.yaml
+// back in place and applies them
+func (s *UIServer) handleConfigRollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	backendPath := s.processManager.ConfigPath()
+	rollBackBackend := fileExists(config.BackupPath(backendPath))
+	rollBackSettings := s.applySettings != nil && fileExists(config.BackupPath(s.settingsPath))
+	if !rollBackBackend && !rollBackSettings {
+		http.Error(w, "No backup to restore", http.StatusNotFound)
+		return
+	}
+
+	restarted := false
+	if rollBackBackend {
+		if err := config.Restore(backendPath); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Restored config.yaml from backup", "path", backendPath)
+		if s.processManager.IsRunning() && s.processManager.ConfigChanged() {
+			if err := s.processManager.Restart(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			restarted = true
+		}
+	}
+
+	if rollBackSettings {
+		if err := config.Restore(s.settingsPath); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Restored settings from backup", "path", s.settingsPath)
+		settings, err := config.LoadSettings(s.settingsPath)
+		if err == nil {
+			err = s.applySettings(settings)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"backend":   rollBackBackend,
+		"settings":  rollBackSettings,
+		"restarted": restarted,
+	})
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
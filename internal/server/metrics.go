@@ -0,0 +1,70 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/metrics"
+)
+
+// registerMetrics adds backend process and auth gauges to the registry
+func (s *UIServer) registerMetrics() {
+	s.metrics.Register(
+		metrics.NewGaugeFunc("vibeproxy_backend_up",
+			"Whether CLIProxyAPI is running and accepting connections.", nil,
+			func(emit func(float64, ...string)) {
+				up := 0.0
+				if s.processManager.IsRunning() && s.processManager.HealthCheck() {
+					up = 1
+				}
+				emit(up)
+			}),
+		metrics.NewCounterFunc("vibeproxy_backend_starts_total",
+			"CLIProxyAPI process starts.", nil,
+			func(emit func(float64, ...string)) {
+				emit(float64(s.processManager.Stats().Starts))
+			}),
+		metrics.NewCounterFunc("vibeproxy_backend_restarts_total",
+			"CLIProxyAPI process starts after the first.", nil,
+			func(emit func(float64, ...string)) {
+				emit(float64(s.processManager.Stats().Restarts))
+			}),
+		metrics.NewCounterFunc("vibeproxy_backend_crashes_total",
+			"CLIProxyAPI exits that were not requested.", nil,
+			func(emit func(float64, ...string)) {
+				emit(float64(s.processManager.Stats().Crashes))
+			}),
+		metrics.NewGaugeFunc("vibeproxy_auth_authenticated",
+			"Whether a credential file exists for the provider.", []string{"provider"},
+			func(emit func(float64, ...string)) {
+				status := s.authManager.GetStatus()
+				for _, provider := range sortedProviders(status) {
+					authenticated := 0.0
+					if status[provider].IsAuthenticated {
+						authenticated = 1
+					}
+					emit(authenticated, provider)
+				}
+			}),
+		metrics.NewGaugeFunc("vibeproxy_auth_expiry_seconds",
+			"Seconds until the provider credential expires; negative once expired.", []string{"provider"},
+			func(emit func(float64, ...string)) {
+				status := s.authManager.GetStatus()
+				for _, provider := range sortedProviders(status) {
+					if st := status[provider]; st.IsAuthenticated && !st.Expired.IsZero() {
+						emit(time.Until(st.Expired).Seconds(), provider)
+					}
+				}
+			}),
+	)
+}
+
+// sortedProviders returns provider names in a stable order
+func sortedProviders[V any](status map[string]V) []string {
+	providers := make([]string, 0, len(status))
+	for provider := range status {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+	return providers
+}
@@ -0,0 +1,384 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+)
+
+const (
+	sessionCookie = "vibeproxy_session"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+// unixConnKey marks requests that arrived on the Unix socket
+type unixConnKey struct{}
+
+// principalKey holds the caller of an API request
+type principalKey struct{}
+
+// principal is who calls the API: the owner (this machine or the UI token)
+// or a team member, who may only read their own usage and the status
+type principal struct {
+	user  string // team member name; empty for the owner
+	admin bool
+}
+
+// memberPaths are the API endpoints team members without the admin role
+// may call
+var memberPaths = []string{"/api/status", "/api/team/usage"}
+
+// session is a browser logged in with the token or a team member's key
+type session struct {
+	principal
+	csrf    string
+	expires time.Time
+}
+
+// uiGuard authenticates API calls and rejects cross-site and DNS rebinding
+// requests before they reach the handlers
+type uiGuard struct {
+	mu       sync.Mutex
+	cfg      config.UIAuthConfig
+	token    string
+	csrf     string // for browsers on this machine that have not logged in
+	sessions map[string]*session
+	hosts    []string
+}
+
+// newUIGuard creates a guard that accepts token for logins
+func newUIGuard(cfg config.UIAuthConfig, token string) *uiGuard {
+	g := &uiGuard{
+		cfg:      cfg,
+		token:    token,
+		csrf:     randomToken(),
+		sessions: make(map[string]*session),
+		hosts:    []string{"localhost"},
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		g.hosts = append(g.hosts, strings.ToLower(hostname), strings.ToLower(hostname)+".local")
+	}
+	for _, host := range cfg.AllowedHosts {
+		g.hosts = append(g.hosts, strings.ToLower(host))
+	}
+	return g
+}
+
+// SetAuth sets the login token and the web UI's access rules
+func (s *UIServer) SetAuth(cfg config.UIAuthConfig, token string) {
+	s.guard = newUIGuard(cfg, token)
+}
+
+// protect wraps the API with the guard; the login page's static files are
+// served to anyone who passes the Host check
+func (s *UIServer) protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g := s.guard
+		unix := r.Context().Value(unixConnKey{}) != nil
+		if !unix && !g.allowedHost(r.Host) {
+			logger.Warn("Rejected request for unknown host", "host", r.Host, "remote", r.RemoteAddr)
+			http.Error(w, "Unknown host", http.StatusMisdirectedRequest)
+			return
+		}
+		if changesState(r) && !g.allowedOrigin(r) {
+			logger.Warn("Rejected cross-origin request", "origin", r.Header.Get("Origin"), "path", r.URL.Path)
+			http.Error(w, "Cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/api/session", "/api/login", "/api/logout":
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		caller, sess := s.caller(r)
+		if caller == nil {
+			http.Error(w, "Login required", http.StatusUnauthorized)
+			return
+		}
+		if !caller.admin && !slices.Contains(memberPaths, r.URL.Path) {
+			http.Error(w, "Admin role required", http.StatusForbidden)
+			return
+		}
+
+		// Browsers carry the session cookie on any request, so changes also
+		// need the CSRF token, which only pages of this origin can read
+		if changesState(r) && (sess != nil || isBrowser(r)) && !s.bearer(r) {
+			want := g.csrf
+			if sess != nil {
+				want = sess.csrf
+			}
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeader)), []byte(want)) != 1 {
+				http.Error(w, "Missing or invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, caller)))
+	})
+}
+
+// caller identifies who made a request: a bearer key, a session, or the
+// owner on this machine; nil means a login is required
+func (s *UIServer) caller(r *http.Request) (*principal, *session) {
+	g := s.guard
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if p, ok := s.login(token); ok {
+			return p, nil
+		}
+	}
+	if sess := g.session(r); sess != nil {
+		return &sess.principal, sess
+	}
+	if r.Context().Value(unixConnKey{}) != nil || (!g.cfg.RequireLogin && isLoopback(r.RemoteAddr)) {
+		return &principal{admin: true}, nil
+	}
+	return nil, nil
+}
+
+// login checks a token: the UI token logs in the owner, a team member's
+// key logs in that member
+func (s *UIServer) login(token string) (*principal, bool) {
+	if s.guard.checkToken(token) {
+		return &principal{admin: true}, true
+	}
+	if s.thinkingProxy.TeamEnabled() {
+		if user, ok := s.thinkingProxy.TeamUser(token); ok {
+			return &principal{user: user.Name, admin: user.IsAdmin()}, true
+		}
+	}
+	return nil, false
+}
+
+// callerOf returns the principal protect found for a request
+func callerOf(r *http.Request) *principal {
+	if p, ok := r.Context().Value(principalKey{}).(*principal); ok {
+		return p
+	}
+	return &principal{admin: true}
+}
+
+// allowedHost guards against DNS rebinding: the Host header must be an IP
+// address, localhost, this machine's name or an allowed host
+func (g *uiGuard) allowedHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	if net.ParseIP(host) != nil || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	return slices.Contains(g.hosts, host)
+}
+
+// allowedOrigin accepts requests without an Origin header, from the UI's
+// own origin and from trusted origins
+func (g *uiGuard) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return r.Header.Get("Sec-Fetch-Site") != "cross-site"
+	}
+	if slices.Contains(g.cfg.TrustedOrigins, strings.TrimSuffix(origin, "/")) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// bearer reports whether the request carries a valid token or key, as
+// scripts do
+func (s *UIServer) bearer(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	_, ok = s.login(token)
+	return ok
+}
+
+// checkToken compares token with the login token in constant time
+func (g *uiGuard) checkToken(token string) bool {
+	return g.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(g.token)) == 1
+}
+
+// session returns the request's unexpired session
+func (g *uiGuard) session(r *http.Request) *session {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sess, ok := g.sessions[cookie.Value]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(sess.expires) {
+		delete(g.sessions, cookie.Value)
+		return nil
+	}
+	return sess
+}
+
+// handleSession tells the page whether it must log in and gives it the
+// CSRF token for its changes
+func (s *UIServer) handleSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	g := s.guard
+	local := r.Context().Value(unixConnKey{}) != nil || (!g.cfg.RequireLogin && isLoopback(r.RemoteAddr))
+	caller, sess := s.caller(r)
+	out := map[string]interface{}{
+		"authenticated": caller != nil,
+		"loginRequired": !local,
+		"team":          s.thinkingProxy.TeamEnabled(),
+	}
+	if caller != nil {
+		out["user"] = caller.user
+		out["role"] = "member"
+		if caller.admin {
+			out["role"] = "admin"
+		}
+	}
+	if sess != nil {
+		out["csrfToken"] = sess.csrf
+	} else if local {
+		out["csrfToken"] = g.csrf
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleLogin exchanges the UI token or a team member's key for a session
+// cookie
+func (s *UIServer) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	g := s.guard
+	caller, ok := s.login(req.Token)
+	if !ok {
+		logger.Warn("Failed web UI login", "remote", r.RemoteAddr)
+		// Slow down guessing
+		time.Sleep(time.Second)
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	id, sess := randomToken(), &session{principal: *caller, csrf: randomToken(), expires: time.Now().Add(g.cfg.SessionTTL)}
+	g.mu.Lock()
+	for key, old := range g.sessions {
+		if time.Now().After(old.expires) {
+			delete(g.sessions, key)
+		}
+	}
+	g.sessions[id] = sess
+	g.mu.Unlock()
+	logger.Info("Web UI login", "remote", r.RemoteAddr, "user", caller.user, "admin", caller.admin)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  sess.expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	role := "member"
+	if caller.admin {
+		role = "admin"
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "csrfToken": sess.csrf, "user": caller.user, "role": role})
+}
+
+// handleLogout ends the request's session
+func (s *UIServer) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		s.guard.mu.Lock()
+		delete(s.guard.sessions, cookie.Value)
+		s.guard.mu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// markUnixConn tags connections accepted on the Unix socket, which only
+// permitted local users reach
+func markUnixConn(ctx context.Context, c net.Conn) context.Context {
+	if c.LocalAddr().Network() == "unix" {
+		return context.WithValue(ctx, unixConnKey{}, true)
+	}
+	return ctx
+}
+
+// changesState reports whether the method may change something
+func changesState(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// isBrowser reports whether a browser sent the request; scripts and the CLI
+// send neither header
+func isBrowser(r *http.Request) bool {
+	return r.Header.Get("Origin") != "" || r.Header.Get("Sec-Fetch-Mode") != ""
+}
+
+// isLoopback reports whether a remote address is this machine
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// randomToken returns 32 random bytes in hex
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/config"
+	"github.com/automazeio/vibeproxy/internal/proxy"
+)
+
+const testToken = "test-ui-token"
+
+// newGuardedServer returns a server and its guarded handler, which answers
+// 200 once a request gets past the guard
+func newGuardedServer(cfg config.UIAuthConfig) (*UIServer, http.Handler) {
+	if cfg.SessionTTL == 0 {
+		cfg.SessionTTL = time.Hour
+	}
+	s := &UIServer{thinkingProxy: proxy.NewThinkingProxy(0, 0)}
+	s.SetAuth(cfg, testToken)
+	return s, s.protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+// localRequest is a request from this machine to the UI at localhost
+func localRequest(method, path string) *http.Request {
+	r := httptest.NewRequest(method, "http://localhost:8319"+path, nil)
+	r.RemoteAddr = "127.0.0.1:50000"
+	return r
+}
+
+func serve(h http.Handler, r *http.Request) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestProtectRejectsForeignOrigin(t *testing.T) {
+	s, h := newGuardedServer(config.UIAuthConfig{TrustedOrigins: []string{"https://vibe.example.com"}})
+
+	for _, tt := range []struct {
+		name   string
+		origin string
+		site   string
+		want   int
+	}{
+		{"foreign origin", "http://evil.example", "", http.StatusForbidden},
+		{"origin on another port", "http://localhost:9999", "", http.StatusForbidden},
+		{"cross-site without origin", "", "cross-site", http.StatusForbidden},
+		{"own origin", "http://localhost:8319", "", http.StatusOK},
+		{"trusted origin", "https://vibe.example.com", "", http.StatusOK},
+	} {
+		r := localRequest(http.MethodPost, "/api/server/reload")
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if tt.site != "" {
+			r.Header.Set("Sec-Fetch-Site", tt.site)
+		}
+		r.Header.Set(csrfHeader, s.guard.csrf)
+		if got := serve(h, r); got != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProtectRejectsForeignHost(t *testing.T) {
+	_, h := newGuardedServer(config.UIAuthConfig{AllowedHosts: []string{"vibe.lan"}})
+
+	for _, tt := range []struct {
+		host string
+		want int
+	}{
+		{"evil.example:8319", http.StatusMisdirectedRequest}, // DNS rebinding
+		{"localhost.evil.example", http.StatusMisdirectedRequest},
+		{"localhost:8319", http.StatusOK},
+		{"127.0.0.1:8319", http.StatusOK},
+		{"[::1]:8319", http.StatusOK},
+		{"app.localhost:8319", http.StatusOK},
+		{"VIBE.lan:8319", http.StatusOK},
+	} {
+		r := localRequest(http.MethodGet, "/api/status")
+		r.Host = tt.host
+		if got := serve(h, r); got != tt.want {
+			t.Errorf("Host %q: status %d, want %d", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestProtectRequiresCSRFToken(t *testing.T) {
+	s, h := newGuardedServer(config.UIAuthConfig{})
+
+	for _, tt := range []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"missing token", "", http.StatusForbidden},
+		{"wrong token", "not-the-token", http.StatusForbidden},
+		{"page token", s.guard.csrf, http.StatusOK},
+	} {
+		r := localRequest(http.MethodPut, "/api/config")
+		r.Header.Set("Origin", "http://localhost:8319")
+		r.Header.Set("Sec-Fetch-Mode", "cors")
+		if tt.token != "" {
+			r.Header.Set(csrfHeader, tt.token)
+		}
+		if got := serve(h, r); got != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// Reads need no token, and neither do scripts using the UI token
+	if got := serve(h, localRequest(http.MethodGet, "/api/config")); got != http.StatusOK {
+		t.Errorf("GET without token: status %d, want 200", got)
+	}
+	r := localRequest(http.MethodPut, "/api/config")
+	r.Header.Set("Sec-Fetch-Mode", "cors")
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	if got := serve(h, r); got != http.StatusOK {
+		t.Errorf("PUT with bearer token: status %d, want 200", got)
+	}
+}
+
+func TestProtectRequiresSessionForRemoteClients(t *testing.T) {
+	s, h := newGuardedServer(config.UIAuthConfig{})
+
+	remote := func(method, path string) *http.Request {
+		r := httptest.NewRequest(method, "http://192.168.1.10:8319"+path, nil)
+		r.RemoteAddr = "192.168.1.20:50000"
+		return r
+	}
+
+	if got := serve(h, remote(http.MethodGet, "/api/status")); got != http.StatusUnauthorized {
+		t.Errorf("remote without session: status %d, want 401", got)
+	}
+	if got := serve(h, remote(http.MethodGet, "/metrics")); got != http.StatusUnauthorized {
+		t.Errorf("remote metrics without session: status %d, want 401", got)
+	}
+	r := remote(http.MethodGet, "/api/status")
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: "made-up"})
+	if got := serve(h, r); got != http.StatusUnauthorized {
+		t.Errorf("remote with unknown session: status %d, want 401", got)
+	}
+	r = remote(http.MethodGet, "/api/status")
+	r.Header.Set("Authorization", "Bearer wrong-token")
+	if got := serve(h, r); got != http.StatusUnauthorized {
+		t.Errorf("remote with wrong token: status %d, want 401", got)
+	}
+
+	// The login page itself is served
+	if got := serve(h, remote(http.MethodGet, "/index.html")); got != http.StatusOK {
+		t.Errorf("remote static file: status %d, want 200", got)
+	}
+
+	// A session from a login is accepted, with its CSRF token for changes
+	login := httptest.NewRequest(http.MethodPost, "http://192.168.1.10:8319/api/login", strings.NewReader(`{"token":"`+testToken+`"}`))
+	login.RemoteAddr = "192.168.1.20:50000"
+	w := httptest.NewRecorder()
+	s.handleLogin(w, login)
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusOK || len(cookies) == 0 {
+		t.Fatalf("login failed: status %d", w.Code)
+	}
+	r = remote(http.MethodGet, "/api/status")
+	r.AddCookie(cookies[0])
+	if got := serve(h, r); got != http.StatusOK {
+		t.Errorf("remote with session: status %d, want 200", got)
+	}
+	r = remote(http.MethodPost, "/api/server/reload")
+	r.AddCookie(cookies[0])
+	r.Header.Set(csrfHeader, s.guard.csrf) // the local page token is not the session's
+	if got := serve(h, r); got != http.StatusForbidden {
+		t.Errorf("remote change with another CSRF token: status %d, want 403", got)
+	}
+}
+
+func TestProtectRequireLoginAppliesToLoopback(t *testing.T) {
+	_, h := newGuardedServer(config.UIAuthConfig{RequireLogin: true})
+	if got := serve(h, localRequest(http.MethodGet, "/api/status")); got != http.StatusUnauthorized {
+		t.Errorf("loopback with require-login: status %d, want 401", got)
+	}
+}
@@ -1,20 +1,134 @@
 // State
 let currentStatus = null;
+let csrfToken = null;
+let started = false;
+let isAdmin = true;
 
 // Initialize
-document.addEventListener('DOMContentLoaded', () => {
+document.addEventListener('DOMContentLoaded', async () => {
     setupEventListeners();
+    if (await loadSession()) {
+        start();
+    } else {
+        showLoginModal();
+    }
+});
+
+// Load everything and start polling once the page may use the API
+function start() {
+    if (started) return;
+    started = true;
+
+    // Team members only see the status and their own usage
+    for (const section of document.querySelectorAll('[data-admin]')) {
+        section.hidden = !isAdmin;
+    }
+
     loadStatus();
+    loadTeamUsage();
+    setInterval(loadStatus, 3000);
+    setInterval(loadTeamUsage, 3000);
+    if (!isAdmin) return;
+
     loadAutostartStatus();
+    loadRequests();
+    loadLogs();
+    loadConfig();
 
     // Poll for status updates every 3 seconds
-    setInterval(loadStatus, 3000);
-});
+    setInterval(loadRequests, 3000);
+    setInterval(loadLogs, 3000);
+
+    // Log filters
+    document.getElementById('log-level').addEventListener('change', loadLogs);
+    document.getElementById('log-component').addEventListener('change', loadLogs);
+}
+
+// Call the API, adding the CSRF token to changes and asking for a login
+// when the session has expired
+async function api(url, options = {}) {
+    const method = (options.method || 'GET').toUpperCase();
+    if (method !== 'GET' && method !== 'HEAD') {
+        options.headers = { ...options.headers, 'X-CSRF-Token': csrfToken || '' };
+    }
+    const response = await fetch(url, options);
+    if (response.status === 401) {
+        showLoginModal();
+    }
+    return response;
+}
+
+// Find out whether a login is needed and get the CSRF token
+async function loadSession() {
+    try {
+        const response = await fetch('/api/session');
+        if (!response.ok) throw new Error(await response.text());
+
+        const session = await response.json();
+        csrfToken = session.csrfToken || null;
+        isAdmin = session.role !== 'member';
+        document.getElementById('logout-btn').hidden = !(session.loginRequired && session.authenticated);
+        return session.authenticated;
+    } catch (error) {
+        console.error('Error loading session:', error);
+        return false;
+    }
+}
+
+// Exchange the token for a session
+async function handleLogin(e) {
+    e.preventDefault();
+    const token = document.getElementById('login-token-input').value.trim();
+    if (!token) return;
+
+    const btn = document.getElementById('login-btn');
+    btn.disabled = true;
+    try {
+        const response = await fetch('/api/login', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ token })
+        });
+        if (!response.ok) throw new Error(await response.text() || 'Login failed');
+
+        const data = await response.json();
+        csrfToken = data.csrfToken;
+        isAdmin = data.role !== 'member';
+        document.getElementById('login-modal').classList.remove('show');
+        document.getElementById('logout-btn').hidden = false;
+        start();
+    } catch (error) {
+        console.error('Error logging in:', error);
+        showToast(error.message || 'Login failed', 'error');
+    } finally {
+        btn.disabled = false;
+    }
+}
+
+// End the session
+async function handleLogout() {
+    await api('/api/logout', { method: 'POST' });
+    location.reload();
+}
+
+// Show the login modal
+function showLoginModal() {
+    const modal = document.getElementById('login-modal');
+    if (modal.classList.contains('show')) return;
+    modal.classList.add('show');
+    document.getElementById('login-token-input').value = '';
+    document.getElementById('login-token-input').focus();
+}
 
 // Setup event listeners
 function setupEventListeners() {
+    // Login
+    document.getElementById('login-form').addEventListener('submit', handleLogin);
+    document.getElementById('logout-btn').addEventListener('click', handleLogout);
+
     // Launch at login toggle
     document.getElementById('launch-at-login').addEventListener('change', handleLaunchAtLoginToggle);
+    document.getElementById('autostart-mechanism').addEventListener('change', handleAutostartMechanismChange);
 
     // Open folder button
     document.getElementById('open-folder-btn').addEventListener('click', handleOpenFolder);
@@ -35,12 +149,24 @@ function setupEventListeners() {
             hideQwenModal();
         }
     });
+
+    // Configuration form
+    document.getElementById('config-form').addEventListener('submit', handleConfigSave);
+    document.getElementById('config-rollback-btn').addEventListener('click', handleConfigRollback);
+
+    // Request detail modal
+    document.getElementById('request-close-btn').addEventListener('click', hideRequestModal);
+    document.getElementById('request-modal').addEventListener('click', (e) => {
+        if (e.target.id === 'request-modal') {
+            hideRequestModal();
+        }
+    });
 }
 
 // Load status from API
 async function loadStatus() {
     try {
-        const response = await fetch('/api/status');
+        const response = await api('/api/status');
         if (!response.ok) throw new Error('Failed to fetch status');
 
         currentStatus = await response.json();
@@ -54,16 +180,38 @@ async function loadStatus() {
 // Load autostart status
 async function loadAutostartStatus() {
     try {
-        const response = await fetch('/api/autostart/status');
+        const response = await api('/api/autostart');
         if (!response.ok) throw new Error('Failed to fetch autostart status');
 
-        const data = await response.json();
-        document.getElementById('launch-at-login').checked = data.enabled;
+        showAutostartStatus(await response.json());
     } catch (error) {
         console.error('Error loading autostart status:', error);
     }
 }
 
+// Show the active autostart mechanism, the systemd unit's state and hints
+function showAutostartStatus(data) {
+    document.getElementById('launch-at-login').checked = data.enabled;
+
+    const select = document.getElementById('autostart-mechanism');
+    for (const option of select.options) {
+        option.disabled = !data.available.includes(option.value);
+    }
+    if (data.mechanism) {
+        select.value = data.mechanism;
+    }
+
+    const lines = [];
+    if (data.unit) {
+        lines.push(`Unit ${data.unit.path}: ${data.unit.unitFileState || 'unknown'}, ${data.unit.activeState || 'unknown'} (${data.unit.subState || '-'})`);
+    }
+    lines.push(...(data.hints || []));
+
+    const info = document.getElementById('autostart-info');
+    info.textContent = lines.join('\n');
+    info.hidden = lines.length === 0;
+}
+
 // Update UI based on current status
 function updateUI() {
     if (!currentStatus) return;
@@ -85,6 +233,329 @@ function updateUI() {
     updateServiceUI('codex', currentStatus.services.codex);
     updateServiceUI('gemini', currentStatus.services.gemini);
     updateServiceUI('qwen', currentStatus.services.qwen);
+
+    updateLimitsUI(currentStatus.limits || []);
+}
+
+// Update rate limiter state
+function updateLimitsUI(limits) {
+    const card = document.getElementById('limits-card');
+    const list = document.getElementById('limits-list');
+
+    card.hidden = limits.length === 0;
+    list.innerHTML = '';
+
+    for (const limit of limits) {
+        const parts = [];
+        if (limit.requestsPerMinute) {
+            parts.push(`${limit.requestsAvailable}/${limit.requestsPerMinute} req/min`);
+        }
+        if (limit.tokensPerMinute) {
+            parts.push(`${limit.tokensAvailable}/${limit.tokensPerMinute} tokens/min`);
+        }
+        if (limit.maxConcurrent) {
+            parts.push(`${limit.inFlight}/${limit.maxConcurrent} in flight`);
+        }
+        if (limit.waiting) {
+            parts.push(`${limit.waiting} waiting`);
+        }
+        if (limit.rejected) {
+            parts.push(`${limit.rejected} rejected`);
+        }
+
+        const row = document.createElement('div');
+        row.className = 'limit-row';
+
+        const name = document.createElement('span');
+        name.className = 'limit-name';
+        name.textContent = limit.clientKey ? `${limit.rule} (${limit.clientKey})` : limit.rule;
+
+        const state = document.createElement('span');
+        state.className = 'limit-state';
+        state.textContent = parts.join(' · ');
+
+        row.append(name, state);
+        list.appendChild(row);
+    }
+}
+
+// Load each team member's usage today
+async function loadTeamUsage() {
+    try {
+        const response = await api('/api/team/usage');
+        if (!response.ok) throw new Error('Failed to fetch team usage');
+
+        updateTeamUI(await response.json());
+    } catch (error) {
+        console.error('Error loading team usage:', error);
+    }
+}
+
+// Update the team usage card
+function updateTeamUI(team) {
+    const card = document.getElementById('team-card');
+    const list = document.getElementById('team-list');
+
+    card.hidden = !team.enabled;
+    list.innerHTML = '';
+
+    for (const user of team.users) {
+        const tokens = user.inputTokens + user.outputTokens;
+        const parts = [
+            user.dailyRequests ? `${user.requests}/${user.dailyRequests} requests` : `${user.requests} requests`,
+            user.dailyTokens ? `${tokens}/${user.dailyTokens} tokens` : `${tokens} tokens`,
+        ];
+        if (user.rejected) {
+            parts.push(`${user.rejected} rejected`);
+        }
+        if (user.models && user.models.length) {
+            parts.push(user.models.join(', '));
+        }
+
+        const row = document.createElement('div');
+        row.className = 'limit-row';
+
+        const name = document.createElement('span');
+        name.className = 'limit-name';
+        name.textContent = user.role === 'admin' ? `${user.name} (admin)` : user.name;
+
+        const state = document.createElement('span');
+        state.className = 'limit-state';
+        state.textContent = parts.join(' · ');
+
+        row.append(name, state);
+        list.appendChild(row);
+    }
+}
+
+// Load recent proxy requests
+async function loadRequests() {
+    try {
+        const response = await api('/api/requests?limit=50');
+        if (!response.ok) throw new Error('Failed to fetch requests');
+
+        updateRequestsUI(await response.json());
+    } catch (error) {
+        console.error('Error loading requests:', error);
+    }
+}
+
+// Update the request list
+function updateRequestsUI(requests) {
+    const list = document.getElementById('requests-list');
+    list.innerHTML = '';
+
+    if (requests.length === 0) {
+        const empty = document.createElement('div');
+        empty.className = 'requests-empty';
+        empty.textContent = 'No requests yet';
+        list.appendChild(empty);
+        return;
+    }
+
+    for (const req of requests) {
+        const row = document.createElement('div');
+        row.className = 'request-row';
+        row.addEventListener('click', () => showRequestDetail(req.id));
+
+        const time = document.createElement('span');
+        time.className = 'request-time';
+        time.textContent = new Date(req.time).toLocaleTimeString();
+
+        const model = document.createElement('span');
+        model.className = 'request-model';
+        model.textContent = describeModel(req);
+
+        const status = document.createElement('span');
+        status.className = req.status >= 200 && req.status < 300 ? 'request-status ok' : 'request-status error';
+        status.textContent = req.source ? `${req.status} ${req.source}` : `${req.status}`;
+
+        const timing = document.createElement('span');
+        timing.className = 'request-timing';
+        timing.textContent = describeTiming(req);
+
+        row.append(time, model, status, timing);
+        list.appendChild(row);
+    }
+}
+
+// Load recent log entries matching the selected filters
+async function loadLogs() {
+    const params = new URLSearchParams({
+        limit: 200,
+        level: document.getElementById('log-level').value,
+    });
+    const component = document.getElementById('log-component').value;
+    if (component) {
+        params.set('component', component);
+    }
+
+    try {
+        const response = await api(`/api/logs?${params}`);
+        if (!response.ok) throw new Error('Failed to fetch logs');
+
+        updateLogsUI(await response.json());
+    } catch (error) {
+        console.error('Error loading logs:', error);
+    }
+}
+
+// Update the log list, newest first
+function updateLogsUI(entries) {
+    const list = document.getElementById('logs-list');
+    list.innerHTML = '';
+
+    if (entries.length === 0) {
+        const empty = document.createElement('div');
+        empty.className = 'logs-empty';
+        empty.textContent = 'No log entries';
+        list.appendChild(empty);
+        return;
+    }
+
+    for (const entry of entries.reverse()) {
+        const row = document.createElement('div');
+        row.className = `log-row log-${entry.level.toLowerCase()}`;
+
+        const time = document.createElement('span');
+        time.className = 'log-time';
+        time.textContent = new Date(entry.time).toLocaleTimeString();
+
+        const level = document.createElement('span');
+        level.className = 'log-level';
+        level.textContent = entry.level;
+
+        const component = document.createElement('span');
+        component.className = 'log-component';
+        component.textContent = entry.component || '';
+
+        const message = document.createElement('span');
+        message.className = 'log-message';
+        message.textContent = describeLogEntry(entry);
+
+        row.append(time, level, component, message);
+        list.appendChild(row);
+    }
+}
+
+// Append an entry's attributes to its message as key=value pairs
+function describeLogEntry(entry) {
+    const attrs = Object.entries(entry.attrs || {})
+        .map(([key, value]) => `${key}=${typeof value === 'object' ? JSON.stringify(value) : value}`);
+    return [entry.message, ...attrs].join(' ');
+}
+
+// Describe the requested and forwarded model
+function describeModel(req) {
+    let text = req.model || req.path;
+    if (req.backendModel && req.backendModel !== req.model) {
+        text += ` → ${req.backendModel}`;
+    }
+    if (req.thinkingBudget) {
+        text += ` (thinking ${req.thinkingBudget})`;
+    }
+    return text;
+}
+
+// Describe latency, time to first token and token usage
+function describeTiming(req) {
+    const parts = [`${req.latencyMs} ms`];
+    if (req.ttftMs) {
+        parts.push(`TTFT ${req.ttftMs} ms`);
+    }
+    if (req.usage.inputTokens || req.usage.outputTokens) {
+        parts.push(`${req.usage.inputTokens}/${req.usage.outputTokens} tok`);
+    }
+    return parts.join(' · ');
+}
+
+// Show one request with the diff of its original and transformed body
+async function showRequestDetail(id) {
+    try {
+        const response = await api(`/api/requests/${id}`);
+        if (!response.ok) throw new Error('Failed to fetch request');
+
+        const req = await response.json();
+        document.getElementById('request-title').textContent = `${req.method} ${req.path}`;
+
+        const meta = [`Client ${req.client}`];
+        if (req.clientKey) meta.push(`key ${req.clientKey}`);
+        if (req.accountHint) meta.push(`account hint ${req.accountHint} (advisory)`);
+        meta.push(describeModel(req), `status ${req.status}`, describeTiming(req));
+        document.getElementById('request-meta').textContent = meta.join(' · ');
+
+        renderDiff(document.getElementById('request-diff'), req.requestBody || '', req.transformedBody || '');
+        document.getElementById('request-modal').classList.add('show');
+    } catch (error) {
+        console.error('Error loading request:', error);
+        showToast('Failed to load request', 'error');
+    }
+}
+
+// Hide request detail modal
+function hideRequestModal() {
+    document.getElementById('request-modal').classList.remove('show');
+}
+
+// Render a line diff of two bodies
+function renderDiff(container, before, after) {
+    container.innerHTML = '';
+
+    if (!before && !after) {
+        container.textContent = 'Request bodies are not kept (inspector.bodies is off)';
+        return;
+    }
+
+    for (const [kind, text] of diffLines(before.split('\n'), after.split('\n'))) {
+        const line = document.createElement('div');
+        line.className = `diff-line diff-${kind}`;
+        line.textContent = (kind === 'add' ? '+ ' : kind === 'del' ? '- ' : '  ') + text;
+        container.appendChild(line);
+    }
+}
+
+// Compute a line diff as [kind, text] pairs using the longest common subsequence
+function diffLines(a, b) {
+    let start = 0;
+    while (start < a.length && start < b.length && a[start] === b[start]) start++;
+    let endA = a.length;
+    let endB = b.length;
+    while (endA > start && endB > start && a[endA - 1] === b[endB - 1]) {
+        endA--;
+        endB--;
+    }
+
+    const result = a.slice(0, start).map(line => ['same', line]);
+    const midA = a.slice(start, endA);
+    const midB = b.slice(start, endB);
+
+    if (midA.length * midB.length > 4000000) {
+        // Too large to align; show the changed region as replaced
+        midA.forEach(line => result.push(['del', line]));
+        midB.forEach(line => result.push(['add', line]));
+    } else {
+        const lcs = Array.from({ length: midA.length + 1 }, () => new Uint32Array(midB.length + 1));
+        for (let i = midA.length - 1; i >= 0; i--) {
+            for (let j = midB.length - 1; j >= 0; j--) {
+                lcs[i][j] = midA[i] === midB[j] ? lcs[i + 1][j + 1] + 1 : Math.max(lcs[i + 1][j], lcs[i][j + 1]);
+            }
+        }
+        let i = 0;
+        let j = 0;
+        while (i < midA.length || j < midB.length) {
+            if (i < midA.length && j < midB.length && midA[i] === midB[j]) {
+                result.push(['same', midA[i++]]);
+                j++;
+            } else if (j < midB.length && (i === midA.length || lcs[i][j + 1] >= lcs[i + 1][j])) {
+                result.push(['add', midB[j++]]);
+            } else {
+                result.push(['del', midA[i++]]);
+            }
+        }
+    }
+
+    a.slice(endA).forEach(line => result.push(['same', line]));
+    return result;
 }
 
 // Update individual service UI
@@ -137,7 +608,7 @@ async function handleConnect(service, btn) {
     btn.disabled = true;
 
     try {
-        const response = await fetch('/api/auth/connect', {
+        const response = await api('/api/auth/connect', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({ service })
@@ -174,7 +645,7 @@ async function handleDisconnect(service, btn) {
     btn.disabled = true;
 
     try {
-        const response = await fetch('/api/auth/disconnect', {
+        const response = await api('/api/auth/disconnect', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({ service })
@@ -200,18 +671,32 @@ async function handleDisconnect(service, btn) {
 async function handleLaunchAtLoginToggle(e) {
     const enabled = e.target.checked;
     const endpoint = enabled ? '/api/autostart/enable' : '/api/autostart/disable';
+    const mechanism = document.getElementById('autostart-mechanism').value;
 
     try {
-        const response = await fetch(endpoint, { method: 'POST' });
-        if (!response.ok) throw new Error('Failed to update autostart');
+        const response = await api(endpoint, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: enabled ? JSON.stringify({ mechanism }) : undefined
+        });
+        if (!response.ok) throw new Error(await response.text() || 'Failed to update autostart');
 
         showToast(`Autostart ${enabled ? 'enabled' : 'disabled'}`, 'success');
     } catch (error) {
         console.error('Error updating autostart:', error);
-        showToast('Failed to update autostart', 'error');
+        showToast(error.message || 'Failed to update autostart', 'error');
         // Revert toggle
         e.target.checked = !enabled;
     }
+    loadAutostartStatus();
+}
+
+// Switch the autostart mechanism while autostart is enabled
+function handleAutostartMechanismChange() {
+    const toggle = document.getElementById('launch-at-login');
+    if (toggle.checked) {
+        handleLaunchAtLoginToggle({ target: toggle });
+    }
 }
 
 // Handle open folder
@@ -219,6 +704,127 @@ function handleOpenFolder() {
     showToast('Open your file manager and navigate to ~/.cli-proxy-api/', 'success');
 }
 
+// Load config.yaml and vibeproxy.yaml into the configuration form
+async function loadConfig() {
+    try {
+        const response = await api('/api/config');
+        if (!response.ok) throw new Error(await response.text());
+
+        const config = await response.json();
+        for (const field of document.querySelectorAll('#config-form [data-key]')) {
+            const values = config[field.dataset.file];
+            field.disabled = !values;
+            if (!values) continue;
+
+            const value = field.dataset.key.split('.').reduce((obj, key) => obj?.[key], values);
+            if (field.type === 'checkbox') {
+                field.checked = Boolean(value);
+            } else {
+                field.value = value ?? '';
+            }
+        }
+        const backups = config.backups || {};
+        document.getElementById('config-rollback-btn').disabled = !backups.backend && !backups.settings;
+    } catch (error) {
+        console.error('Error loading config:', error);
+        showConfigError(error.message);
+    }
+}
+
+// Collect the form into {backend: {...}, settings: {...}}, nested like the YAML
+function readConfigForm() {
+    const changes = {};
+    for (const field of document.querySelectorAll('#config-form [data-key]')) {
+        if (field.disabled) continue;
+
+        let value = field.value.trim();
+        if (field.type === 'checkbox') {
+            value = field.checked;
+        } else if (field.type === 'number') {
+            value = Number(value);
+        }
+
+        const keys = field.dataset.key.split('.');
+        let target = changes[field.dataset.file] ??= {};
+        for (const key of keys.slice(0, -1)) {
+            target = target[key] ??= {};
+        }
+        target[keys[keys.length - 1]] = value;
+    }
+    return changes;
+}
+
+// Check the fields the browser can validate before sending them
+function validateConfigForm() {
+    for (const field of document.querySelectorAll('#config-form [data-key]')) {
+        if (!field.checkValidity()) {
+            const label = document.querySelector(`label[for="${field.id}"]`);
+            return `${label ? label.textContent : field.dataset.key}: ${field.validationMessage}`;
+        }
+    }
+
+    const proxyURL = document.getElementById('cfg-proxy-url').value.trim();
+    if (proxyURL && !/^(https?|socks5):\/\/[^/]+/.test(proxyURL)) {
+        return 'Upstream proxy URL must start with http://, https:// or socks5:// and name a host';
+    }
+    return null;
+}
+
+// Show or clear the validation message under the form
+function showConfigError(message) {
+    const error = document.getElementById('config-error');
+    error.textContent = message || '';
+    error.hidden = !message;
+}
+
+// Save the configuration form; the backend restarts if config.yaml changed
+async function handleConfigSave(e) {
+    e.preventDefault();
+
+    const invalid = validateConfigForm();
+    showConfigError(invalid);
+    if (invalid) return;
+
+    const btn = document.getElementById('config-save-btn');
+    btn.disabled = true;
+    btn.textContent = 'Saving...';
+
+    try {
+        const response = await api('/api/config', {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(readConfigForm())
+        });
+        if (!response.ok) throw new Error(await response.text());
+
+        const data = await response.json();
+        showToast(data.restarted ? 'Configuration saved, backend restarted' : 'Configuration saved', 'success');
+    } catch (error) {
+        console.error('Error saving config:', error);
+        showConfigError(error.message);
+    } finally {
+        btn.disabled = false;
+        btn.textContent = 'Save';
+        loadConfig();
+    }
+}
+
+// Put the previous config.yaml and vibeproxy.yaml back
+async function handleConfigRollback() {
+    try {
+        const response = await api('/api/config/rollback', { method: 'POST' });
+        if (!response.ok) throw new Error(await response.text());
+
+        showConfigError(null);
+        showToast('Previous configuration restored', 'success');
+    } catch (error) {
+        console.error('Error rolling back config:', error);
+        showConfigError(error.message);
+    } finally {
+        loadConfig();
+    }
+}
+
 // Show Qwen email modal
 function showQwenModal() {
     document.getElementById('qwen-modal').classList.add('show');
@@ -255,7 +861,7 @@ async function handleQwenContinue() {
     btn.disabled = true;
 
     try {
-        const response = await fetch('/api/auth/connect', {
+        const response = await api('/api/auth/connect', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({ service: 'qwen', email })
@@ -1,41 +1,75 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
-	"os"
 	"os/exec"
-	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/automazeio/vibeproxy/internal/auth"
+	"github.com/automazeio/vibeproxy/internal/autostart"
+	"github.com/automazeio/vibeproxy/internal/config"
+	"github.com/automazeio/vibeproxy/internal/listen"
+	"github.com/automazeio/vibeproxy/internal/logging"
+	"github.com/automazeio/vibeproxy/internal/metrics"
 	"github.com/automazeio/vibeproxy/internal/process"
+	"github.com/automazeio/vibeproxy/internal/proxy"
+	"github.com/automazeio/vibeproxy/internal/version"
 )
 
 //go:embed static/*
 var staticFiles embed.FS
 
+var logger = logging.Component("UIServer")
+
 // UIServer serves the web UI
 type UIServer struct {
 	port           int
+	listen         config.ListenerConfig
+	tlsConfig      *tls.Config
 	authManager    *auth.Manager
 	processManager *process.Manager
+	thinkingProxy  *proxy.ThinkingProxy
+	metrics        *metrics.Registry
+	autostart      *autostart.Manager
+	guard          *uiGuard
 	mux            *http.ServeMux
+	server         *http.Server
+
+	configMu      sync.Mutex // serializes config edits
+	settingsPath  string
+	applySettings func(*config.Settings) error
 }
 
 // NewUIServer creates a new UI server
-func NewUIServer(port int, authMgr *auth.Manager, procMgr *process.Manager) *UIServer {
+func NewUIServer(port int, authMgr *auth.Manager, procMgr *process.Manager, thinkingProxy *proxy.ThinkingProxy) *UIServer {
 	s := &UIServer{
 		port:           port,
 		authManager:    authMgr,
 		processManager: procMgr,
+		thinkingProxy:  thinkingProxy,
+		metrics:        metrics.NewRegistry(),
+		autostart:      autostart.NewManager(autostart.Config{}, nil),
+		listen:         config.DefaultSettings().Listen.UI,
+		guard:          newUIGuard(config.DefaultSettings().UIAuth, ""),
 		mux:            http.NewServeMux(),
 	}
+	s.server = &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: s.protect(s.mux), ConnContext: markUnixConn}
 
+	thinkingProxy.RegisterMetrics(s.metrics)
+	s.registerMetrics()
 	s.setupRoutes()
 	return s
 }
@@ -43,30 +77,74 @@ func NewUIServer(port int, authMgr *auth.Manager, procMgr *process.Manager) *UIS
 // setupRoutes configures all HTTP routes
 func (s *UIServer) setupRoutes() {
 	// API routes
+	s.mux.HandleFunc("/api/session", s.handleSession)
+	s.mux.HandleFunc("/api/login", s.handleLogin)
+	s.mux.HandleFunc("/api/logout", s.handleLogout)
 	s.mux.HandleFunc("/api/status", s.handleStatus)
 	s.mux.HandleFunc("/api/auth/connect", s.handleConnect)
 	s.mux.HandleFunc("/api/auth/disconnect", s.handleDisconnect)
 	s.mux.HandleFunc("/api/server/start", s.handleServerStart)
 	s.mux.HandleFunc("/api/server/stop", s.handleServerStop)
+	s.mux.HandleFunc("/api/server/reload", s.handleServerReload)
+	s.mux.HandleFunc("/api/autostart", s.handleAutostartStatus)
 	s.mux.HandleFunc("/api/autostart/enable", s.handleAutostartEnable)
 	s.mux.HandleFunc("/api/autostart/disable", s.handleAutostartDisable)
 	s.mux.HandleFunc("/api/autostart/status", s.handleAutostartStatus)
+	s.mux.HandleFunc("/api/autostart/unit", s.handleAutostartUnit)
+	s.mux.HandleFunc("/api/team/usage", s.handleTeamUsage)
+	s.mux.HandleFunc("/api/response-cache", s.handleResponseCache)
+	s.mux.HandleFunc("/api/response-cache/clear", s.handleResponseCacheClear)
+	s.mux.HandleFunc("/api/requests", s.handleRequests)
+	s.mux.HandleFunc("/api/requests/", s.handleRequestDetail)
+	s.mux.HandleFunc("/api/logs", s.handleLogs)
+	s.mux.HandleFunc("/api/config", s.handleConfig)
+	s.mux.HandleFunc("/api/config/rollback", s.handleConfigRollback)
+
+	// Prometheus metrics
+	s.mux.Handle("/metrics", s.metrics.Handler())
 
 	// Static files
 	s.mux.Handle("/", http.FileServer(http.FS(staticFiles)))
 }
 
+// SetListen sets the TCP port and Unix socket Start listens on
+func (s *UIServer) SetListen(cfg config.ListenerConfig) {
+	s.listen = cfg
+}
+
+// SetTLS sets the certificate served when the listener has tls enabled
+func (s *UIServer) SetTLS(tlsConfig *tls.Config) {
+	s.tlsConfig = tlsConfig
+}
+
 // Start starts the UI server
 func (s *UIServer) Start() error {
-	log.Printf("[UIServer] Starting on port %d", s.port)
-	go func() {
-		if err := http.ListenAndServe(fmt.Sprintf(":%d", s.port), s.mux); err != nil {
-			log.Printf("[UIServer] Server error: %v", err)
-		}
-	}()
+	listeners, err := listen.Open(s.listen, s.port, s.tlsConfig)
+	if err != nil {
+		return fmt.Errorf("failed to start listener: %w", err)
+	}
+	logger.Info("Listening", "addresses", listen.Addrs(listeners))
+
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				logger.Error("Server error", "error", err)
+			}
+		}(listener)
+	}
 	return nil
 }
 
+// Shutdown stops the UI server, letting in-flight API calls finish until
+// ctx expires
+func (s *UIServer) Shutdown(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	if err != nil {
+		s.server.Close()
+	}
+	return err
+}
+
 // handleStatus returns the current status of all services
 func (s *UIServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -76,14 +154,19 @@ func (s *UIServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 
 	// Refresh auth status
 	if err := s.authManager.CheckAuthStatus(); err != nil {
-		log.Printf("[UIServer] Error checking auth status: %v", err)
+		logger.Error("Failed to check auth status", "error", err)
 	}
 
 	status := map[string]interface{}{
 		"services": s.authManager.GetStatus(),
+		"accounts": s.authManager.Accounts(),
 		"server": map[string]interface{}{
 			"running": s.processManager.IsRunning() && s.processManager.HealthCheck(),
 		},
+		"limits":      s.thinkingProxy.RateLimitStatus(),
+		"cooldowns":   s.thinkingProxy.Cooldowns(),
+		"promptCache": s.thinkingProxy.PromptCacheStats(),
+		"version":     version.Version,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -107,24 +190,15 @@ func (s *UIServer) handleConnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var cmd process.AuthCommand
-	switch strings.ToLower(req.Service) {
-	case "claude":
-		cmd = process.ClaudeLogin
-	case "codex":
-		cmd = process.CodexLogin
-	case "gemini":
-		cmd = process.GeminiLogin
-	case "qwen":
-		cmd = process.QwenLogin
-		if req.Email == "" {
-			http.Error(w, "Email required for Qwen", http.StatusBadRequest)
-			return
-		}
-	default:
+	cmd, err := process.ParseAuthCommand(req.Service)
+	if err != nil {
 		http.Error(w, "Unknown service", http.StatusBadRequest)
 		return
 	}
+	if cmd == process.QwenLogin && req.Email == "" {
+		http.Error(w, "Email required for Qwen", http.StatusBadRequest)
+		return
+	}
 
 	success, message, err := s.processManager.RunAuthCommand(cmd, req.Email)
 
@@ -150,6 +224,7 @@ func (s *UIServer) handleDisconnect(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		Service string `json:"service"`
+		Account string `json:"account,omitempty"` // ID or email; the first account when empty
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -157,56 +232,13 @@ func (s *UIServer) handleDisconnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		http.Error(w, "Failed to get home directory", http.StatusInternalServerError)
+	account, err := s.authManager.RemoveAccount(strings.ToLower(req.Service), req.Account)
+	if errors.Is(err, auth.ErrAccountNotFound) {
+		http.Error(w, "Auth file not found", http.StatusNotFound)
 		return
 	}
-
-	authDir := filepath.Join(homeDir, ".cli-proxy-api")
-	serviceType := strings.ToLower(req.Service)
-
-	// Find and delete the auth file
-	files, err := os.ReadDir(authDir)
 	if err != nil {
-		http.Error(w, "Failed to read auth directory", http.StatusInternalServerError)
-		return
-	}
-
-	found := false
-	for _, file := range files {
-		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
-			continue
-		}
-
-		filePath := filepath.Join(authDir, file.Name())
-		data, err := os.ReadFile(filePath)
-		if err != nil {
-			continue
-		}
-
-		var authData map[string]interface{}
-		if err := json.Unmarshal(data, &authData); err != nil {
-			continue
-		}
-
-		fileType, ok := authData["type"].(string)
-		if !ok || strings.ToLower(fileType) != serviceType {
-			continue
-		}
-
-		// Found the file - delete it
-		if err := os.Remove(filePath); err != nil {
-			http.Error(w, "Failed to delete auth file", http.StatusInternalServerError)
-			return
-		}
-
-		found = true
-		break
-	}
-
-	if !found {
-		http.Error(w, "Auth file not found", http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -214,6 +246,7 @@ func (s *UIServer) handleDisconnect(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
 		"message": fmt.Sprintf("%s disconnected successfully", req.Service),
+		"account": account,
 	})
 }
 
@@ -253,14 +286,14 @@ func (s *UIServer) handleServerStop(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// handleAutostartEnable enables autostart
-func (s *UIServer) handleAutostartEnable(w http.ResponseWriter, r *http.Request) {
+// handleServerReload restarts CLIProxyAPI without dropping requests
+func (s *UIServer) handleServerReload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if err := enableAutostart(); err != nil {
+	if err := s.processManager.Restart(); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -268,109 +301,221 @@ func (s *UIServer) handleAutostartEnable(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
+		"port":    s.processManager.Port(),
 	})
 }
 
-// handleAutostartDisable disables autostart
-func (s *UIServer) handleAutostartDisable(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+// handleTeamUsage returns each team member's usage today; members only
+// see their own
+func (s *UIServer) handleTeamUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if err := disableAutostart(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	usage := s.thinkingProxy.TeamUsage()
+	if caller := callerOf(r); !caller.admin {
+		usage = slices.DeleteFunc(usage, func(u proxy.UserUsage) bool { return u.Name != caller.user })
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
+		"enabled": s.thinkingProxy.TeamEnabled(),
+		"users":   usage,
 	})
 }
 
-// handleAutostartStatus returns autostart status
-func (s *UIServer) handleAutostartStatus(w http.ResponseWriter, r *http.Request) {
+// handleResponseCache returns response cache statistics
+func (s *UIServer) handleResponseCache(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	enabled := isAutostartEnabled()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.thinkingProxy.ResponseCacheStats())
+}
+
+// handleResponseCacheClear drops all cached responses
+func (s *UIServer) handleResponseCacheClear(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.thinkingProxy.ClearResponseCache()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"enabled": enabled,
+		"success": true,
 	})
 }
 
-// enableAutostart enables the application to start on boot
-func enableAutostart() error {
-	if runtime.GOOS != "linux" {
-		return fmt.Errorf("autostart only supported on Linux")
+// handleRequests lists recent proxy exchanges, newest first
+func (s *UIServer) handleRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
 	}
 
-	homeDir, err := os.UserHomeDir()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.thinkingProxy.RecentRequests(limit))
+}
+
+// handleRequestDetail returns one exchange with its original and transformed bodies
+func (s *UIServer) handleRequestDetail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/requests/"), 10, 64)
 	if err != nil {
-		return err
+		http.Error(w, "Invalid request id", http.StatusBadRequest)
+		return
+	}
+
+	record, ok := s.thinkingProxy.RequestDetail(id)
+	if !ok {
+		http.Error(w, "Request not found", http.StatusNotFound)
+		return
 	}
 
-	autostartDir := filepath.Join(homeDir, ".config", "autostart")
-	if err := os.MkdirAll(autostartDir, 0755); err != nil {
-		return err
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
+}
+
+// handleLogs returns recent log entries, filtered by ?level= and ?component=;
+// ?after= returns only entries newer than that sequence number
+func (s *UIServer) handleLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	execPath, err := os.Executable()
+	query := r.URL.Query()
+	limit := 500
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	level, err := logging.ParseLevel(query.Get("level"))
 	if err != nil {
-		return err
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.Get("level") == "" {
+		level = slog.LevelDebug
+	}
+	var after uint64
+	if value := query.Get("after"); value != "" {
+		if after, err = strconv.ParseUint(value, 10, 64); err != nil {
+			http.Error(w, "Invalid after", http.StatusBadRequest)
+			return
+		}
 	}
 
-	desktopEntry := fmt.Sprintf(`[Desktop Entry]
-Type=Application
-Name=VibeProxy
-Exec=%s
-Hidden=false
-NoDisplay=false
-X-GNOME-Autostart-enabled=true
-`, execPath)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logging.Recent(limit, level, query.Get("component"), after))
+}
 
-	desktopFile := filepath.Join(autostartDir, "vibeproxy.desktop")
-	return os.WriteFile(desktopFile, []byte(desktopEntry), 0644)
+// SetAutostart replaces the autostart manager, e.g. to pin the config
+// directory the autostarted instance uses
+func (s *UIServer) SetAutostart(m *autostart.Manager) {
+	s.autostart = m
 }
 
-// disableAutostart disables autostart
-func disableAutostart() error {
-	if runtime.GOOS != "linux" {
-		return fmt.Errorf("autostart only supported on Linux")
+// handleAutostartEnable enables autostart with the mechanism in the optional
+// body, {"mechanism": "desktop"|"systemd"}
+func (s *UIServer) handleAutostartEnable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Mechanism string `json:"mechanism"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
 	}
 
-	homeDir, err := os.UserHomeDir()
+	status, err := s.autostart.Enable(req.Mechanism)
 	if err != nil {
-		return err
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"mechanism": status.Mechanism,
+		"status":    status,
+	})
+}
+
+// handleAutostartDisable disables autostart
+func (s *UIServer) handleAutostartDisable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	desktopFile := filepath.Join(homeDir, ".config", "autostart", "vibeproxy.desktop")
-	if err := os.Remove(desktopFile); err != nil && !os.IsNotExist(err) {
-		return err
+	if err := s.autostart.Disable(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	return nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
 }
 
-// isAutostartEnabled checks if autostart is enabled
-func isAutostartEnabled() bool {
-	if runtime.GOOS != "linux" {
-		return false
+// handleAutostartStatus returns which autostart mechanism is active and the
+// systemd unit's state
+func (s *UIServer) handleAutostartStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	homeDir, err := os.UserHomeDir()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.autostart.Status())
+}
+
+// handleAutostartUnit returns the systemd unit autostart would install
+func (s *UIServer) handleAutostartUnit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	unit, err := s.autostart.Unit()
 	if err != nil {
-		return false
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	desktopFile := filepath.Join(homeDir, ".config", "autostart", "vibeproxy.desktop")
-	_, err = os.Stat(desktopFile)
-	return err == nil
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, unit)
 }
 
 // OpenBrowser opens the default browser to the UI
@@ -0,0 +1,203 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/automazeio/vibeproxy/internal/logging"
+)
+
+var logger = logging.Component("Tracing")
+
+const (
+	queueSize     = 2048
+	batchSize     = 256
+	flushInterval = 5 * time.Second
+)
+
+// exporter batches finished spans and posts them to an OTLP/HTTP endpoint
+type exporter struct {
+	endpoint    string
+	serviceName string
+	headers     map[string]string
+	client      *http.Client
+
+	queue   chan *Span
+	done    chan struct{}
+	stopped chan struct{}
+	failing bool
+}
+
+// newExporter starts the export loop
+func newExporter(endpoint, serviceName string, headers map[string]string) *exporter {
+	e := &exporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		headers:     headers,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan *Span, queueSize),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// enqueue adds a span without blocking; spans are dropped when the queue is full
+func (e *exporter) enqueue(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+	}
+}
+
+// run sends a batch when it is full or the flush interval passes
+func (e *exporter) run() {
+	defer close(e.stopped)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				e.send(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				e.send(batch)
+				batch = batch[:0]
+			}
+		case <-e.done:
+			for {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+				default:
+					if len(batch) > 0 {
+						e.send(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// shutdown flushes queued spans, waiting at most timeout
+func (e *exporter) shutdown(timeout time.Duration) {
+	close(e.done)
+	select {
+	case <-e.stopped:
+	case <-time.After(timeout):
+		logger.Warn("Timed out flushing spans")
+	}
+}
+
+// send posts one batch, logging only the first of consecutive failures
+func (e *exporter) send(batch []*Span) {
+	body, err := json.Marshal(e.payload(batch))
+	if err != nil {
+		logger.Error("Failed to encode spans", "error", err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		logger.Error("Invalid endpoint", "endpoint", e.endpoint, "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("collector returned %s", resp.Status)
+		}
+	}
+	if err != nil {
+		if !e.failing {
+			logger.Warn("Failed to export spans", "spans", len(batch), "endpoint", e.endpoint, "error", err)
+		}
+		e.failing = true
+		return
+	}
+	if e.failing {
+		logger.Info("Export recovered", "endpoint", e.endpoint)
+	}
+	e.failing = false
+}
+
+// payload builds an OTLP ExportTraceServiceRequest in its JSON mapping
+func (e *exporter) payload(batch []*Span) map[string]interface{} {
+	spans := make([]map[string]interface{}, 0, len(batch))
+	for _, span := range batch {
+		spans = append(spans, span.otlp())
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []interface{}{
+						map[string]interface{}{"key": "service.name", "value": attributeValue(e.serviceName)},
+					},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "github.com/automazeio/vibeproxy"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+}
+
+// otlp converts a finished span to its OTLP/JSON form
+func (s *Span) otlp() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.attributes))
+	for key := range s.attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attributes := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		attributes = append(attributes, map[string]interface{}{"key": key, "value": attributeValue(s.attributes[key])})
+	}
+
+	status := map[string]interface{}{"code": 1} // OK
+	if s.err != "" {
+		status = map[string]interface{}{"code": 2, "message": s.err} // ERROR
+	}
+
+	span := map[string]interface{}{
+		"traceId":           s.context.TraceID.String(),
+		"spanId":            s.context.SpanID.String(),
+		"name":              s.name,
+		"kind":              int(s.kind),
+		"startTimeUnixNano": fmt.Sprint(s.start.UnixNano()),
+		"endTimeUnixNano":   fmt.Sprint(s.end.UnixNano()),
+		"attributes":        attributes,
+		"status":            status,
+	}
+	if s.parent != (SpanID{}) {
+		span["parentSpanId"] = s.parent.String()
+	}
+	return span
+}
@@ -0,0 +1,249 @@
+// Package tracing records request spans and exports them to an OpenTelemetry
+// collector using OTLP/HTTP with JSON encoding. Trace and span IDs are always
+// generated so they can be logged and propagated; spans are only exported
+// when an endpoint is configured.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanKind follows the OTLP span kinds
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// String returns the lowercase hex form used by traceparent and OTLP/JSON
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the lowercase hex form used by traceparent and OTLP/JSON
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is the part of a span that crosses process boundaries
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether the context carries non-zero IDs
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the context as a W3C traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value
+func ParseTraceparent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	return sc, sc.IsValid()
+}
+
+// Span is one timed operation
+type Span struct {
+	tracer *Tracer
+
+	mu         sync.Mutex
+	context    SpanContext
+	parent     SpanID
+	name       string
+	kind       SpanKind
+	start      time.Time
+	end        time.Time
+	attributes map[string]interface{}
+	err        string
+	ended      bool
+}
+
+// Context returns the span's propagation context
+func (s *Span) Context() SpanContext {
+	return s.context
+}
+
+// TraceID returns the hex trace ID for logs and response headers
+func (s *Span) TraceID() string {
+	return s.context.TraceID.String()
+}
+
+// StartChild begins a span below s
+func (s *Span) StartChild(name string, kind SpanKind) *Span {
+	return &Span{
+		tracer:  s.tracer,
+		context: SpanContext{TraceID: s.context.TraceID, SpanID: newSpanID(), Sampled: s.context.Sampled},
+		parent:  s.context.SpanID,
+		name:    name,
+		kind:    kind,
+		start:   time.Now(),
+	}
+}
+
+// SetAttribute records a string, bool, integer or float attribute
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = make(map[string]interface{})
+	}
+	s.attributes[key] = value
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = message
+}
+
+// End finishes the span and queues it for export. Calling End again is a no-op.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.context.Sampled {
+		s.tracer.export(s)
+	}
+}
+
+// Tracer creates spans and hands finished ones to the exporter
+type Tracer struct {
+	exporter    *exporter
+	sampleRatio float64
+}
+
+// NewTracer creates a tracer that exports to endpoint; an empty endpoint
+// disables export
+func NewTracer(endpoint, serviceName string, headers map[string]string, sampleRatio float64) *Tracer {
+	t := &Tracer{sampleRatio: sampleRatio}
+	if endpoint != "" {
+		t.exporter = newExporter(endpoint, serviceName, headers)
+	}
+	return t
+}
+
+// Enabled reports whether spans are exported
+func (t *Tracer) Enabled() bool {
+	return t != nil && t.exporter != nil
+}
+
+// Start begins a root span, continuing the remote trace in parent if valid
+func (t *Tracer) Start(name string, kind SpanKind, parent SpanContext) *Span {
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent.IsValid() {
+		span.context = SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: parent.Sampled}
+		span.parent = parent.SpanID
+		return span
+	}
+
+	span.context = SpanContext{TraceID: newTraceID(), SpanID: newSpanID()}
+	span.context.Sampled = t.Enabled() && t.sample(span.context.TraceID)
+	return span
+}
+
+// Shutdown exports queued spans and stops the exporter
+func (t *Tracer) Shutdown(timeout time.Duration) {
+	if t.Enabled() {
+		t.exporter.shutdown(timeout)
+	}
+}
+
+// sample decides from the trace ID so every hop agrees
+func (t *Tracer) sample(id TraceID) bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	if t.sampleRatio <= 0 {
+		return false
+	}
+	return float64(binary.BigEndian.Uint64(id[8:])>>11) < t.sampleRatio*float64(uint64(1)<<53)
+}
+
+// export queues a finished span
+func (t *Tracer) export(span *Span) {
+	if t.Enabled() {
+		t.exporter.enqueue(span)
+	}
+}
+
+// newTraceID returns a random trace ID
+func newTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:])
+	return id
+}
+
+// newSpanID returns a random span ID
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
+
+// attributeValue converts a Go value to an OTLP AnyValue
+func attributeValue(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int:
+		return map[string]interface{}{"intValue": fmt.Sprint(v)}
+	case int64:
+		return map[string]interface{}{"intValue": fmt.Sprint(v)}
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		return map[string]interface{}{"doubleValue": v}
+	default:
+		return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+	}
+}
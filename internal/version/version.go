@@ -0,0 +1,6 @@
+// Package version holds the VibeProxy version, set at build time with
+// -ldflags "-X github.com/automazeio/vibeproxy/internal/version.Version=..."
+package version
+
+// Version is the release this binary was built from
+var Version = "dev"